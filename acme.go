@@ -31,6 +31,12 @@ var (
 	winsize           = flag.String("W", "1024x768", "Window size and position as WidthxHeight[@X,Y]")
 	ncol              = flag.Int("c", 2, "Number of columns at startup")
 	loadfile          = flag.String("l", "", "Load state from file generated with Dump command")
+	undodir           = flag.String("u", "", "Save undo history of Put files in this directory and restore it on open")
+	journaldir        = flag.String("j", "", "Journal unsaved changes in this directory to recover them after a crash")
+	backupflag        = flag.String("B", backupnone, "Back up files written by Put to name~: none, once (the first Put in this session) or always")
+	listenflag        = flag.String("L", "", "Also serve the 9P file system on this address: unix!path or tcp!host!port")
+	keyflag           = flag.String("K", "", "Require 9P clients on the -L address to authenticate with the secret in this file")
+	nowatchflag       = flag.Bool("n", false, "Don't watch the disk files of windows for changes made by other programs")
 )
 
 func predrawInit() *dumpfile.Content {
@@ -39,6 +45,12 @@ func predrawInit() *dumpfile.Content {
 
 	flag.Parse()
 
+	switch *backupflag {
+	case backupnone, backuponce, backupalways:
+	default:
+		log.Fatalf("unknown backup policy %q", *backupflag)
+	}
+
 	startProfiler()
 
 	// Implicit to preserve existing semantics.
@@ -86,6 +98,11 @@ func mainWithDisplay(g *globals, dump *dumpfile.Content, display draw.Display) {
 
 	startplumbing()
 	fs := fsysinit()
+	if *listenflag != "" {
+		if err := listen9p(*listenflag, *keyflag); err != nil {
+			log.Fatalf("can't listen: %v", err)
+		}
+	}
 
 	const WindowsPerCol = 6
 
@@ -125,6 +142,13 @@ func mainWithDisplay(g *globals, dump *dumpfile.Content, display draw.Display) {
 			}
 		}
 	}
+	if *journaldir != "" {
+		offerrecovery()
+		go journalthread(g)
+	}
+	if !*nowatchflag {
+		go watchthread(g)
+	}
 	display.Flush()
 
 	// After row is initialized
@@ -171,6 +195,7 @@ func readfile(c *Column, filename string) {
 	w.SetName(abspath)
 	w.body.Load(0, filename, true)
 	w.body.file.Clean()
+	loadundohistory(&w.body)
 	w.Resize(w.r, false, true)
 	w.body.ScrDraw(w.body.fr.GetFrameFillStatus().Nchars)
 	w.tag.SetSelect(w.tag.file.Nr(), w.tag.file.Nr())
@@ -364,6 +389,7 @@ func MovedMouse(g *globals, m draw.Mouse) {
 			}
 			if t.w != nil && t == &t.w.body {
 				g.activewin = t.w
+				xfidlogf(t.w, "select", "%d %d", t.q0, t.q1)
 			}
 		case m.Buttons&2 != 0:
 			if q0, q1, argt, ok := t.Select2(); ok {
@@ -590,6 +616,7 @@ func (w errorWriter) Close() error {
 const MAXSNARF = 10 * 1024
 
 func acmeputsnarf() {
+	recordsnarf()
 	global.row.display.WriteSnarf(global.snarfbuf)
 }
 
@@ -608,6 +635,7 @@ func acmegetsnarf() {
 	}
 	if n < len(b) && n == sz {
 		global.snarfbuf = b[0:n]
+		recordsnarf()
 		return
 	}
 
@@ -621,4 +649,5 @@ func acmegetsnarf() {
 
 	// Trim it: it might have shortened.
 	global.snarfbuf = b[0:n]
+	recordsnarf()
 }
@@ -39,12 +39,28 @@ func isregexc(r rune) bool {
 	return false
 }
 
+// A lineIndexer can convert between lines (where the first line is line
+// 0) and rune offsets without reading its text.
+type lineIndexer interface {
+	LineToRune(l int) (int, bool)
+	RuneToLine(q int) int
+}
+
+var _ lineIndexer = (*Text)(nil) // Enforce at compile time that Text implements lineIndexer
+
 // nlcounttopos starts at q0 and advances nl lines,
 // being careful not to walk past the end of the text,
 // and then nr chars, being careful not to walk past
 // the end of the current line.
 // It returns the final position in runes.
 func nlcounttopos(t sam.Texter, q0 int, nl int, nr int) int {
+	if li, ok := t.(lineIndexer); ok && nl > 0 {
+		q, ok := li.LineToRune(li.RuneToLine(q0) + nl)
+		if !ok {
+			return q
+		}
+		q0, nl = q, 0
+	}
 	for nl > 0 && q0 < t.Nc() {
 		if t.ReadC(q0) == '\n' {
 			nl--
@@ -86,6 +102,14 @@ func number(showerr bool, t sam.Texter, r Range, line int, dir int, size int) (R
 	case None:
 		q0 = 0
 		q1 = 0
+		if li, ok := t.(lineIndexer); ok && line > 0 {
+			if q0, ok = li.LineToRune(line - 1); !ok {
+				goto Rescue
+			}
+			// The last line might not end with a newline.
+			q1, _ = li.LineToRune(line)
+			break
+		}
 		for line > 0 && q1 < t.Nc() {
 			if t.ReadC(q1) == '\n' || q1 == t.Nc() {
 				line--
@@ -324,6 +348,13 @@ func address(showerr bool, t sam.Texter, lim Range, ar Range, q0 int, q1 int, ge
 					c = getc(q)
 					q++
 				case '/':
+					// A pattern that is closed can have flags.
+					flags := ""
+					for q < q1 && strings.ContainsRune(rxflagchars, getc(q)) {
+						flags += string(getc(q))
+						q++
+					}
+					pat = rxflags(pat, flags)
 					goto out
 				}
 				pat = pat + string(c)
@@ -34,6 +34,10 @@ func TestAddr(t *testing.T) {
 		{Range{10, 10}, ",2", Range{0, 21}, true, 2},
 		{Range{0, 0}, "2,", Range{10, 39}, true, 2},
 		{Range{0, 0}, "$-1", Range{21, 39}, true, 3},
+		{Range{0, 0}, "3", Range{21, 39}, true, 1},
+		{Range{0, 0}, "4", Range{39, 39}, true, 1},
+		{Range{0, 0}, "0", Range{0, 0}, true, 1},
+		{Range{3, 3}, "5", Range{3, 3}, false, 1},
 
 		{Range{0, 0}, "/addressing", Range{28, 38}, true, 11},
 		{Range{0, 0}, "/addressing\n", Range{28, 38}, true, 11},
@@ -45,6 +49,9 @@ func TestAddr(t *testing.T) {
 		{Range{0, 0}, "2,/i/", Range{10, 3}, true, 5},
 		{Range{0, 0}, "2;/i/", Range{10, 36}, true, 5},
 		{Range{39, 39}, "?s", Range{34, 35}, true, 2},
+		{Range{0, 0}, "/SHORT/i", Range{10, 15}, true, 8},
+		{Range{0, 0}, "/text.to/s", Range{16, 23}, true, 10},
+		{Range{0, 0}, "/t.y/l", Range{-1, -1}, false, 6},
 
 		{Range{0, 0}, "line2", Range{0, 0}, true, 0},
 		{Range{0, 0}, "2$", Range{10, 21}, true, 1},
@@ -52,17 +59,23 @@ func TestAddr(t *testing.T) {
 		{Range{0, 0}, "#X", Range{0, 0}, true, 1},
 	}
 
-	text := sam.NewTextBuffer(0, 0, []rune("This is a\nshort text\nto try addressing\n"))
+	const contents = "This is a\nshort text\nto try addressing\n"
 
-	for i, test := range testtab {
-		t.Run(fmt.Sprintf("test-%02d", i), func(t *testing.T) {
-			r, ep, q := address(false, text, Range{-1, -1}, test.dot, 0, len(test.addr),
-				func(q int) rune { return []rune(test.addr)[q] }, true)
-			if test.r != r || test.ep != ep || test.q != q {
-				t.Errorf("address %q: r=%v, ep=%v, q=%v; expected r=%v, ep=%v, q=%v",
-					test.addr, r, ep, q, test.r, test.ep, test.q)
-			}
-		})
+	// A Text finds lines with the line index of its file.
+	for _, text := range []sam.Texter{
+		sam.NewTextBuffer(0, 0, []rune(contents)),
+		&Text{file: file.MakeObservableEditableBuffer("", []rune(contents))},
+	} {
+		for i, test := range testtab {
+			t.Run(fmt.Sprintf("%T-test-%02d", text, i), func(t *testing.T) {
+				r, ep, q := address(false, text, Range{-1, -1}, test.dot, 0, len(test.addr),
+					func(q int) rune { return []rune(test.addr)[q] }, true)
+				if test.r != r || test.ep != ep || test.q != q {
+					t.Errorf("address %q: r=%v, ep=%v, q=%v; expected r=%v, ep=%v, q=%v",
+						test.addr, r, ep, q, test.r, test.ep, test.q)
+				}
+			})
+		}
 	}
 }
 
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/util"
+)
+
+// A write to the addr file can set several ranges, so that a tool can
+// make several changes to the body at once. It holds an address per
+// line. As in Edit, an address can be followed by x/re/ or y/re/ (or a
+// sequence of them) to select the matches of re in its range, or the
+// text between them. The ranges must not overlap. Reading addr gives a
+// line per range, in order of position.
+//
+// While several ranges are set, a read of data or xdata gives the text
+// of each range in turn, with a NUL byte, which the body can't hold,
+// after all but the last. A write to data replaces every range as a
+// single change: with the NUL-separated pieces of the write, one per
+// range, or with all of it if it has no NUL.
+
+// ErrNoMatch is the error for a write to addr that selects no ranges.
+var ErrNoMatch = fmt.Errorf("no match for address")
+
+// parseaddrs returns the ranges of t selected by the addresses written
+// to the addr file (see above). The addresses are evaluated from ar
+// and searches are limited to lim, as for a single address.
+func parseaddrs(t *Text, lim, ar Range, r []rune) ([]Range, error) {
+	lines := strings.Split(string(r), "\n")
+	addrs := make([][]rune, len(lines))
+	loops := make([][]addrloop, len(lines))
+	for i, line := range lines {
+		if line == "" && len(lines) > 1 {
+			return nil, ErrBadAddr
+		}
+		l := []rune(line)
+		_, _, nr := address(false, t, lim, ar, 0, len(l), func(q int) rune { return l[q] }, false)
+		lps, ok := parseloops(l[nr:])
+		if !ok {
+			return nil, ErrBadAddr
+		}
+		addrs[i], loops[i] = l[:nr], lps
+	}
+
+	var rs []Range
+	for i, l := range addrs {
+		a, eval, _ := address(false, t, lim, ar, 0, len(l), func(q int) rune { return l[q] }, true)
+		if !eval {
+			return nil, ErrAddrRange
+		}
+		lrs := []Range{a}
+		for _, lp := range loops[i] {
+			are, err := rxcompile(lp.re)
+			if err != nil {
+				return nil, ErrBadAddr
+			}
+			var nrs []Range
+			for _, lr := range lrs {
+				nrs = append(nrs, loopranges(t, are, lr, lp.isX)...)
+			}
+			lrs = nrs
+		}
+		rs = append(rs, lrs...)
+	}
+	if len(rs) == 0 {
+		return nil, ErrNoMatch
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].q0 < rs[j].q0 })
+	for i := 1; i < len(rs); i++ {
+		if rs[i].q0 < rs[i-1].q1 {
+			return nil, fmt.Errorf("overlapping addresses")
+		}
+	}
+	return rs, nil
+}
+
+type addrloop struct {
+	isX bool // Else y.
+	re  string
+}
+
+// parseloops parses the x/re/ and y/re/ commands that follow an address.
+// The delimiter is any character that isn't a letter, digit or space and
+// may be escaped in re with a backslash. The last delimiter may be
+// left out; if it isn't, flags can follow it, as in Edit.
+func parseloops(r []rune) ([]addrloop, bool) {
+	var loops []addrloop
+	for len(r) > 0 {
+		if (r[0] != 'x' && r[0] != 'y') || len(r) < 2 {
+			return nil, false
+		}
+		isX := r[0] == 'x'
+		delim := r[1]
+		if delim == '\\' || delim == ' ' || delim == '\t' || isalnum(delim) {
+			return nil, false
+		}
+		var re []rune
+		i := 2
+		for ; i < len(r) && r[i] != delim; i++ {
+			if r[i] == '\\' && i+1 < len(r) && r[i+1] == delim {
+				i++
+			}
+			re = append(re, r[i])
+		}
+		if len(re) == 0 {
+			return nil, false
+		}
+		flags := ""
+		if i < len(r) {
+			for i++; i < len(r) && strings.ContainsRune(rxflagchars, r[i]); i++ {
+				flags += string(r[i])
+			}
+		}
+		loops = append(loops, addrloop{isX, rxflags(string(re), flags)})
+		r = r[i:]
+	}
+	return loops, true
+}
+
+// addrstring returns the contents of the addr file of w.
+func (w *Window) addrstring() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%11d %11d ", w.addr.q0, w.addr.q1)
+	for _, r := range w.moreaddr {
+		fmt.Fprintf(&sb, "\n%11d %11d ", r.q0, r.q1)
+	}
+	return sb.String()
+}
+
+// xfidaddrsread responds to x, a read of the data or xdata file of w
+// while several ranges are set, with the text of the ranges from w.addr
+// on. It moves w.addr past the text read and on to the next range.
+func xfidaddrsread(x *Xfid, w *Window) {
+	var fc plan9.Fcall
+	t := &w.body
+	w.Commit(t)
+	for _, r := range append([]Range{w.addr}, w.moreaddr...) {
+		if r.q0 > t.Nc() || r.q1 > t.Nc() {
+			x.respond(&fc, ErrAddrRange)
+			return
+		}
+	}
+	count := int(x.fcall.Count)
+	var buf []byte
+	for {
+		b, nr := runeread(t, w.addr.q0, w.addr.q1, count-len(buf))
+		buf = append(buf, b...)
+		w.addr.q0 += nr
+		if w.addr.q0 < w.addr.q1 || len(w.moreaddr) == 0 || len(buf) >= count {
+			break
+		}
+		buf = append(buf, 0)
+		w.addr = w.moreaddr[0]
+		w.moreaddr = w.moreaddr[1:]
+	}
+	fc.Count = uint32(len(buf))
+	fc.Data = buf
+	x.respond(&fc, nil)
+}
+
+// addrswrite replaces the ranges of w, while several are set, with the
+// data written to its data file. Must be called with the window lock
+// held.
+func addrswrite(w *Window, data []byte) error {
+	t := &w.body
+	w.Commit(t)
+	rs := append([]Range{w.addr}, w.moreaddr...)
+	for _, r := range rs {
+		if r.q0 > t.Nc() || r.q1 > t.Nc() {
+			return ErrAddrRange
+		}
+	}
+	pieces := bytes.Split(data, []byte{0})
+	if len(pieces) != 1 && len(pieces) != len(rs) {
+		return fmt.Errorf("%d pieces written for %d ranges", len(pieces), len(rs))
+	}
+	text := make([][]rune, len(rs))
+	for i := range rs {
+		p := pieces[0]
+		if len(pieces) > 1 {
+			p = pieces[i]
+		}
+		text[i], _, _ = util.Cvttorunes(p, len(p))
+	}
+
+	if !w.nomark {
+		global.seq++
+		t.file.Mark(global.seq)
+	}
+	// From the last range back, so the earlier ones don't move.
+	for i := len(rs) - 1; i >= 0; i-- {
+		q0, q1, r := rs[i].q0, rs[i].q1, text[i]
+		if q1 > q0 {
+			t.Delete(q0, q1, true)
+		}
+		tq0, tq1 := t.q0, t.q1
+		if len(r) > 0 {
+			t.Insert(q0, r, true)
+		}
+		if tq0 >= q0 {
+			tq0 += len(r)
+		}
+		if tq1 >= q0 {
+			tq1 += len(r)
+		}
+		t.SetSelect(tq0, tq1)
+	}
+	if t.fr != nil {
+		t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
+	}
+
+	// Leave addr after the last replacement, as for a single range.
+	q := rs[len(rs)-1].q0 + len(text[len(rs)-1])
+	for i, r := range rs[:len(rs)-1] {
+		q += len(text[i]) - (r.q1 - r.q0)
+	}
+	w.addr = Range{q, q}
+	w.moreaddr = nil
+	return nil
+}
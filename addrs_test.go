@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/edwoodtest"
+	"github.com/rjkroege/edwood/file"
+)
+
+func TestParseaddrs(t *testing.T) {
+	const body = "one two\nthree two\nfour\n"
+	w := NewWindow().initHeadless(nil)
+	w.body.file = file.MakeObservableEditableBuffer("", []rune(body))
+	lim := Range{0, len(body)}
+
+	for _, tc := range []struct {
+		addr string
+		want []Range
+		err  error
+	}{
+		{"2", []Range{{8, 18}}, nil},
+		{"3\n1", []Range{{0, 8}, {18, 23}}, nil},
+		{",x/two/", []Range{{4, 7}, {14, 17}}, nil},
+		{"2x/two", []Range{{14, 17}}, nil},
+		{",x/TWO/i", []Range{{4, 7}, {14, 17}}, nil},
+		{"/T.o/ix/O/i", []Range{{6, 7}}, nil},
+		{",x/.*\\n/x/o/", []Range{{0, 1}, {6, 7}, {16, 17}, {19, 20}}, nil},
+		{"1y/ /", []Range{{0, 3}, {4, 8}}, nil},
+		{"#0\n#3,#4", []Range{{0, 0}, {3, 4}}, nil},
+		{",x/six/", nil, ErrNoMatch},
+		{"1\n1", nil, fmt.Errorf("overlapping addresses")},
+		{"1\n", nil, ErrBadAddr},
+		{",x", nil, ErrBadAddr},
+		{",z/two/", nil, ErrBadAddr},
+		{",xa", nil, ErrBadAddr},
+		{"/six/\n1", nil, ErrAddrRange},
+		{"/six/\n1q", nil, ErrBadAddr},
+	} {
+		got, err := parseaddrs(&w.body, lim, Range{}, []rune(tc.addr))
+		if (err == nil) != (tc.err == nil) || (err != nil && err.Error() != tc.err.Error()) {
+			t.Errorf("parseaddrs(%q) got error %v; want %v", tc.addr, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseaddrs(%q) got %v; want %v", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestXfidMultipleAddrs(t *testing.T) {
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.configureGlobals(display)
+
+	const body = "a := f(x)\nb := f(y)\n"
+	w := NewWindow().initHeadless(nil)
+	w.col = new(Column)
+	w.col.safe = true
+	w.display = display
+	w.body.fr = &MockFrame{}
+	w.body.display = display
+	w.body.file = file.MakeObservableEditableBuffer("", []rune(body))
+	w.tag.fr = &MockFrame{}
+	w.tag.display = display
+	w.limit = Range{0, len(body)}
+
+	rpc := func(write bool, q uint64, data string, count uint32) (string, error) {
+		mr := new(mockResponder)
+		x := &Xfid{
+			fcall: plan9.Fcall{Data: []byte(data), Count: count},
+			f:     &Fid{qid: plan9.Qid{Path: QID(1, q)}, w: w},
+			fs:    mr,
+		}
+		if write {
+			x.fcall.Count = uint32(len(data))
+			xfidwrite(x)
+		} else {
+			xfidread(x)
+		}
+		if mr.err != nil {
+			return "", mr.err
+		}
+		return string(mr.fcall.Data), nil
+	}
+
+	if _, err := rpc(true, QWaddr, ",x/f\\([a-z]\\)/", 0); err != nil {
+		t.Fatalf("write of addr failed: %v", err)
+	}
+	if got, want := w.addrstring(), "          5           9 \n         15          19 "; got != want {
+		t.Errorf("got addr %q; want %q", got, want)
+	}
+	var got string
+	for {
+		s, err := rpc(false, QWxdata, "", 3)
+		if err != nil {
+			t.Fatalf("read of xdata failed: %v", err)
+		}
+		if s == "" {
+			break
+		}
+		got += s
+	}
+	if want := "f(x)\x00f(y)"; got != want {
+		t.Errorf("read of xdata got %q; want %q", got, want)
+	}
+
+	rpc(true, QWaddr, ",x/f\\([a-z]\\)/", 0)
+	if _, err := rpc(true, QWdata, "g\x00h\x00i", 0); err == nil {
+		t.Errorf("write of three pieces to two ranges succeeded")
+	}
+	if _, err := rpc(true, QWdata, "g(x, 1)\x00g(y, 2)", 0); err != nil {
+		t.Fatalf("write of data failed: %v", err)
+	}
+	if got, want := w.body.file.String(), "a := g(x, 1)\nb := g(y, 2)\n"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+	if got, want := w.addr, (Range{25, 25}); got != want || len(w.moreaddr) != 0 {
+		t.Errorf("got addr %v and %v more; want %v", got, w.moreaddr, want)
+	}
+
+	rpc(true, QWaddr, ",x/:=/", 0)
+	rpc(true, QWdata, "=", 0)
+	if got, want := w.body.file.String(), "a = g(x, 1)\nb = g(y, 2)\n"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+
+	w.Undo(true)
+	w.Undo(true)
+	if got, want := w.body.file.String(), body; got != want {
+		t.Errorf("after two undos got body %q; want %q", got, want)
+	}
+}
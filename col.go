@@ -323,7 +323,12 @@ func (c *Column) Resize(r image.Rectangle) {
 
 func (c *Column) Sort() {
 	sort.Slice(c.w, func(i, j int) bool { return c.w[i].body.file.Name() < c.w[j].body.file.Name() })
+	c.restack()
+}
 
+// restack lays out the windows of c from top to bottom in the order of
+// c.w, keeping their heights.
+func (c *Column) restack() {
 	r := c.r
 	r.Min.Y = c.tag.fr.Rect().Max.Y
 	c.display.ScreenImage().Draw(r, global.textcolors[frame.ColBack], nil, image.Point{})
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rjkroege/edwood/file"
+)
+
+// A begin message to the ctl file of a window starts a transaction. The
+// changes to the window until a commit message, whether made by later
+// ctl messages or by writes to its other files, form a single change
+// that Undo reverts. An abort message, a ctl message that fails, or
+// closing the ctl file that began the transaction instead rolls the
+// window back to its state at the begin, unless other changes, such as
+// typing, were made to the window meanwhile.
+//
+// The messages that can't be rolled back, del, delete, get, put and
+// font, fail in a transaction.
+
+// A ctltxn holds the state of a window when a transaction began.
+type ctltxn struct {
+	fid      uint32 // Of the ctl file that began the transaction.
+	seq      int    // Of the changes made in the transaction.
+	depth    int    // Of the undo history of the body at the begin.
+	dirty    bool
+	nomark   bool
+	filemenu bool
+	dumpstr  string
+	dumpdir  string
+	encoding string
+	crlf     bool
+	bom      bool
+}
+
+// txnrefused returns an error if the ctl message named cmd can't be
+// rolled back and so can't be part of a transaction.
+func txnrefused(cmd string) error {
+	switch cmd {
+	case "del", "delete", "get", "put", "font":
+		return fmt.Errorf("%s in a transaction", cmd)
+	}
+	return nil
+}
+
+// begintxn starts a transaction on w for the ctl file opened as fid.
+// Must be called with the window lock held.
+func (w *Window) begintxn(fid uint32) error {
+	if w.ctltxn != nil {
+		return fmt.Errorf("transaction already begun")
+	}
+	f := w.body.file
+	txn := &ctltxn{
+		fid:      fid,
+		depth:    f.UndoDepth(),
+		dirty:    f.Dirty(),
+		nomark:   w.nomark,
+		filemenu: w.filemenu,
+		dumpstr:  w.dumpstr,
+		dumpdir:  w.dumpdir,
+		encoding: f.Encoding(),
+		crlf:     f.CRLF(),
+		bom:      f.BOM(),
+	}
+	global.seq++
+	txn.seq = global.seq
+	f.Mark(txn.seq)
+	w.nomark = true
+	w.ctltxn = txn
+	return nil
+}
+
+// own returns true if the changes to f since txn began were all made
+// in the transaction.
+func (txn *ctltxn) own(f *file.ObservableEditableBuffer) bool {
+	seqs, ok := f.SeqsSince(txn.depth)
+	if !ok {
+		return false
+	}
+	for _, seq := range seqs {
+		if seq != txn.seq {
+			return false
+		}
+	}
+	return true
+}
+
+// committxn ends the transaction on w, keeping its changes as a single
+// change. Must be called with the window lock held.
+func (w *Window) committxn() error {
+	txn := w.ctltxn
+	if txn == nil {
+		return fmt.Errorf("no transaction")
+	}
+	w.ctltxn = nil
+	w.nomark = txn.nomark
+	f := w.body.file
+	if f.UndoDepth() == txn.depth && !txn.dirty {
+		// Nothing to undo: the mark alone would leave the body dirty.
+		f.Clean()
+	}
+	if txn.own(f) {
+		f.MergeSince(txn.depth)
+	}
+	return nil
+}
+
+// aborttxn ends the transaction on w, rolling back its changes. It
+// refuses to if other changes were made to w meanwhile. Must be called
+// with the window lock held.
+func (w *Window) aborttxn() error {
+	txn := w.ctltxn
+	if txn == nil {
+		return fmt.Errorf("no transaction")
+	}
+	w.ctltxn = nil
+	w.nomark = txn.nomark
+	f := w.body.file
+	if !txn.own(f) {
+		return fmt.Errorf("other changes made during the transaction")
+	}
+	for f.UndoDepth() > txn.depth && w.Undo(true) {
+	}
+	if !txn.dirty {
+		f.Clean()
+	} else if !f.Dirty() {
+		f.Modded()
+	}
+	w.filemenu = txn.filemenu
+	w.dumpstr = txn.dumpstr
+	w.dumpdir = txn.dumpdir
+	if f.Encoding() != txn.encoding {
+		f.SetEncoding(txn.encoding)
+	}
+	f.SetCRLF(txn.crlf)
+	f.SetBOM(txn.bom)
+	return nil
+}
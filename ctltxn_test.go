@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+// ctltxnWindow makes a window whose body is clean and returns it with
+// a function that writes data to its file q as fid.
+func ctltxnWindow(t *testing.T) (*Window, func(fid uint32, q uint64, data string) error) {
+	t.Helper()
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("/a/a.txt"),
+		ScBody("/a/a.txt", "hello\n"),
+	)
+	w := global.row.col[0].w[0]
+	w.body.file.Clean()
+
+	write := func(fid uint32, q uint64, data string) error {
+		t.Helper()
+		mr := new(mockResponder)
+		xfidwrite(&Xfid{
+			f:     &Fid{fid: fid, qid: plan9.Qid{Path: QID(w.id, q)}, w: w},
+			fcall: plan9.Fcall{Data: []byte(data), Count: uint32(len(data))},
+			fs:    mr,
+		})
+		return mr.err
+	}
+	return w, write
+}
+
+func TestCtlTransaction(t *testing.T) {
+	w, write := ctltxnWindow(t)
+	f := w.body.file
+
+	change := func() {
+		t.Helper()
+		if err := write(1, QWctl, "begin\nname /a/b.txt\ndump cmd\n"); err != nil {
+			t.Fatalf("ctl write failed: %v", err)
+		}
+		if err := write(2, QWbody, "world\n"); err != nil {
+			t.Fatalf("body write failed: %v", err)
+		}
+	}
+	check := func(name, body, dump string, dirty bool) {
+		t.Helper()
+		if got := f.Name(); got != name {
+			t.Errorf("got name %q; want %q", got, name)
+		}
+		if got := f.String(); got != body {
+			t.Errorf("got body %q; want %q", got, body)
+		}
+		if w.dumpstr != dump {
+			t.Errorf("got dump %q; want %q", w.dumpstr, dump)
+		}
+		if f.Dirty() != dirty {
+			t.Errorf("got dirty %v; want %v", f.Dirty(), dirty)
+		}
+		if w.ctltxn != nil || w.nomark {
+			t.Errorf("transaction not ended")
+		}
+	}
+
+	// A failing line rolls back the earlier ones and writes to the body.
+	change()
+	if err := write(1, QWctl, "clean\nbogus"); err != ErrBadCtl {
+		t.Fatalf("got error %v; want %v", err, ErrBadCtl)
+	}
+	check("/a/a.txt", "hello\n", "", false)
+
+	change()
+	if err := write(1, QWctl, "abort"); err != nil {
+		t.Fatalf("abort failed: %v", err)
+	}
+	check("/a/a.txt", "hello\n", "", false)
+
+	// Closing the ctl file that began the transaction aborts it.
+	change()
+	x := &Xfid{f: &Fid{fid: 2, qid: plan9.Qid{Path: QID(w.id, QWctl)}, w: w, open: true}, fs: new(mockResponder)}
+	w.ref.Inc()
+	xfidclose(x)
+	if w.ctltxn == nil {
+		t.Fatalf("closing another ctl file ended the transaction")
+	}
+	x = &Xfid{f: &Fid{fid: 1, qid: plan9.Qid{Path: QID(w.id, QWctl)}, w: w, open: true}, fs: new(mockResponder)}
+	w.ref.Inc()
+	xfidclose(x)
+	check("/a/a.txt", "hello\n", "", false)
+
+	// An empty transaction leaves the window clean.
+	f.Clean()
+	if err := write(1, QWctl, "begin\ncommit"); err != nil {
+		t.Fatalf("empty transaction failed: %v", err)
+	}
+	if f.Dirty() {
+		t.Errorf("empty transaction left the window dirty")
+	}
+}
+
+func TestCtlTransactionCommit(t *testing.T) {
+	w, write := ctltxnWindow(t)
+	f := w.body.file
+	depth := f.UndoDepth()
+
+	if err := write(1, QWctl, "begin\nname /a/b.txt\n"); err != nil {
+		t.Fatalf("ctl write failed: %v", err)
+	}
+	if err := write(2, QWbody, "world\n"); err != nil {
+		t.Fatalf("body write failed: %v", err)
+	}
+	if err := write(1, QWctl, "dump cmd\nclean\n"); err != nil {
+		t.Fatalf("ctl write failed: %v", err)
+	}
+	if err := write(2, QWbody, "again\n"); err != nil {
+		t.Fatalf("body write failed: %v", err)
+	}
+	if err := write(1, QWctl, "commit\n"); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if got, want := f.UndoDepth(), depth+1; got != want {
+		t.Errorf("got undo depth %d after commit; want %d", got, want)
+	}
+
+	undostep(w, true)
+	if got, want := f.String(), "hello\n"; got != want {
+		t.Errorf("after undo got body %q; want %q", got, want)
+	}
+	if got, want := f.Name(), "/a/a.txt"; got != want {
+		t.Errorf("after undo got name %q; want %q", got, want)
+	}
+	undostep(w, false)
+	if got, want := f.String(), "hello\nworld\nagain\n"; got != want {
+		t.Errorf("after redo got body %q; want %q", got, want)
+	}
+}
+
+func TestCtlTransactionInterleaved(t *testing.T) {
+	w, write := ctltxnWindow(t)
+	f := w.body.file
+
+	for _, end := range []string{"abort", "commit"} {
+		if err := write(1, QWctl, "begin\n"); err != nil {
+			t.Fatalf("begin failed: %v", err)
+		}
+		if err := write(2, QWbody, "world\n"); err != nil {
+			t.Fatalf("body write failed: %v", err)
+		}
+		// Typed meanwhile.
+		global.seq++
+		f.Mark(global.seq)
+		f.InsertAt(0, []rune("X"))
+
+		want := "Xhello\nworld\n"
+		err := write(1, QWctl, end)
+		if end == "abort" {
+			if err == nil {
+				t.Errorf("abort with other changes succeeded")
+			}
+		} else if err != nil {
+			t.Errorf("commit failed: %v", err)
+		}
+		if w.ctltxn != nil || w.nomark {
+			t.Errorf("%s: transaction not ended", end)
+		}
+		if got := f.String(); got != want {
+			t.Errorf("%s: got body %q; want %q", end, got, want)
+		}
+
+		// The typing remains a change of its own.
+		undostep(w, true)
+		if got, want := f.String(), "hello\nworld\n"; got != want {
+			t.Errorf("%s: after undo got body %q; want %q", end, got, want)
+		}
+		undostep(w, true)
+		if got, want := f.String(), "hello\n"; got != want {
+			t.Errorf("%s: after second undo got body %q; want %q", end, got, want)
+		}
+	}
+}
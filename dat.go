@@ -13,26 +13,43 @@ import (
 )
 
 // These constants are used to identify a file in the file server.
-// They are stored in plan9.Qid.Path (along with window ID).
+// They are stored in plan9.Qid.Path (along with window ID, or column
+// number for the QC files).
 // TODO(fhs): Introduce a new type for these constants?
 const (
 	Qdir uint64 = iota
 	Qacme
+	Qcol
 	Qcons
 	Qconsctl
+	Qctl
 	Qdraw
 	Qeditout
+	Qeventjson
 	Qindex
+	Qindexjson
 	Qlabel
 	Qlog
 	Qnew
+	Qsnarf
+	Qsnarfhist
+	QCdir
+	QCctl
+	QCindex
+	QCtag
 	QWaddr
 	QWbody
 	QWctl
 	QWdata
+	QWdiff
 	QWeditout
 	QWerrors
 	QWevent
+	QWeventjson
+	QWhistory
+	QWinfojson
+	QWlines
+	QWmarks
 	QWrdsel
 	QWwrsel
 	QWtag
@@ -118,11 +135,22 @@ type Fid struct {
 	open   bool // true after Topen; false after Tcluck
 	qid    plan9.Qid
 	w      *Window
+	col    *Column // Of the files in col/<n>.
 	dir    *DirTab // Used for stat, and open permission check.
 	mntdir *MntDir
 	nrpart int
 	rpart  [utf8.UTFMax]byte
 	logoff int
+
+	logfilter *logFilter // Selects the entries read from the log file; nil for the default.
+
+	auth      bool   // An auth fid (see fileServer.authrpc).
+	authok    bool   // The response to the challenge was written to the auth fid.
+	challenge []byte // Read from the auth fid.
+
+	snapshot []byte // Contents of index.json, info.json, diff or a snarf file when opened.
+	snarf    []byte // Written to the snarf file; nil if not open for writing.
+	lines    []byte // Written to the lines file; applied when clunked.
 }
 
 type Xfid struct {
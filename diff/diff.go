@@ -0,0 +1,134 @@
+// Package diff implements line-oriented comparison and three-way merging
+// of texts.
+package diff
+
+import "strings"
+
+// An Edit is a difference between two sequences of lines a and b:
+// a[A0:A1] is replaced by b[B0:B1].
+type Edit struct {
+	A0, A1 int
+	B0, B1 int
+}
+
+// SplitLines splits s after each newline. The last line lacks a newline
+// if s doesn't end with one.
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns the shortest sequence of Edits that turns a into b, in
+// increasing order of position.
+func Lines(a, b []string) []Edit {
+	// Common prefixes and suffixes are frequent and cheap to remove.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	edits := myers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	for i := range edits {
+		edits[i].A0 += pre
+		edits[i].A1 += pre
+		edits[i].B0 += pre
+		edits[i].B1 += pre
+	}
+	return edits
+}
+
+// myers implements the O(ND) difference algorithm of Eugene W. Myers.
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	max := n + m
+	// v[k+max] is the furthest x reached on diagonal k. trace[d] holds
+	// v[-d+max:d+max+1] after d differences.
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	var d int
+search:
+	for d = 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+max] < v[k+1+max]) {
+				x = v[k+1+max]
+			} else {
+				x = v[k-1+max] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+max] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[-d+max:d+max+1]...))
+				break search
+			}
+		}
+		trace = append(trace, append([]int(nil), v[-d+max:d+max+1]...))
+	}
+
+	// Walk back through trace to recover the path, collecting the
+	// lines deleted from a and inserted from b in reverse.
+	var edits []Edit
+	x, y := n, m
+	for ; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		var pk int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := at(pk)
+		py := px - pk
+		// Follow the diagonal back to the end of the move from (px, py).
+		mx, my := px+1, py
+		if pk == k+1 {
+			mx, my = px, py+1
+		}
+		for x > mx && y > my {
+			x--
+			y--
+		}
+		if pk == k+1 {
+			edits = addEdit(edits, Edit{px, px, py, py + 1})
+		} else {
+			edits = addEdit(edits, Edit{px, px + 1, py, py})
+		}
+		x, y = px, py
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// addEdit adds e to edits, which are in decreasing order of position,
+// joining it with the last edit if they are adjacent.
+func addEdit(edits []Edit, e Edit) []Edit {
+	if len(edits) > 0 {
+		last := &edits[len(edits)-1]
+		if e.A1 == last.A0 && e.B1 == last.B0 {
+			last.A0 = e.A0
+			last.B0 = e.B0
+			return edits
+		}
+	}
+	return append(edits, e)
+}
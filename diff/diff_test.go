@@ -0,0 +1,138 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// apply applies edits to a.
+func apply(a, b []string, edits []Edit) []string {
+	var out []string
+	pos := 0
+	for _, e := range edits {
+		out = append(out, a[pos:e.A0]...)
+		out = append(out, b[e.B0:e.B1]...)
+		pos = e.A1
+	}
+	return append(out, a[pos:]...)
+}
+
+func TestSplitLines(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\n\nb", []string{"a\n", "\n", "b"}},
+	} {
+		if got := SplitLines(tc.s); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SplitLines(%q): got %q, want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want []Edit
+	}{
+		{"", "", nil},
+		{"abc", "abc", nil},
+		{"", "ab", []Edit{{0, 0, 0, 2}}},
+		{"ab", "", []Edit{{0, 2, 0, 0}}},
+		{"abc", "axc", []Edit{{1, 2, 1, 2}}},
+		{"abcd", "acd", []Edit{{1, 2, 1, 1}}},
+		{"abcd", "abxcd", []Edit{{2, 2, 2, 3}}},
+		{"abcabba", "cbabac", nil}, // Checked below by applying.
+	} {
+		a, b := strings.Split(tc.a, ""), strings.Split(tc.b, "")
+		got := Lines(a, b)
+		if tc.want != nil && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Lines(%q, %q): got %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		if r := apply(a, b, got); strings.Join(r, "") != tc.b {
+			t.Errorf("Lines(%q, %q): edits %v produce %q", tc.a, tc.b, got, strings.Join(r, ""))
+		}
+	}
+}
+
+func TestLinesRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	gen := func() []string {
+		s := make([]string, r.Intn(30))
+		for i := range s {
+			s[i] = string(rune('a' + r.Intn(4)))
+		}
+		return s
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := gen(), gen()
+		edits := Lines(a, b)
+		if got := apply(a, b, edits); !reflect.DeepEqual(got, b) && !(len(got) == 0 && len(b) == 0) {
+			t.Fatalf("Lines(%q, %q): edits %v produce %q", a, b, edits, got)
+		}
+		for j := 1; j < len(edits); j++ {
+			if edits[j].A0 <= edits[j-1].A1 && edits[j].B0 <= edits[j-1].B1 {
+				t.Fatalf("Lines(%q, %q): adjacent edits %v", a, b, edits)
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		base, mine, theirs string
+		want               string
+		conflicts          int
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", 0},
+		{"mine", "a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n", "a\nB\nc\n", 0},
+		{"theirs", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nC\n", "a\nb\nC\n", 0},
+		{"both", "a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", 0},
+		{"same", "a\nb\nc\n", "a\nX\nc\n", "a\nX\nc\n", "a\nX\nc\n", 0},
+		{"conflict", "a\nb\nc\n", "a\nM\nc\n", "a\nT\nc\n",
+			"a\n<<<<<<< mine\nM\n=======\nT\n>>>>>>> theirs\nc\n", 1},
+		{"no newline", "a\nb", "a\nm", "a\nt",
+			"a\n<<<<<<< mine\nm\n=======\nt\n>>>>>>> theirs\n", 1},
+		{"insertions", "a\n", "a\nm\n", "a\nt\n",
+			"a\n<<<<<<< mine\nm\n=======\nt\n>>>>>>> theirs\n", 1},
+	} {
+		got, n := Merge(SplitLines(tc.base), SplitLines(tc.mine), SplitLines(tc.theirs), "mine", "theirs")
+		if s := strings.Join(got, ""); s != tc.want || n != tc.conflicts {
+			t.Errorf("%s: got %q %d, want %q %d", tc.name, s, n, tc.want, tc.conflicts)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", ""},
+		{"change", "a\nb\nc\n", "a\nB\nc\n",
+			"--- old\n+++ new\n@@ -1,3 +1,3 @@ new:2\n a\n-b\n+B\n c\n"},
+		{"empty", "", "a\n",
+			"--- old\n+++ new\n@@ -0,0 +1 @@ new:1\n+a\n"},
+		{"deleted", "a\n", "",
+			"--- old\n+++ new\n@@ -1 +0,0 @@ new:1\n-a\n"},
+		{"no newline", "a\nb", "a\nb\n",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@ new:2\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{"context", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\n2\n3\n4\n5\n6\n7\n8\nnine\n10\n",
+			"--- old\n+++ new\n@@ -6,5 +6,5 @@ new:9\n 6\n 7\n 8\n-9\n+nine\n 10\n"},
+		{"hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@ new:1\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,3 @@ new:9\n 7\n 8\n 9\n-10\n"},
+		{"joined", "1\n2\n3\n4\n5\n6\n7\n8\n", "one\n2\n3\n4\n5\n6\n7\neight\n",
+			"--- old\n+++ new\n@@ -1,8 +1,8 @@ new:1\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n"},
+	} {
+		if got := Unified("old", "new", SplitLines(tc.a), SplitLines(tc.b)); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package diff
+
+import (
+	"sort"
+)
+
+// Conflict markers written by Merge.
+const (
+	MarkMine   = "<<<<<<<"
+	MarkSep    = "======="
+	MarkTheirs = ">>>>>>>"
+)
+
+// Merge returns the three-way merge of mine and theirs, which are both
+// derived from base, and the number of conflicts in it. Changes made by
+// only one side are taken from that side. Overlapping (or adjacent)
+// changes that differ are conflicts and are written between conflict
+// markers labelled with minelabel and theirslabel.
+func Merge(base, mine, theirs []string, minelabel, theirslabel string) (merged []string, conflicts int) {
+	type sideEdit struct {
+		Edit
+		theirs bool
+	}
+	var all []sideEdit
+	for _, e := range Lines(base, mine) {
+		all = append(all, sideEdit{e, false})
+	}
+	for _, e := range Lines(base, theirs) {
+		all = append(all, sideEdit{e, true})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].A0 < all[j].A0 })
+
+	// side returns base[lo:hi] with the edits in es applied.
+	side := func(es []Edit, lines []string, lo, hi int) []string {
+		var out []string
+		for _, e := range es {
+			out = append(out, base[lo:e.A0]...)
+			out = append(out, lines[e.B0:e.B1]...)
+			lo = e.A1
+		}
+		return append(out, base[lo:hi]...)
+	}
+
+	pos := 0
+	for i := 0; i < len(all); {
+		// Gather the edits overlapping the first remaining one.
+		lo, hi := all[i].A0, all[i].A1
+		var me, te []Edit
+		for ; i < len(all) && all[i].A0 <= hi; i++ {
+			if all[i].theirs {
+				te = append(te, all[i].Edit)
+			} else {
+				me = append(me, all[i].Edit)
+			}
+			if all[i].A1 > hi {
+				hi = all[i].A1
+			}
+		}
+
+		merged = append(merged, base[pos:lo]...)
+		pos = hi
+		m := side(me, mine, lo, hi)
+		t := side(te, theirs, lo, hi)
+		switch {
+		case len(te) == 0:
+			merged = append(merged, m...)
+		case len(me) == 0 || equal(m, t):
+			merged = append(merged, t...)
+		default:
+			conflicts++
+			merged = append(merged, MarkMine+" "+minelabel+"\n")
+			merged = appendLines(merged, m)
+			merged = append(merged, MarkSep+"\n")
+			merged = appendLines(merged, t)
+			merged = append(merged, MarkTheirs+" "+theirslabel+"\n")
+		}
+	}
+	return append(merged, base[pos:]...), conflicts
+}
+
+// appendLines appends lines to out, ending the last one with a newline
+// so that a conflict marker can follow it.
+func appendLines(out, lines []string) []string {
+	out = append(out, lines...)
+	if n := len(out); n > 0 && out[n-1] != "" && out[n-1][len(out[n-1])-1] != '\n' {
+		out[n-1] += "\n"
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines around each change in the
+// output of Unified.
+const Context = 3
+
+// Unified returns the differences between a and b as a unified diff of
+// the files aname and bname, or "" if there are none. The header of
+// each hunk ends with bname:n, where n is the line of b at which the
+// hunk's first change is, so that it can be looked at or plumbed.
+func Unified(aname, bname string, a, b []string) string {
+	edits := Lines(a, b)
+	if len(edits) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aname, bname)
+	for len(edits) > 0 {
+		// A hunk takes the edits separated by no more than twice the
+		// context.
+		n := 1
+		for n < len(edits) && edits[n].A0-edits[n-1].A1 <= 2*Context {
+			n++
+		}
+		hunk := edits[:n]
+		edits = edits[n:]
+
+		first, last := hunk[0], hunk[len(hunk)-1]
+		a0 := max(first.A0-Context, 0)
+		a1 := min(last.A1+Context, len(a))
+		b0 := first.B0 - (first.A0 - a0)
+		b1 := last.B1 + (a1 - last.A1)
+		line := min(first.B0+1, len(b))
+		fmt.Fprintf(&sb, "@@ -%s +%s @@ %s:%d\n", hunkrange(a0, a1), hunkrange(b0, b1), bname, max(line, 1))
+
+		pos := a0
+		for _, e := range hunk {
+			writelines(&sb, ' ', a[pos:e.A0])
+			writelines(&sb, '-', a[e.A0:e.A1])
+			writelines(&sb, '+', b[e.B0:e.B1])
+			pos = e.A1
+		}
+		writelines(&sb, ' ', a[pos:a1])
+	}
+	return sb.String()
+}
+
+// hunkrange returns the range of lines [l0, l1), counting from 0, as
+// written in a hunk header.
+func hunkrange(l0, l1 int) string {
+	switch l1 - l0 {
+	case 0:
+		// An empty range is given by the line before it.
+		return fmt.Sprintf("%d,0", l0)
+	case 1:
+		return fmt.Sprintf("%d", l0+1)
+	}
+	return fmt.Sprintf("%d,%d", l0+1, l1-l0)
+}
+
+// writelines writes lines to sb, each after the prefix c.
+func writelines(sb *strings.Builder, c byte, lines []string) {
+	for _, l := range lines {
+		sb.WriteByte(c)
+		sb.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package dumpfile
+
+import "time"
+
+// History is the undo history of the body of a window: a snapshot of
+// the piece table of its buffer and of its undo tree. It has the same
+// encoding as file.History, into which it is converted when the window
+// is loaded.
+//
+// The piece table is stored as a graph: every piece reachable from the
+// current piece chain or from a change is recorded along with the ids of
+// its neighbours. A piece id of 0 represents nil.
+type History struct {
+	Pieces  []HistoryPiece
+	Begin   int             // id of the sentinel piece at the start of the chain
+	End     int             // id of the sentinel piece at the end of the chain
+	Actions []HistoryAction // every action in the undo tree in order of creation
+	Path    []int           // ids of the actions followed by Undo and Redo
+	Head    int             // number of actions in Path that are applied
+	Saved   int             // id of the action at the last Clean or 0
+
+	Seq    int // undo sequence of the body
+	PutSeq int // undo sequence of the body at the last Put
+}
+
+// HistoryPiece is a piece of the piece table.
+type HistoryPiece struct {
+	ID   int
+	Prev int
+	Next int
+	Data string `json:",omitempty"`
+}
+
+// HistoryAction is an action of the undo tree.
+type HistoryAction struct {
+	ID      int
+	Parent  int // id of the parent action or 0 for the unmodified state
+	Seq     int
+	Time    time.Time
+	Kind    int    `json:",omitempty"`
+	Fname   string `json:",omitempty"`
+	Changes []HistoryChange
+}
+
+// HistoryChange is a change made by an action.
+type HistoryChange struct {
+	Off  int
+	Roff int
+	Old  HistorySpan
+	New  HistorySpan
+}
+
+// HistorySpan is a span of pieces replaced or inserted by a change.
+type HistorySpan struct {
+	Start int
+	End   int
+	Len   int
+}
@@ -61,6 +61,14 @@ type Window struct {
 	// Body.Buffer is empty if Type == Unsaved.
 	Body Text
 
+	// Undo history of the body. Restored if it matches the body's
+	// contents when the window is loaded.
+	History *History `json:",omitempty"`
+
+	// Named marks of the body (see file.Marks), kept if they are
+	// within the body when the window is loaded.
+	Marks map[string]Mark `json:",omitempty"`
+
 	// Used for Type == Exec
 	ExecDir     string `json:",omitempty"` // Execute command in this directory
 	ExecCommand string `json:",omitempty"` // Command to execute
@@ -74,6 +82,11 @@ type Text struct {
 	Q1     int    // Selection ends before this rune position
 }
 
+// Mark is a range of runes of a body.
+type Mark struct {
+	Q0, Q1 int
+}
+
 type versionedContent struct {
 	Version int // Dump file format version
 	*Content
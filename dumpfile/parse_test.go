@@ -31,6 +31,48 @@ var testTab = []Content{
 		},
 		Windows: []*Window{},
 	},
+	{
+		CurrentDir: "/home/gopher",
+		Columns: []Column{
+			{
+				Position: 0,
+			},
+		},
+		Windows: []*Window{
+			{
+				Type: Unsaved,
+				Tag: Text{
+					Buffer: "/home/gopher/hello.txt Del Snarf | Look",
+				},
+				Body: Text{
+					Buffer: "hello",
+				},
+				History: &History{
+					Pieces: []HistoryPiece{
+						{ID: 1, Next: 3},
+						{ID: 2, Prev: 3},
+						{ID: 3, Prev: 1, Next: 2, Data: "hello"},
+					},
+					Begin: 1,
+					End:   2,
+					Actions: []HistoryAction{
+						{
+							ID:  1,
+							Seq: 1,
+							Changes: []HistoryChange{
+								{
+									New: HistorySpan{Start: 3, End: 3, Len: 5},
+								},
+							},
+						},
+					},
+					Path: []int{1},
+					Head: 1,
+					Seq:  1,
+				},
+			},
+		},
+	},
 }
 
 func TestEncodeDecode(t *testing.T) {
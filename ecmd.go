@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/rjkroege/edwood/file"
 	"github.com/rjkroege/edwood/util"
@@ -53,7 +54,7 @@ func cmdexec(t *Text, cp *Cmd) bool {
 	}
 
 	if w == nil && (cp.addr == nil || cp.addr.typ != '"') &&
-		!strings.ContainsRune("bBnqUXY!", cp.cmdc) && // Commands that don't need a window
+		!strings.ContainsRune("bBnqFUXY!", cp.cmdc) && // Commands that don't need a window
 		!(cp.cmdc == 'D' && len(cp.text) > 0) {
 		editerror("no current window")
 	}
@@ -320,6 +321,17 @@ func i_cmd(t *Text, cp *Cmd) bool {
 	return appendx(t.file, cp, addr.r.q0)
 }
 
+// k_cmd sets the mark of the file named by its argument, or the
+// unnamed mark, to the address, for the ' address.
+func k_cmd(t *Text, cp *Cmd) bool {
+	name := strings.TrimSpace(cp.text)
+	if !validmarkname(name) {
+		editerror("bad mark name %q", name)
+	}
+	t.file.Marks().Set(name, addr.r.q0, addr.r.q1)
+	return true
+}
+
 func copyx(f *file.ObservableEditableBuffer, addr2 Address) {
 	ni := 0
 	buf := make([]rune, RBUFSIZE)
@@ -367,6 +379,18 @@ func m_cmd(t *Text, cp *Cmd) bool {
 	return true
 }
 
+// n_cmd prints the file menu, a line for the file of each window.
+func n_cmd(t *Text, cp *Cmd) bool {
+	seen := make(map[*file.ObservableEditableBuffer]bool)
+	global.row.AllWindows(func(w *Window) {
+		if f := w.body.file; !seen[f] {
+			seen[f] = true
+			pfilename(f)
+		}
+	})
+	return true
+}
+
 func p_cmd(t *Text, cp *Cmd) bool {
 	return pdisplay(t.file)
 }
@@ -456,6 +480,14 @@ func s_cmd(t *Text, cp *Cmd) bool {
 }
 
 func u_cmd(t *Text, cp *Cmd) bool {
+	if cp.text != "" {
+		when, err := parseundotime(cp.text, time.Now())
+		if err != nil {
+			editerror("%v", err)
+		}
+		undotime(t.w, when)
+		return true
+	}
 	n := cp.num
 	flag := true
 	if n < 0 {
@@ -493,6 +525,20 @@ func x_cmd(t *Text, cp *Cmd) bool {
 	return true
 }
 
+// findcmd returns the first command of cmds that is cp, or follows or
+// is held by it, or 0 if there is none.
+func findcmd(cp *Cmd, cmds string) rune {
+	for ; cp != nil; cp = cp.next {
+		if strings.ContainsRune(cmds, cp.cmdc) {
+			return cp.cmdc
+		}
+		if c := findcmd(cp.cmd, cmds); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
 func X_cmd(t *Text, cp *Cmd) bool {
 	filelooper(t, cp, cp.cmdc == 'X')
 	return true
@@ -527,7 +573,12 @@ func runpipe(t *Text, cmd rune, cr []rune, state int) {
 			}
 		}
 	}
-	s = append([]rune{cmd}, r...)
+	if cmd == '!' {
+		// Not connected to the file: the output goes to the Errors window.
+		s = r
+	} else {
+		s = append([]rune{cmd}, r...)
+	}
 
 	dir := t.DirName("") // exec.Cmd.Dir
 	global.editing = state
@@ -570,29 +621,22 @@ func pipe_cmd(t *Text, cp *Cmd) bool {
 	return true
 }
 
+// plan9_cmd runs a command for its side effects.
+func plan9_cmd(t *Text, cp *Cmd) bool {
+	runpipe(t, cp.cmdc, []rune(cp.text), Inactive)
+	return true
+}
+
+// nlcount returns the number of newlines in t between q0 and q1 and the
+// number of runes between the last of them (or q0) and q1.
 func nlcount(t *Text, q0, q1 int) (nl, pnr int) {
-	buf := make([]rune, RBUFSIZE)
-	i := 0
-	nl = 0
-	start := q0
-	nbuf := 0
-	for q0 < q1 {
-		if i == nbuf {
-			nbuf = q1 - q0
-			if nbuf > RBUFSIZE {
-				nbuf = RBUFSIZE
-			}
-			t.file.Read(q0, buf[:nbuf])
-			i = 0
-		}
-		if buf[i] == '\n' {
-			start = q0 + 1
-			nl++
-		}
-		i++
-		q0++
+	l := t.file.RuneToLine(q1)
+	nl = l - t.file.RuneToLine(q0)
+	if nl == 0 {
+		return 0, q1 - q0
 	}
-	return nl, q0 - start
+	start, _ := t.file.LineToRune(l)
+	return nl, q1 - start
 }
 
 const (
@@ -739,21 +783,27 @@ func loopcmd(file *file.ObservableEditableBuffer, cp *Cmd, rp []Range) {
 }
 
 func looper(file *file.ObservableEditableBuffer, cp *Cmd, isX bool) {
-	rp := []Range{}
-	tr := Range{}
-	r := addr.r
-	isY := !isX
 	nest++
 	are, err := rxcompile(cp.re)
 	if err != nil {
 		editerror("bad regexp in %c command", cp.cmdc)
 	}
+	rp := loopranges(file.GetCurObserver().(*Text), are, addr.r, isX)
+	loopcmd(file, cp.cmd, rp)
+	nest--
+}
+
+// loopranges returns the ranges in r of t that an x command (if isX) or a
+// y command with the regexp are loops over.
+func loopranges(t *Text, are *AcmeRegexp, r Range, isX bool) []Range {
+	rp := []Range{}
+	tr := Range{}
+	isY := !isX
 	/*if isX */ op := -1 // Not used in the X case.
 	if isY {
 		op = r.q0
 	}
-	cur := file.GetCurObserver().(*Text)
-	sels := are.rxexecute(cur, nil, r.q0, r.q1, -1)
+	sels := are.rxexecute(t, nil, r.q0, r.q1, -1)
 	if len(sels) == 0 {
 		if isY {
 			rp = append(rp, Range{r.q0, r.q1})
@@ -776,8 +826,7 @@ func looper(file *file.ObservableEditableBuffer, cp *Cmd, isX bool) {
 			rp = append(rp, tr)
 		}
 	}
-	loopcmd(file, cp.cmd, rp)
-	nest--
+	return rp
 }
 
 func linelooper(file *file.ObservableEditableBuffer, cp *Cmd) {
@@ -976,8 +1025,12 @@ func cmdaddress(ap *Addr, a Address, sign int) Address {
 			a.r.q1 = a.r.q0
 
 		case '\'':
-			editerror("can't handle '")
-			//			a.r = f.mark;
+			// The unnamed mark is at 0 until set, as in sam.
+			q0, q1, ok := file.Marks().Get(ap.name)
+			if !ok && ap.name != "" {
+				editerror("no mark '%s", ap.name)
+			}
+			a.r = Range{q0, q1}
 
 		case '?':
 			sign = -sign
@@ -1183,29 +1236,35 @@ func lineaddr(l int, addr Address, sign int) Address {
 			p = addr.r.q1 - 1
 		} else {
 			if sign == 0 || addr.r.q1 == 0 {
-				p = 0
-				n = 1
+				q, ok := f.LineToRune(l - 1)
+				if !ok {
+					editerror("address out of range")
+				}
+				p = q
 			} else {
 				p = addr.r.q1 - 1
 				if file.ReadC(p) == '\n' {
 					n = 1
 				}
 				p++
-			}
-			for n < l {
-				// TODO(rjk) utf8 buffer issue p
-				if p >= file.Nr() {
-					editerror("address out of range")
-				}
-				if f.ReadC(p) == '\n' {
-					n++
+				for n < l {
+					// TODO(rjk) utf8 buffer issue p
+					if p >= file.Nr() {
+						editerror("address out of range")
+					}
+					if f.ReadC(p) == '\n' {
+						n++
+					}
+					p++
 				}
-				p++
 			}
 			a.r.q0 = p
 		}
-		for p < f.Nr() && f.ReadC(p) != '\n' {
-			p++
+		// Up to the first newline at or after p.
+		if q, ok := f.LineToRune(f.RuneToLine(p) + 1); ok {
+			p = q - 1
+		} else {
+			p = f.Nr()
 		}
 		a.r.q1 = p
 	} else {
@@ -191,7 +191,7 @@ func benchmarkLargeEditTargetsImpl(t *testing.B, nl int) {
 				tc.fn(b, global)
 				b.StopTimer()
 
-				got, err := global.row.dump()
+				got, err := global.row.dumpstate(false)
 				if err != nil {
 					b.Fatalf("dump failed: %v", err)
 				}
@@ -17,6 +17,8 @@ var (
 	errRegexpMissing    = fmt.Errorf("no regular expression defined")
 	errLeftBraceMissing = fmt.Errorf("right brace with no left brace")
 	errBadRHS           = fmt.Errorf("bad right hand side")
+	errGlobMissing      = fmt.Errorf("no file pattern")
+	errBadMarkName      = fmt.Errorf("bad mark name")
 )
 
 type invalidCmdError rune
@@ -32,9 +34,10 @@ func (e badDelimiterError) Error() string {
 }
 
 type Addr struct {
-	typ  rune // # (byte addr), l (line addr), / ? . $ + - , ;
+	typ  rune // # (byte addr), l (line addr), / ? . $ + - , ; '
 	re   string
-	left *Addr // left side of , and ;
+	name string // of the mark for '
+	left *Addr  // left side of , and ;
 	num  int
 	next *Addr // or right side of , and ;
 }
@@ -100,7 +103,9 @@ var cmdtab = []Cmdtab{
 	{'f', false, false, false, 0, aNo, cNo, wordx, f_cmd},
 	{'g', false, true, false, 'p', aDot, cNo, "", nil}, // Assingned to g_cmd in init() to avoid initialization loop
 	{'i', true, false, false, 0, aDot, cNo, "", i_cmd},
+	{'k', false, false, false, 0, aDot, cNo, wordx, k_cmd},
 	{'m', false, false, true, 0, aDot, cNo, "", m_cmd},
+	{'n', false, false, false, 0, aNo, cNo, "", n_cmd},
 	{'p', false, false, false, 0, aDot, cNo, "", p_cmd},
 	{'r', false, false, false, 0, aDot, cNo, wordx, e_cmd},
 	{'s', false, true, false, 0, aDot, cUnsigned, "", s_cmd},
@@ -118,11 +123,10 @@ var cmdtab = []Cmdtab{
 	{'<', false, false, false, 0, aDot, cNo, linex, pipe_cmd},
 	{'|', false, false, false, 0, aDot, cNo, linex, pipe_cmd},
 	{'>', false, false, false, 0, aDot, cNo, linex, pipe_cmd},
+	{'!', false, false, false, 0, aNo, cNo, linex, plan9_cmd},
+	{'F', false, false, false, 'f', aNo, cNo, "", nil}, // Assingned to F_cmd in init() to avoid initialization loop
 	/* deliberately unimplemented:
-	{'k', false, false, false, 0, aDot, cNo, "", k_cmd},
-	{'n', false, false, false, 0, aNo, cNo, "", n_cmd},
 	{'q', false, false, false, 0, aNo, cNo, "", q_cmd},
-	{'!', false, false, false, 0, aNo, cNo, linex, plan9_cmd},
 	*/
 }
 
@@ -135,6 +139,8 @@ func init() {
 			cmdtab[i].fn = x_cmd
 		case 'X', 'Y':
 			cmdtab[i].fn = X_cmd
+		case 'F':
+			cmdtab[i].fn = F_cmd
 		}
 	}
 }
@@ -143,6 +149,9 @@ var (
 	editerrc chan error
 
 	lastpat string
+
+	// previewing is true while an Edit command runs for a preview.
+	previewing bool
 )
 
 type cmdParser struct {
@@ -159,6 +168,9 @@ func editthread(cp *cmdParser) {
 		if cmd == nil {
 			break
 		}
+		if c := findcmd(cmd, previewrefused); previewing && c != 0 {
+			editerror("%c in a preview", c)
+		}
 		if !cmdexec(curtext, cmd) {
 			break
 		}
@@ -198,23 +210,37 @@ func allupdate(w *Window) {
 func editerror(format string, args ...interface{}) {
 	s := fmt.Errorf(format, args...)
 	global.row.AllWindows(allelogterm) // truncate the edit logs
+	for _, w := range previewfiles {
+		closeheadless(w)
+	}
+	previewfiles = nil
 	editerrc <- s
 	runtime.Goexit()
 }
 
 func editcmd(ct *Text, r []rune) {
-	if len(r) == 0 {
+	if !editrun(ct, r) {
 		return
 	}
+	// update everyone whose edit log has data
+	global.row.AllWindows(allupdate)
+}
+
+// editrun runs the Edit command r from ct, leaving its changes in the
+// edit logs of the windows. It returns false if r could not be run.
+func editrun(ct *Text, r []rune) bool {
+	if len(r) == 0 {
+		return false
+	}
 
 	if len(r) > 2*RBUFSIZE {
 		warning(nil, "string too long\n")
-		return
+		return false
 	}
 
 	global.row.AllWindows(alleditinit)
 	cp := newCmdParser(r)
-	if ct.w == nil {
+	if ct == nil || ct.w == nil {
 		curtext = nil
 	} else {
 		curtext = &ct.w.body
@@ -232,8 +258,7 @@ func editcmd(ct *Text, r []rune) {
 	if err != nil {
 		warning(nil, "Edit: %s\n", err)
 	}
-	// update everyone whose edit log has data
-	global.row.AllWindows(allupdate)
+	return true
 }
 
 func newCmdParser(r []rune) *cmdParser {
@@ -294,6 +319,39 @@ func (cp *cmdParser) getnum(signok bool) int {
 	return sign * n
 }
 
+// getword returns the characters up to the next blank, newline or the
+// end of the command.
+func (cp *cmdParser) getword() string {
+	var s strings.Builder
+	for {
+		c := cp.nextc()
+		if c <= 0 || c == ' ' || c == '\t' || c == '\n' {
+			break
+		}
+		s.WriteRune(cp.getch())
+	}
+	return s.String()
+}
+
+// getmarkname returns the name of the mark after a ', which is quoted,
+// as in '"todo", or empty for the unnamed mark. Unquoted, a ' followed by
+// a command, as in 'd, is the unnamed mark as in sam.
+func (cp *cmdParser) getmarkname() (string, error) {
+	if cp.nextc() != '"' {
+		return "", nil
+	}
+	cp.getch()
+	var s strings.Builder
+	for c := cp.nextc(); c != '"'; c = cp.nextc() {
+		if !isalnum(c) {
+			return "", errBadMarkName
+		}
+		s.WriteRune(cp.getch())
+	}
+	cp.getch()
+	return s.String(), nil
+}
+
 func (cp *cmdParser) skipbl() rune {
 	var c rune
 	for {
@@ -473,6 +531,25 @@ func (cp *cmdParser) parse(nest int) (*Cmd, error) {
 		if ct.count != cNo {
 			cmd.num = cp.getnum(ct.count == cSigned)
 		}
+		if ct.cmdc == 'u' && cp.nextc() == '@' {
+			// u@time undoes to a point in time.
+			cp.getch()
+			cmd.text = cp.getword()
+		}
+		if ct.cmdc == 'F' {
+			cp.skipbl()
+			c := cp.getch()
+			if c == '\n' || c < 0 {
+				return nil, errGlobMissing
+			}
+			if !okdelim(c) {
+				return nil, badDelimiterError(c)
+			}
+			cmd.text, err = cp.getglob(c)
+			if err != nil {
+				return nil, err
+			}
+		}
 		if ct.regexp {
 			// x without pattern . .*\n, indicated by cmd.re==0
 			// X without pattern is all files
@@ -486,7 +563,7 @@ func (cp *cmdParser) parse(nest int) (*Cmd, error) {
 				if !okdelim(c) {
 					return nil, badDelimiterError(c)
 				}
-				cmd.re, err = cp.getregexp(c)
+				cmd.re, err = cp.getregexp(c, ct.cmdc != 's')
 				if err != nil {
 					return nil, err
 				}
@@ -498,8 +575,17 @@ func (cp *cmdParser) parse(nest int) (*Cmd, error) {
 					}
 					if cp.nextc() == c {
 						cp.getch()
-						if cp.nextc() == 'g' {
-							cmd.flag = cp.getch()
+						var flags string
+						for c := cp.nextc(); c == 'g' || strings.ContainsRune(rxflagchars, c); c = cp.nextc() {
+							if c == 'g' {
+								cmd.flag = cp.getch()
+							} else {
+								flags += string(cp.getch())
+							}
+						}
+						if flags != "" {
+							cmd.re = rxflags(cmd.re, flags)
+							lastpat = cmd.re
 						}
 					}
 
@@ -576,7 +662,9 @@ func (cp *cmdParser) parse(nest int) (*Cmd, error) {
 	return &cmd, nil
 }
 
-func (cp *cmdParser) getregexp(delim rune) (string, error) {
+// getregexp returns the regular expression up to delim and, if flags is
+// true, with the flags after it (see rxflags).
+func (cp *cmdParser) getregexp(delim rune, flags bool) (string, error) {
 	var c rune
 
 	buf := string("")
@@ -603,6 +691,9 @@ func (cp *cmdParser) getregexp(delim rune) (string, error) {
 	}
 	if len(buf) > 0 {
 		lastpat = buf
+		if c == delim && flags {
+			lastpat = rxflags(buf, cp.getflags())
+		}
 	}
 	if len(lastpat) == 0 {
 		return "", errRegexpMissing
@@ -610,6 +701,50 @@ func (cp *cmdParser) getregexp(delim rune) (string, error) {
 	return lastpat, nil
 }
 
+// getglob returns the file pattern of F up to delim, which may be
+// escaped in it with a backslash. So that the pattern can hold slashes,
+// a / delimiter ends it only if followed by a blank or newline.
+func (cp *cmdParser) getglob(delim rune) (string, error) {
+	var s strings.Builder
+	for {
+		c := cp.getch()
+		if c == '\\' && cp.nextc() == delim {
+			c = cp.getch()
+		} else if c == delim && (c != '/' || strings.ContainsRune(" \t\n", cp.nextc())) {
+			break
+		} else if c == '\n' {
+			cp.ungetch()
+			break
+		}
+		s.WriteRune(c)
+	}
+	if s.Len() == 0 {
+		return "", errGlobMissing
+	}
+	return s.String(), nil
+}
+
+// getflags returns the flags of a regular expression, a run of the
+// letters in rxflagchars. As i and s are also commands, the run is only
+// taken for flags if a command follows it, as in /re/i p: followed by a
+// delimiter or newline, it is a command and its text, as in /re/i/text/.
+func (cp *cmdParser) getflags() string {
+	i := cp.pos
+	for i < len(cp.buf) && strings.ContainsRune(rxflagchars, cp.buf[i]) {
+		i++
+	}
+	j := i
+	for j < len(cp.buf) && (cp.buf[j] == ' ' || cp.buf[j] == '\t') {
+		j++
+	}
+	if j == len(cp.buf) || cp.buf[j] == '\n' || okdelim(cp.buf[j]) {
+		return ""
+	}
+	flags := string(cp.buf[cp.pos:i])
+	cp.pos = i
+	return flags
+}
+
 func (cp *cmdParser) simpleaddr() (*Addr, error) {
 	var addr Addr
 
@@ -623,12 +758,19 @@ func (cp *cmdParser) simpleaddr() (*Addr, error) {
 	case '/', '?', '"':
 		addr.typ = cp.getch()
 		var err error
-		addr.re, err = cp.getregexp(addr.typ)
+		addr.re, err = cp.getregexp(addr.typ, true)
 		if err != nil {
 			return nil, err
 		}
-	case '.', '$', '+', '-', '\'':
+	case '.', '$', '+', '-':
+		addr.typ = cp.getch()
+	case '\'':
 		addr.typ = cp.getch()
+		var err error
+		addr.name, err = cp.getmarkname()
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, nil
 	}
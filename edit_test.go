@@ -53,6 +53,26 @@ func TestEdit(t *testing.T) {
 
 		// f - Don't know how to test f
 
+		// k and '
+		{Range{0, 0}, "test", "/short/k\n'a/@/", "This is a\nshort@ text\nto try addressing\n", []string{}},
+		{Range{0, 0}, "test", "/text/k\n/This/,'d", "\nto try addressing\n", []string{}},
+		{Range{5, 7}, "test", "'i/@/", "@This is a\nshort text\nto try addressing\n", []string{}},
+
+		// Regular expression flags
+		{Range{0, 0}, "test", ",x/T/i d", "his is a\nshor ex\no ry addressing\n", []string{}},
+		{Range{0, 0}, "test", ",x/is/s/i/I/", "ThIs Is a\nshort text\nto try addressing\n", []string{}},
+		{Range{0, 0}, "test", "/SHORT/i c/long/", "This is a\nlong text\nto try addressing\n", []string{}},
+		{Range{0, 0}, "test", "/text.to/s d", "This is a\nshort  try addressing\n", []string{}},
+		{Range{0, 0}, "test", "/short/i\n@@\n.\n", "This is a\n@@\nshort text\nto try addressing\n", []string{}},
+		{Range{0, 0}, "test", "/short/i/@/", "This is a\n@short text\nto try addressing\n", []string{}},
+		{Range{0, 0}, "test", ",s/S/z/gi", "Thiz iz a\nzhort text\nto try addrezzing\n", []string{}},
+		{Range{0, 0}, "test", ",s/t.y/z/l", "This is a\nshort text\nto try addressing\n", []string{"Edit: no substitution\n"}},
+		{Range{0, 0}, "test", ",x/A./il d", "This is a\nshort text\nto try addressing\n", []string{}},
+		{Range{0, 0}, "test", "/short/k a\n/try/k todo\n'\"a\",'\"todo\"d", "This is a\n addressing\n", []string{}},
+		{Range{0, 0}, "test", "'\"nomark\" d", "This is a\nshort text\nto try addressing\n", []string{"Edit: no mark 'nomark\n"}},
+		{Range{0, 0}, "test", "k a-b", "This is a\nshort text\nto try addressing\n", []string{"Edit: bad mark name \"a-b\"\n"}},
+		{Range{0, 0}, "test", "'\"a-b\" d", "This is a\nshort text\nto try addressing\n", []string{"Edit: bad mark name\n"}},
+
 		// g/v
 		{Range{0, 0}, "test", "g/This/d", "This is a\nshort text\nto try addressing\n", []string{}},
 		{Range{0, 12}, "test", "g/This/d", "ort text\nto try addressing\n", []string{}},
@@ -77,6 +97,9 @@ func TestEdit(t *testing.T) {
 		{Range{1, 3}, "test", "=+", "This is a\nshort text\nto try addressing\n", []string{"test:1+#1\n"}},
 		{Range{1, 3}, "test", "=#", "This is a\nshort text\nto try addressing\n", []string{"test:#1,#3\n"}},
 
+		// n
+		{Range{0, 0}, "test", "n", "This is a\nshort text\nto try addressing\n", []string{" +. test\n +  alt_example_2\n"}},
+
 		// p
 		{Range{0, 4}, "test", "p", "This is a\nshort text\nto try addressing\n", []string{"This"}},
 
@@ -149,6 +172,80 @@ func TestEdit(t *testing.T) {
 	}
 }
 
+func TestEditBang(t *testing.T) {
+	runfunc = mockrun
+	defer func() { runfunc = run }()
+	global.cedit = make(chan int)
+	warnings = nil
+	mockruncmd = ""
+
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("test"),
+		ScBody("test", contents),
+		ScBodyRange("test", Range{0, 4}),
+	)
+	w := global.row.col[0].w[0]
+
+	global.row.lk.Lock()
+	w.Lock('M')
+	editcmd(&w.body, []rune("!date"))
+	w.Unlock()
+	global.row.lk.Unlock()
+
+	if got, want := mockruncmd, "date"; got != want {
+		t.Errorf("ran %q; want %q", got, want)
+	}
+	if got := w.body.file.String(); got != contents {
+		t.Errorf("body changed to %q; want %q", got, contents)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("got %d warnings; want none", len(warnings))
+	}
+}
+
+func TestEditReadOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fn   func(w *Window)
+	}{
+		{"Edit", func(w *Window) { editcmd(&w.body, []rune(",d")) }},
+		{"u", func(w *Window) { editcmd(&w.body, []rune("u")) }},
+		{"u@", func(w *Window) { editcmd(&w.body, []rune("u@1h")) }},
+		{"Cut", func(w *Window) { cut(&w.tag, &w.body, nil, true, true, "") }},
+		{"Undo", func(w *Window) { undo(&w.body, nil, nil, true, false, "-branch") }},
+		{"Type", func(w *Window) { w.body.Type('x') }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			warnings = nil
+			FlexiblyMakeWindowScaffold(
+				t,
+				ScWin("test"),
+				ScBody("test", contents),
+				ScBodyRange("test", Range{0, 4}),
+			)
+			w := global.row.col[0].w[0]
+			w.body.file.Mark(1)
+			w.body.file.InsertAt(0, []rune("x"))
+			w.body.file.SetReadOnly(true)
+			want := w.body.file.String()
+
+			global.row.lk.Lock()
+			w.Lock('M')
+			tc.fn(w)
+			w.Unlock()
+			global.row.lk.Unlock()
+
+			if got := w.body.file.String(); got != want {
+				t.Errorf("read-only body modified: got %q, want %q", got, want)
+			}
+			if w.body.q0 > w.body.file.Nr() || w.body.q1 > w.body.file.Nr() {
+				t.Errorf("selection %d,%d beyond the body", w.body.q0, w.body.q1)
+			}
+		})
+	}
+}
+
 // TODO(rjk): Make longer names.
 const contents = "This is a\nshort text\nto try addressing\n"
 const alt_contents = "A different text\nWith other contents\nSo there!\n"
@@ -484,6 +581,8 @@ func TestParsecmd(t *testing.T) {
 		{[]rune("u\n"), &Cmd{num: 1, cmdc: 'u'}, nil},
 		{[]rune("u5\n"), &Cmd{num: 5, cmdc: 'u'}, nil},
 		{[]rune("u-3\n"), &Cmd{num: -3, cmdc: 'u'}, nil},
+		{[]rune("u@10m\n"), &Cmd{num: 1, text: "10m", cmdc: 'u'}, nil},
+		{[]rune("u@12:30 \n"), &Cmd{num: 1, text: "12:30", cmdc: 'u'}, nil},
 	}
 	for _, tc := range tt {
 		lastpat = ""
@@ -669,7 +768,12 @@ func TestBadDelimiterError(t *testing.T) {
 	}
 }
 
+// mockruncmd is the command last run by mockrun.
+var mockruncmd string
+
 func mockrun(win *Window, s string, rdir string, newns bool, argaddr string, xarg string, iseditcmd bool) {
+	mockruncmd = s
+
 	// Optionally generate an error.
 	if s[1:] == "error" {
 		// TODO(rjk): Create more complex error cases.
@@ -686,7 +790,7 @@ func mockrun(win *Window, s string, rdir string, newns bool, argaddr string, xar
 
 		ds := fmt.Sprintf("{%#v %#v %#v %#v %#v %#v}", s, rdir, newns, argaddr, xarg, iseditcmd)
 
-		if s[0] != '>' {
+		if s[0] != '>' && win != nil {
 			global.row.lk.Lock()
 			win.Lock('M')
 			edittext(win, 4, []rune(ds))
@@ -838,7 +942,7 @@ func TestComplexEditActions(t *testing.T) {
 
 			t.Log(*varfontflag, defaultVarFont)
 
-			got, err := global.row.dump()
+			got, err := global.row.dumpstate(false)
 			if err != nil {
 				t.Fatalf("dump failed: %v", err)
 			}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rjkroege/edwood/file"
+	"github.com/rjkroege/edwood/sam"
+)
+
+// F/pattern/ cmd runs cmd on each file that matches pattern, whether or
+// not a window holds it, as X runs it on windows. The pattern is a file
+// name pattern, as for filepath.Match, relative to the directory of the
+// current window, in which an element ** matches any number of
+// directories: F/**/*.go/ matches every Go file in the directory tree.
+// A / delimiter ends the pattern only if followed by a blank or newline.
+// Directories whose names begin with a dot are skipped.
+//
+// A file that no window holds is read into a window without a display,
+// which is discarded after cmd has run. If cmd changed its text, a
+// window is opened on the file with the changes, which are left unsaved.
+// Files with NUL bytes, which Get would elide, are skipped. The commands
+// that close windows or write files, D and w, can't be used in F.
+
+// frefused are the Edit commands that can't be used in F.
+const frefused = "Dw"
+
+// hasglobmeta returns true if the element elem of a file name pattern
+// has special characters.
+func hasglobmeta(elem string) bool {
+	return strings.ContainsAny(elem, `*?[\`)
+}
+
+// globmatch returns true if the elements of a file name match those of
+// pattern, where ** matches any number of elements.
+func globmatch(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if globmatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// globprefix returns true if the elements of the names of the files in
+// a directory can match those of pattern, given the elements of the
+// name of the directory.
+func globprefix(pattern, dir []string) bool {
+	for len(dir) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], dir[0]); !ok {
+			return false
+		}
+		pattern, dir = pattern[1:], dir[1:]
+	}
+	return len(pattern) > 0
+}
+
+// globfiles returns the names of the files that match pattern, relative
+// to dir, in lexical order.
+func globfiles(dir, pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(pattern)
+	if !filepath.IsAbs(filepath.FromSlash(pattern)) {
+		pattern = filepath.ToSlash(dir) + "/" + pattern
+	}
+	elems := strings.Split(path.Clean(pattern), "/")
+
+	// Walk from the last directory before a special character.
+	i := 0
+	for i < len(elems)-1 && !hasglobmeta(elems[i]) {
+		i++
+	}
+	root := filepath.FromSlash(strings.Join(elems[:i], "/") + "/")
+	pattern = strings.Join(elems[i:], "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	elems = elems[i:]
+
+	var names []string
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Leave out what can't be read.
+			return nil
+		}
+		if name == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return nil
+		}
+		relems := strings.Split(filepath.ToSlash(rel), "/")
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") || !globprefix(elems, relems) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && globmatch(elems, relems) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// loadheadless returns a window without a display that holds the file
+// name. It has no id and isn't journalled: only the window that
+// openchanged opens on the file is. It must be discarded with
+// closeheadless.
+func loadheadless(name string) (*Window, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	w := NewWindow()
+	w.initUnnumbered(nil)
+	f := w.body.file
+	f.SetName(name)
+	_, hasNulls, err := f.Load(0, fd, true)
+	if err == nil && hasNulls {
+		err = fmt.Errorf("%s: has NUL bytes; skipped", name)
+	}
+	if err != nil {
+		closeheadless(w)
+		return nil, err
+	}
+	f.Clean()
+	return w, nil
+}
+
+// closeheadless discards a window returned by loadheadless.
+func closeheadless(w *Window) {
+	w.body.file.Close()
+}
+
+// openchanged opens a window on the file name that will make the
+// changes in elog, if the file on disk still has the given hash.
+func openchanged(t *Text, name string, hash file.Hash, elog sam.Elog) {
+	w := makenewwindow(t)
+	w.SetName(name)
+	w.body.Load(0, name, true)
+	w.body.file.Clean()
+	xfidlog(w, "new")
+	if !w.body.file.Hash().Eq(hash) {
+		warning(nil, "%s changed on disk; not edited\n", name)
+		return
+	}
+	// As alleditinit would have, had the window been open.
+	w.body.file.EditClean = false
+	w.body.file.Elog = elog
+}
+
+func F_cmd(t *Text, cp *Cmd) bool {
+	if Glooping != 0 {
+		editerror("can't nest F command")
+	}
+	if c := findcmd(cp.cmd, frefused); c != 0 {
+		editerror("can't use %c in F command", c)
+	}
+	dir := global.wdir
+	if t != nil && t.w != nil {
+		dir = t.w.body.DirName("")
+	}
+	names, err := globfiles(dir, cp.text)
+	if err != nil {
+		editerror("bad file pattern: %v", err)
+	}
+	Glooping++
+	nest++
+
+	// Unlike X, keep the window running F locked, as an error ends the
+	// Edit command at once, and lock each other window in turn.
+	for _, name := range names {
+		if w := lookfile(name); w != nil {
+			if t != nil && t.w != nil && w.body.file == t.w.body.file {
+				// Locked with its clones.
+				cmdexec(&w.body, cp.cmd)
+				continue
+			}
+			flocked(w, cp)
+			continue
+		}
+		hw, err := loadheadless(name)
+		if err != nil {
+			warning(nil, "%v\n", err)
+			continue
+		}
+		fheadless(t, hw, cp)
+	}
+
+	Glooping--
+	nest--
+	return true
+}
+
+// flocked runs the command of F cp on the window w, locked while it
+// runs, even if an error ends the Edit command.
+func flocked(w *Window, cp *Cmd) {
+	w.Lock(int(cp.cmdc))
+	defer w.Unlock()
+	cmdexec(&w.body, cp.cmd)
+}
+
+// fheadless runs the command of F cp, run from t, on the window w
+// returned by loadheadless, and opens a window with its changes, if
+// any. When previewing, w is kept in previewfiles instead.
+func fheadless(t *Text, w *Window, cp *Cmd) {
+	keep := false
+	defer func() {
+		if !keep {
+			closeheadless(w)
+		}
+	}()
+	cmdexec(&w.body, cp.cmd)
+	f := w.body.file
+	switch {
+	case f.Elog.Empty():
+	case previewing:
+		previewfiles = append(previewfiles, w)
+		keep = true
+	default:
+		openchanged(t, f.Name(), f.Hash(), f.Elog)
+	}
+}
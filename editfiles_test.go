@@ -0,0 +1,274 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobmatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.go", "a.go", true},
+		{"*.go", "sub/a.go", false},
+		{"**/*.go", "a.go", true},
+		{"**/*.go", "sub/deep/a.go", true},
+		{"sub/**", "sub/deep/a.go", true},
+		{"sub/**/a.go", "sub/a.go", true},
+		{"sub/**/a.go", "other/a.go", false},
+		{"**/deep/*", "sub/deep/a.go", true},
+		{"**/deep/*", "sub/deep", false},
+	} {
+		if got := globmatch(strings.Split(tc.pattern, "/"), strings.Split(tc.name, "/")); got != tc.want {
+			t.Errorf("globmatch(%q, %q) = %v; want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestGlobprefix(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, dir string
+		want         bool
+	}{
+		{"*.go", "sub", false},
+		{"sub/*.go", "sub", true},
+		{"sub/*.go", "other", false},
+		{"sub/*.go", "sub/deep", false},
+		{"*/*.go", "sub", true},
+		{"**/*.go", "sub/deep", true},
+		{"sub/**/*.go", "sub/deep/deeper", true},
+		{"sub/**/*.go", "other/deep", false},
+	} {
+		if got := globprefix(strings.Split(tc.pattern, "/"), strings.Split(tc.dir, "/")); got != tc.want {
+			t.Errorf("globprefix(%q, %q) = %v; want %v", tc.pattern, tc.dir, got, tc.want)
+		}
+	}
+}
+
+// makefiles makes the files of contents, by name, in dir.
+func makefiles(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+	for name, s := range contents {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGlobfiles(t *testing.T) {
+	dir := t.TempDir()
+	makefiles(t, dir, map[string]string{
+		"a.go":             "",
+		"b.txt":            "",
+		"sub/c.go":         "",
+		"sub/deep/d.go":    "",
+		".git/e.go":        "",
+		"sub/.hidden/f.go": "",
+	})
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.go", []string{"a.go"}},
+		{"**/*.go", []string{"a.go", "sub/c.go", "sub/deep/d.go"}},
+		{"sub/*.go", []string{"sub/c.go"}},
+		{"sub/**/*.go", []string{"sub/c.go", "sub/deep/d.go"}},
+		{filepath.Join(dir, "sub", "*", "*.go"), []string{"sub/deep/d.go"}},
+		{"b.txt", []string{"b.txt"}},
+		{"none/*.go", nil},
+	} {
+		got, err := globfiles(dir, tc.pattern)
+		if err != nil {
+			t.Errorf("globfiles %q failed: %v", tc.pattern, err)
+			continue
+		}
+		var want []string
+		for _, name := range tc.want {
+			want = append(want, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("globfiles %q got %q; want %q", tc.pattern, got, want)
+		}
+	}
+	if _, err := globfiles(dir, "[a-"); err == nil {
+		t.Errorf("globfiles of a bad pattern succeeded")
+	}
+}
+
+func TestEditFiles(t *testing.T) {
+	files := map[string]string{
+		"one.go":     "package one\n",
+		"sub/two.go": "package two\n",
+		"three.go":   "// Nothing to change.\n",
+		"four.txt":   "package four\n",
+	}
+	run := func(t *testing.T, dir, cmd string) *Window {
+		t.Helper()
+		warningsMu.Lock()
+		warnings = nil
+		warningsMu.Unlock()
+		FlexiblyMakeWindowScaffold(
+			t,
+			ScWin("x.go"),
+			ScBody("x.go", "package x\n"),
+			ScDir(dir, "x.go"),
+		)
+		makefiles(t, dir, files)
+		w := global.row.col[0].w[0]
+		global.activecol = w.col
+		global.row.lk.Lock()
+		w.Lock('M')
+		edit(&w.body, nil, nil, false, false, cmd)
+		w.Unlock()
+		global.row.lk.Unlock()
+		return w
+	}
+	warned := func(t *testing.T, want string) {
+		t.Helper()
+		warningsMu.Lock()
+		defer warningsMu.Unlock()
+		var all string
+		for _, wa := range warnings {
+			all += wa.buf.String()
+		}
+		if !strings.Contains(all, want) {
+			t.Errorf("warnings %q lack %q", all, want)
+		}
+	}
+
+	t.Run("Changed", func(t *testing.T) {
+		dir := t.TempDir()
+		w := run(t, dir, "F/**/*.go/ ,s/package/pkg/")
+		if got, want := w.body.file.String(), "pkg x\n"; got != want {
+			t.Errorf("open window got %q; want %q", got, want)
+		}
+		for name, want := range map[string]string{
+			"one.go":     "pkg one\n",
+			"sub/two.go": "pkg two\n",
+			"three.go":   "",
+			"four.txt":   "",
+		} {
+			name = filepath.Join(dir, filepath.FromSlash(name))
+			fw := lookfile(name)
+			switch {
+			case want == "" && fw != nil:
+				t.Errorf("window opened on unchanged %s", name)
+			case want == "":
+			case fw == nil:
+				t.Errorf("no window opened on %s", name)
+			case fw.body.file.String() != want || !fw.body.file.Dirty():
+				t.Errorf("window on %s got %q, dirty %v; want %q, dirty", name, fw.body.file.String(), fw.body.file.Dirty(), want)
+			}
+			if b, _ := os.ReadFile(name); string(b) != files[strings.TrimPrefix(filepath.ToSlash(name), filepath.ToSlash(dir)+"/")] {
+				t.Errorf("%s changed on disk to %q", name, b)
+			}
+		}
+	})
+
+	t.Run("Print", func(t *testing.T) {
+		dir := t.TempDir()
+		run(t, dir, "F/*.go/")
+		warned(t, " +. "+filepath.Join(dir, "x.go")+"\n")
+		warned(t, " +  "+filepath.Join(dir, "three.go")+"\n")
+	})
+
+	t.Run("Preview", func(t *testing.T) {
+		dir := t.TempDir()
+		run(t, dir, "-n F/**/*.go/ ,s/package/pkg/")
+		if fw := lookfile(filepath.Join(dir, "one.go")); fw != nil {
+			t.Errorf("preview opened a window on one.go")
+		}
+		pw := lookfile(filepath.Join(dir, "+Preview"))
+		if pw == nil {
+			t.Fatalf("no +Preview window")
+		}
+		d := pw.body.file.String()
+		for _, want := range []string{"-package x\n+pkg x\n", "-package one\n+pkg one\n", "-package two\n+pkg two\n"} {
+			if !strings.Contains(d, want) {
+				t.Errorf("preview %q lacks %q", d, want)
+			}
+		}
+	})
+
+	t.Run("IDsAndJournals", func(t *testing.T) {
+		defer func(dir string) { *journaldir = dir }(*journaldir)
+		*journaldir = t.TempDir()
+		dir := t.TempDir()
+		run(t, dir, "F/*.go/ 5d")
+		warned(t, "Edit: address out of range\n")
+		for oeb := range journals {
+			found := false
+			global.row.AllWindows(func(w *Window) {
+				found = found || w.body.file == oeb
+			})
+			if !found {
+				t.Errorf("journal left open for %s", oeb.Name())
+			}
+		}
+
+		id := global.WinID
+		run(t, dir, "F/**/*.go/ ,s/package/pkg/")
+		// The scaffold makes one window; F opens one.go and sub/two.go.
+		if got, want := global.WinID, id+3; got != want {
+			t.Errorf("got window id %d; want %d", got, want)
+		}
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		dir := t.TempDir()
+		run(t, dir, "F/one.go/ w")
+		warned(t, "Edit: can't use w in F command\n")
+	})
+
+	t.Run("NUL", func(t *testing.T) {
+		dir := t.TempDir()
+		makefiles(t, dir, map[string]string{"nul.go": "package\x00nul\n"})
+		run(t, dir, "F/nul.go/ ,s/package/pkg/")
+		name := filepath.Join(dir, "nul.go")
+		warned(t, name+": has NUL bytes; skipped\n")
+		if lookfile(name) != nil {
+			t.Errorf("window opened on %s", name)
+		}
+	})
+
+	t.Run("ErrorUnlocks", func(t *testing.T) {
+		dir := t.TempDir()
+		FlexiblyMakeWindowScaffold(
+			t,
+			ScWin("x.go"),
+			ScBody("x.go", "package x\n"),
+			ScDir(dir, "x.go"),
+			ScWin("y.go"),
+			ScBody("y.go", "package y\n"),
+			ScDir(dir, "y.go"),
+		)
+		w, yw := global.row.col[0].w[0], global.row.col[0].w[1]
+		global.row.lk.Lock()
+		w.Lock('M')
+		edit(&w.body, nil, nil, false, false, "F/y.go/ 5d")
+		w.Unlock()
+		global.row.lk.Unlock()
+		warned(t, "Edit: address out of range\n")
+
+		done := make(chan struct{})
+		go func() {
+			yw.Lock('M')
+			yw.Unlock()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("error in F left %s locked", yw.body.file.Name())
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/rjkroege/edwood/diff"
+	"github.com/rjkroege/edwood/file"
+	"github.com/rjkroege/edwood/sam"
+)
+
+// Edit -n cmd previews the changes that cmd would make. It runs cmd
+// without changing any window and shows, in the window named +Preview
+// of the directory of the window it was run from, a unified diff of the
+// windows it would change. Apply in the tag of +Preview makes the
+// changes shown, without running cmd again, and Discard deletes the
+// window. If the windows or files to change were changed since the
+// preview, Apply shows the changes cmd would now make instead.
+//
+// The commands that change more than the text of windows, e, f, k, u,
+// w, B, D, ! and >, and those that run external commands, < and |, fail
+// in a preview.
+
+// previewrefused are the Edit commands that fail in a preview.
+const previewrefused = "efkuwBD!<>|"
+
+// previewfiles are the windows without a display of the files that F
+// would change in a preview.
+var previewfiles []*Window
+
+// An editpreview holds an Edit command shown in a +Preview window.
+type editpreview struct {
+	cmd   []rune // Without -n.
+	id    int    // Of the window it was run from, or 0.
+	dot   Range  // Of the body of that window.
+	diff  string // Shown in the +Preview window.
+	edits []previewedit
+}
+
+// A previewedit holds the changes to a file shown in a +Preview window.
+type previewedit struct {
+	id   int       // Of the window holding the file, or 0 if none did (see F).
+	name string    // Of the file.
+	hash file.Hash // Of the body of the window, or of the file on disk.
+	elog sam.Elog  // The changes.
+}
+
+// text returns the body of the window the command of p was run from,
+// or nil if it wasn't run from one. It returns false if the window has
+// since been deleted.
+func (p *editpreview) text() (*Text, bool) {
+	if p.id == 0 {
+		return nil, true
+	}
+	w := global.row.LookupWin(p.id)
+	if w == nil {
+		return nil, false
+	}
+	return &w.body, true
+}
+
+// setdot restores the selection of the window of ct, if it has one, to
+// that at the time of the preview.
+func (p *editpreview) setdot(ct *Text) {
+	if ct != nil && ct.w != nil {
+		ct.w.body.q0, ct.w.body.q1 = p.dot.q0, p.dot.q1
+	}
+}
+
+// run runs the command of p from ct, with the selection of its window
+// at the time of the preview, records the changes it would make and
+// returns their diff.
+func (p *editpreview) run(ct *Text) string {
+	p.setdot(ct)
+	p.edits = nil
+	previewing = true
+	ok := editrun(ct, p.cmd)
+	previewing = false
+	if !ok {
+		return ""
+	}
+	var sb strings.Builder
+	previewdiff := func(w *Window) {
+		f := w.body.file
+		if w.editpreview != nil {
+			// The preview doesn't show changes to itself.
+			f.Elog.Term()
+			return
+		}
+		if f.Elog.Empty() {
+			// Including the other windows of a file already seen.
+			return
+		}
+		old := f.String()
+		e := previewedit{name: f.Name(), elog: f.Elog.Copy()}
+		if w.display != nil {
+			e.id = w.id
+			e.hash = file.CalcHash([]byte(old))
+		} else {
+			e.hash = f.Hash()
+		}
+		p.edits = append(p.edits, e)
+		t := sam.NewTextBuffer(0, 0, []rune(old))
+		f.Elog.Apply(t)
+		r := make([]rune, t.Nc())
+		t.ReadB(0, r)
+		name := f.Name()
+		sb.WriteString(diff.Unified(name, name, diff.SplitLines(old), diff.SplitLines(string(r))))
+	}
+	global.row.AllWindows(previewdiff)
+	for _, w := range previewfiles {
+		previewdiff(w)
+		closeheadless(w)
+	}
+	previewfiles = nil
+	return sb.String()
+}
+
+// apply makes the changes recorded by the last run of p, from ct, if
+// the windows and files they change are as they were then. It returns
+// false, changing nothing, if they aren't.
+func (p *editpreview) apply(ct *Text) bool {
+	ws := make([]*Window, len(p.edits))
+	for i, e := range p.edits {
+		if e.id != 0 {
+			w := global.row.LookupWin(e.id)
+			if w == nil || !file.CalcHash([]byte(w.body.file.String())).Eq(e.hash) {
+				return false
+			}
+			ws[i] = w
+			continue
+		}
+		// Perhaps opened since.
+		if w := lookfile(e.name); w != nil {
+			if w.body.file.Dirty() || !w.body.file.Hash().Eq(e.hash) {
+				return false
+			}
+			ws[i] = w
+			continue
+		}
+		if h, err := file.HashFor(e.name); err != nil || !h.Eq(e.hash) {
+			return false
+		}
+	}
+	p.setdot(ct)
+	global.seq++
+	for i, e := range p.edits {
+		if w := ws[i]; w != nil {
+			w.body.file.EditClean = false
+			w.body.file.Elog = e.elog
+		} else {
+			openchanged(ct, e.name, e.hash, e.elog)
+		}
+	}
+	global.row.AllWindows(allupdate)
+	return true
+}
+
+// editpreviewcmd implements Edit -n: it shows the changes that the Edit
+// command r run from et would make in a +Preview window.
+func editpreviewcmd(et *Text, r []rune) {
+	p := &editpreview{cmd: r}
+	dir := global.wdir
+	if et.w != nil {
+		p.id = et.w.id
+		p.dot = Range{et.w.body.q0, et.w.body.q1}
+		dir = et.w.body.DirName("")
+	}
+	p.diff = p.run(et)
+	if p.diff == "" {
+		warning(nil, "Edit: no changes\n")
+		return
+	}
+
+	name := filepath.Join(dir, "+Preview")
+	pw := lookfile(name)
+	if pw == nil {
+		pw = makenewwindow(et)
+		pw.SetName(name)
+		pw.tag.Insert(pw.tag.Nc(), []rune("Apply Discard "), true)
+		pw.tag.file.Clean()
+		xfidlog(pw, "new")
+	}
+	if pw != et.w {
+		// The window of et is already locked.
+		pw.Lock('E')
+		defer pw.Unlock()
+	}
+	pw.editpreview = p
+	showpreview(pw)
+}
+
+// showpreview replaces the body of the +Preview window pw with the diff
+// of its preview. Must be called with the window lock held.
+func showpreview(pw *Window) {
+	t := &pw.body
+	t.Delete(0, t.Nc(), true)
+	t.Insert(0, []rune(pw.editpreview.diff), true)
+	t.file.Clean()
+	t.SetSelect(0, 0)
+	t.Show(0, 0, true)
+}
+
+// applyx implements the Apply command of a +Preview window: it makes
+// the changes of the previewed Edit command and deletes the window. In a
+// +Merge window, it applies the merge (see applymerge).
+func applyx(et *Text, _ *Text, _ *Text, _, _ bool, _ string) {
+	if et != nil && et.w != nil && et.w.mergeview != nil {
+		applymerge(et.w)
+		return
+	}
+	if et == nil || et.w == nil || et.w.editpreview == nil {
+		warning(nil, "Apply: not an Edit preview or a merge\n")
+		return
+	}
+	pw := et.w
+	p := pw.editpreview
+	ct, ok := p.text()
+	if !ok {
+		warning(nil, "Apply: window of the Edit command deleted\n")
+		return
+	}
+
+	if p.apply(ct) {
+		pw.col.Close(pw, true)
+		return
+	}
+
+	// Edit expects the window it runs from, and no other, to be locked.
+	var lw *Window
+	if ct != nil {
+		lw = ct.w
+	}
+	owner := pw.owner
+	if lw != pw {
+		pw.Unlock()
+		if lw != nil {
+			lw.Lock('E')
+		}
+	}
+	p.diff = p.run(ct)
+	if lw != pw {
+		if lw != nil {
+			lw.Unlock()
+		}
+		pw.Lock(owner)
+	}
+
+	if p.diff == "" {
+		warning(nil, "Apply: Edit command now makes no changes\n")
+		return
+	}
+	warning(nil, "Apply: changed since the preview; showing the changes now\n")
+	showpreview(pw)
+}
+
+// discardx implements the Discard command of a +Preview or +Merge
+// window: it deletes the window without making the changes.
+func discardx(et *Text, _ *Text, _ *Text, _, _ bool, _ string) {
+	if et == nil || et.w == nil || (et.w.editpreview == nil && et.w.mergeview == nil) {
+		warning(nil, "Discard: not an Edit preview or a merge\n")
+		return
+	}
+	et.w.col.Close(et.w, true)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditPreview(t *testing.T) {
+	dir := t.TempDir()
+	setup := func() (*Window, *Window) {
+		t.Helper()
+		warningsMu.Lock()
+		warnings = nil
+		warningsMu.Unlock()
+		FlexiblyMakeWindowScaffold(
+			t,
+			ScWin("a.txt"),
+			ScBody("a.txt", contents),
+			ScDir(dir, "a.txt"),
+			ScWin("alt_example_2"),
+			ScBody("alt_example_2", alt_contents),
+		)
+		w := global.row.col[0].w[0]
+		global.activecol = w.col
+		global.row.lk.Lock()
+		w.Lock('M')
+		edit(&w.body, nil, nil, false, false, "-n X/./ ,s/t/T/g")
+		w.Unlock()
+		global.row.lk.Unlock()
+		pw := lookfile(filepath.Join(dir, "+Preview"))
+		if pw == nil {
+			for _, wa := range warnings {
+				t.Log(wa.buf.String())
+			}
+			t.Fatalf("no +Preview window")
+		}
+		return w, pw
+	}
+	apply := func(pw *Window, cmd func(*Text, *Text, *Text, bool, bool, string)) {
+		global.row.lk.Lock()
+		pw.Lock('M')
+		cmd(&pw.tag, nil, nil, false, false, "")
+		if global.row.LookupWin(pw.id) != nil {
+			// A deleted window can't be unlocked without a display.
+			pw.Unlock()
+		}
+		global.row.lk.Unlock()
+	}
+
+	t.Run("Apply", func(t *testing.T) {
+		w, pw := setup()
+		alt := global.row.col[0].w[1]
+		if got := w.body.file.String(); got != contents {
+			t.Errorf("preview changed body to %q", got)
+		}
+		d := pw.body.file.String()
+		for _, want := range []string{
+			"@@ -1,3 +1,3 @@ " + filepath.Join(dir, "a.txt") + ":2\n This is a\n-short text\n",
+			"+shorT TexT\n",
+			"--- alt_example_2\n",
+			"+A differenT TexT\n",
+		} {
+			if !strings.Contains(d, want) {
+				t.Errorf("preview %q doesn't contain %q", d, want)
+			}
+		}
+		if tag := pw.tag.file.String(); !strings.HasSuffix(tag, "Apply Discard ") {
+			t.Errorf("preview tag %q lacks Apply and Discard", tag)
+		}
+
+		apply(pw, applyx)
+		if got, want := w.body.file.String(), "This is a\nshorT TexT\nTo Try addressing\n"; got != want {
+			t.Errorf("Apply got %q; want %q", got, want)
+		}
+		if got, want := alt.body.file.String(), "A differenT TexT\nWiTh oTher conTenTs\nSo There!\n"; got != want {
+			t.Errorf("Apply got %q; want %q", got, want)
+		}
+		if global.row.LookupWin(pw.id) != nil {
+			t.Errorf("Apply left the +Preview window")
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		w, pw := setup()
+		w.body.Insert(0, []rune("test\n"), true)
+		apply(pw, applyx)
+		if got, want := w.body.file.String(), "test\n"+contents; got != want {
+			t.Errorf("Apply after a change got %q; want %q", got, want)
+		}
+		if global.row.LookupWin(pw.id) == nil {
+			t.Fatalf("Apply after a change deleted the +Preview window")
+		}
+		if d := pw.body.file.String(); !strings.Contains(d, "-test\n+TesT\n") || !strings.Contains(d, "+shorT TexT\n") {
+			t.Errorf("Apply after a change shows %q", d)
+		}
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		w, pw := setup()
+		apply(pw, discardx)
+		if got := w.body.file.String(); got != contents {
+			t.Errorf("Discard changed body to %q", got)
+		}
+		if global.row.LookupWin(pw.id) != nil {
+			t.Errorf("Discard left the +Preview window")
+		}
+	})
+
+	for _, tc := range []struct{ cmd, c string }{
+		{"w", "w"},
+		{"| tr a b", "|"},
+		{"< echo x", "<"},
+	} {
+		t.Run("Refused"+tc.c, func(t *testing.T) {
+			_, pw := setup()
+			w := global.row.col[0].w[0]
+			pw.editpreview = nil
+			warningsMu.Lock()
+			warnings = nil
+			warningsMu.Unlock()
+			global.row.lk.Lock()
+			w.Lock('M')
+			edit(&w.body, nil, nil, false, false, "-n ,s/t/T/\n"+tc.cmd)
+			w.Unlock()
+			global.row.lk.Unlock()
+			if got := w.body.file.String(); got != contents {
+				t.Errorf("refused preview changed body to %q", got)
+			}
+			if pw.editpreview != nil {
+				t.Errorf("refused preview shown")
+			}
+			warningsMu.Lock()
+			defer warningsMu.Unlock()
+			var all string
+			for _, wa := range warnings {
+				all += wa.buf.String()
+			}
+			if want := "Edit: " + tc.c + " in a preview\n"; !strings.Contains(all, want) {
+				t.Errorf("warnings %q lack %q", all, want)
+			}
+		})
+	}
+}
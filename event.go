@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/util"
+)
+
+// An Event describes an action in a window. The classic event file of a
+// window reports it as one or more lines of text (see Window.Eventf).
+// The eventjson file of a window, and the global eventjson file for all
+// windows, report it as a JSON object on a line of its own.
+//
+// Like the event file, opening the eventjson file of a window intercepts
+// its look and execute actions: they are only reported. The global
+// eventjson file, like the log file, only reports: Edwood still performs
+// the actions, unless a client of the window intercepts them. Writing an
+// Event with a look or execute Type (and, to the global file, the ID of
+// a window) back makes Edwood perform it.
+type Event struct {
+	ID     int    // Of the window.
+	Origin string // Of the action: E (write to body or tag), F (other file), K (keyboard) or M (mouse).
+	Type   string // Of the action: D, I, L or X in the body and d, i, l or x in the tag.
+	Q0, Q1 int    // Address of the action.
+	Flag   int    // As in the event file.
+	Text   string `json:",omitempty"` // Omitted if longer than EVENTSIZE runes.
+
+	Expansion *EventExpansion `json:",omitempty"` // Of the address of a look or execute, if different.
+	Arg       *EventArg       `json:",omitempty"` // Chorded argument of an execute.
+}
+
+// An EventExpansion is the expanded address of a look or execute Event.
+type EventExpansion struct {
+	Q0, Q1 int
+	Text   string `json:",omitempty"` // A file name and address for a look at a file.
+}
+
+// An EventArg is the chorded argument of an execute Event.
+type EventArg struct {
+	Text string
+	Addr string `json:",omitempty"` // Of the argument, as file:#q0,#q1.
+}
+
+// eventtext returns the text of t in [q0, q1) if it is short enough to
+// report in an Event.
+func eventtext(t *Text, q0, q1 int) string {
+	if q1-q0 > EVENTSIZE {
+		return ""
+	}
+	r := make([]rune, q1-q0)
+	t.file.Read(q0, r)
+	return string(r)
+}
+
+// external returns true if a client reads the events of w from its
+// event or eventjson file and so handles them.
+func (w *Window) external() bool {
+	return w.nopen[QWevent]+w.nopen[QWeventjson] > 0
+}
+
+// intercepted returns true if the look and execute actions of w are
+// reported to a client instead of being performed.
+func (w *Window) intercepted() bool {
+	return w.external()
+}
+
+// reported returns true if the look and execute actions of w are
+// reported to a client, whether or not they are intercepted.
+func (w *Window) reported() bool {
+	return w.intercepted() || eventjsonlog.isopen()
+}
+
+// Event reports e to the clients reading the event files of w.
+func (w *Window) Event(e Event) {
+	if w.nopen[QWevent] > 0 {
+		// The classic format, one action per line.
+		w.Eventf("%c%d %d %d %d %s\n", e.Type[0], e.Q0, e.Q1, e.Flag, utf8.RuneCountInString(e.Text), e.Text)
+		if x := e.Expansion; x != nil {
+			f := 0
+			if e.Type == "l" || e.Type == "L" {
+				f = e.Flag &^ 2
+			}
+			w.Eventf("%c%d %d %d %d %s\n", e.Type[0], x.Q0, x.Q1, f, utf8.RuneCountInString(x.Text), x.Text)
+		}
+		if a := e.Arg; a != nil {
+			w.Eventf("%c0 0 0 %d %s\n", e.Type[0], utf8.RuneCountInString(a.Text), a.Text)
+			w.Eventf("%c0 0 0 %d %s\n", e.Type[0], utf8.RuneCountInString(a.Addr), a.Addr)
+		}
+	}
+	if w.nopen[QWeventjson] == 0 && !eventjsonlog.isopen() {
+		return
+	}
+	if w.owner == 0 {
+		util.AcmeError("no window owner", nil)
+	}
+	e.ID = w.id
+	e.Origin = string(rune(w.owner))
+	b, err := json.Marshal(e)
+	if err != nil {
+		// Not possible: Event has no types that can't be marshalled.
+		panic(err)
+	}
+	b = append(b, '\n')
+	if w.nopen[QWeventjson] > 0 {
+		w.jsonevents = append(w.jsonevents, b...)
+		if x := w.jsoneventx; x != nil {
+			w.jsoneventx = nil
+			x.c <- nil
+		}
+	}
+	eventjsonlog.add(b)
+}
+
+// eventjsonlog holds the events of all windows waiting to be read from the
+// global eventjson file.
+var eventjsonlog eventQueue
+
+type eventQueue struct {
+	lk     sync.Mutex
+	r      sync.Cond
+	nopen  int
+	events []byte
+	read   []*Xfid // Blocked reads.
+}
+
+func (q *eventQueue) isopen() bool {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.nopen > 0
+}
+
+func (q *eventQueue) open() {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	q.nopen++
+}
+
+func (q *eventQueue) close() {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.nopen--; q.nopen == 0 {
+		q.events = nil
+	}
+}
+
+func (q *eventQueue) add(b []byte) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.nopen == 0 {
+		return
+	}
+	q.events = append(q.events, b...)
+	if q.r.L == nil {
+		q.r.L = &q.lk
+	}
+	q.r.Broadcast()
+}
+
+// xfidread responds to x, a read of the global eventjson file, with the
+// waiting events, first waiting for one if there are none.
+func (q *eventQueue) xfidread(x *Xfid) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.r.L == nil {
+		q.r.L = &q.lk
+	}
+
+	q.read = append(q.read, x)
+	x.flushed = false
+	for len(q.events) == 0 && !x.flushed {
+		q.r.Wait()
+	}
+	for i, rx := range q.read {
+		if rx == x {
+			q.read = append(q.read[:i], q.read[i+1:]...)
+			break
+		}
+	}
+	if x.flushed {
+		return
+	}
+
+	n := util.Min(len(q.events), int(x.fcall.Count))
+	fc := plan9.Fcall{
+		Count: uint32(n),
+		Data:  append([]byte(nil), q.events[:n]...),
+	}
+	q.events = q.events[n:]
+	x.respond(&fc, nil)
+}
+
+func (q *eventQueue) xfidflush(x *Xfid) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	for _, rx := range q.read {
+		if rx.fs == x.fs && rx.fcall.Tag == x.fcall.Oldtag {
+			rx.flushed = true
+			q.r.Broadcast()
+		}
+	}
+}
+
+// readevents decodes the Events written to an eventjson file.
+func readevents(data []byte) ([]Event, error) {
+	var evs []Event
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			return evs, nil
+		} else if err != nil {
+			return nil, ErrBadEvent
+		}
+		if e.Type == "" {
+			return nil, ErrBadEvent
+		}
+		evs = append(evs, e)
+	}
+}
+
+// xfideventjsonwrite performs the Events written by x to the eventjson
+// file of w. Must be called with the window lock held.
+func xfideventjsonwrite(x *Xfid, w *Window) {
+	var fc plan9.Fcall
+	evs, err := readevents(x.fcall.Data)
+	for _, e := range evs {
+		if err = performevent(w, eventowner(e), e.Type[0], e.Q0, e.Q1); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		fc.Count = uint32(len(x.fcall.Data))
+	}
+	x.respond(&fc, err)
+}
+
+// xfidglobaleventjsonwrite performs the Events written by x to the
+// global eventjson file in the windows given by their IDs.
+func xfidglobaleventjsonwrite(x *Xfid) {
+	var fc plan9.Fcall
+	evs, err := readevents(x.fcall.Data)
+	for _, e := range evs {
+		global.row.lk.Lock()
+		w := global.row.LookupWin(e.ID)
+		global.row.lk.Unlock()
+		if w == nil {
+			err = fmt.Errorf("no window %d", e.ID)
+			break
+		}
+		w.Lock('F')
+		if w.col == nil {
+			err = ErrDeletedWin
+		} else {
+			err = performevent(w, eventowner(e), e.Type[0], e.Q0, e.Q1)
+		}
+		w.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		fc.Count = uint32(len(x.fcall.Data))
+	}
+	x.respond(&fc, err)
+}
+
+// eventowner returns the origin of e as a lock owner, F if unset.
+func eventowner(e Event) int {
+	if e.Origin == "" {
+		return 'F'
+	}
+	return int(e.Origin[0])
+}
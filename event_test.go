@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/edwoodtest"
+	"github.com/rjkroege/edwood/file"
+)
+
+func TestEventJSON(t *testing.T) {
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.configureGlobals(display)
+	w := NewWindow().initHeadless(nil)
+	w.id = 7
+	w.display = display
+	w.body = Text{
+		display: display,
+		fr:      &MockFrame{},
+		file:    file.MakeObservableEditableBuffer("hello_世界", []rune("Bye, さようなら")),
+		what:    Body,
+	}
+	w.tag = Text{
+		display: display,
+		fr:      &MockFrame{},
+		file:    file.MakeObservableEditableBuffer("", []rune("/Hello, 世界")),
+		what:    Tag,
+	}
+	w.tag.w = w
+	w.body.w = w
+	w.owner = 'M'
+	w.nopen[QWeventjson] = 1
+	w.tag.q0, w.tag.q1 = 8, 10
+
+	for _, tc := range []struct {
+		name     string
+		aq0, aq1 int
+		argt     *Text
+		want     Event
+	}{
+		{"Expanded", 0, 0, nil, Event{
+			ID: 7, Origin: "M", Type: "X", Q0: 0, Q1: 0, Flag: 2,
+			Expansion: &EventExpansion{Q0: 0, Q1: 3, Text: "Bye"},
+		}},
+		{"Selected", 0, 3, nil, Event{
+			ID: 7, Origin: "M", Type: "X", Q0: 0, Q1: 3, Text: "Bye",
+		}},
+		{"Arg", 1, 1, &w.tag, Event{
+			ID: 7, Origin: "M", Type: "X", Q0: 1, Q1: 1, Flag: 10,
+			Expansion: &EventExpansion{Q0: 0, Q1: 3, Text: "Bye"},
+			Arg:       &EventArg{Text: "世界"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w.jsonevents = nil
+			q0, q1 := expandRuneOffsetsToWord(&w.body, tc.aq0, tc.aq1)
+			delegateExecution(&w.body, nil, tc.aq0, tc.aq1, q0, q1, tc.argt)
+
+			var got Event
+			if err := json.Unmarshal(w.jsonevents, &got); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", w.jsonevents, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("event mismatch (-want +got):\n%s", diff)
+			}
+			if n := len(w.jsonevents); w.jsonevents[n-1] != '\n' {
+				t.Errorf("event %q doesn't end with a newline", w.jsonevents)
+			}
+			if len(w.events) != 0 {
+				t.Errorf("classic events %q written without a reader", w.events)
+			}
+		})
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want []Event
+		err  error
+	}{
+		{"Empty", "", nil, nil},
+		{"One", `{"Type":"X","Q0":1,"Q1":4}` + "\n", []Event{{Type: "X", Q0: 1, Q1: 4}}, nil},
+		{"Two", `{"ID":3,"Origin":"M","Type":"l","Q0":0,"Q1":2}{"Type":"L"}`,
+			[]Event{{ID: 3, Origin: "M", Type: "l", Q0: 0, Q1: 2}, {Type: "L"}}, nil},
+		{"NoType", `{"Q0":1,"Q1":4}`, nil, ErrBadEvent},
+		{"Garbage", `X1 4 0 0`, nil, ErrBadEvent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readevents([]byte(tc.data))
+			if err != tc.err {
+				t.Fatalf("got error %v; want %v", err, tc.err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestXfidreadQeventjson(t *testing.T) {
+	w := NewWindow().initHeadless(nil)
+	w.id = 3
+	w.body.file = file.MakeObservableEditableBuffer("", []rune("abc"))
+	w.owner = 'K'
+
+	// Events are only queued while the file is open.
+	w.Event(Event{Type: "I", Q0: 0, Q1: 1, Text: "a"})
+	eventjsonlog.open()
+	defer eventjsonlog.close()
+	if w.intercepted() {
+		t.Errorf("window is intercepted by the global eventjson file")
+	}
+	w.Event(Event{Type: "D", Q0: 1, Q1: 2})
+
+	mr := new(mockResponder)
+	xfidread(&Xfid{
+		f:     &Fid{qid: plan9.Qid{Path: Qeventjson}},
+		fcall: plan9.Fcall{Count: 1000},
+		fs:    mr,
+	})
+	if mr.err != nil {
+		t.Fatalf("got error %v; want nil", mr.err)
+	}
+	want := `{"ID":3,"Origin":"K","Type":"D","Q0":1,"Q1":2,"Flag":0}` + "\n"
+	if got := string(mr.fcall.Data); got != want {
+		t.Errorf("got data %q; want %q", got, want)
+	}
+}
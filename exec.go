@@ -13,7 +13,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"unicode/utf8"
 
 	"9fans.net/go/plan9"
 	"9fans.net/go/plan9/client"
@@ -55,12 +54,16 @@ type Exectab struct {
 
 var globalexectab = []Exectab{
 	//	{ "Abort",		doabort,	false,	true /*unused*/,		true /*unused*/,		},
+	{"Apply", applyx, false, true /*unused*/, true /*unused*/},
 	{"Cut", cut, true, true, true},
 	{"Del", del, false, false, true /*unused*/},
 	{"Delcol", delcol, false, true /*unused*/, true /*unused*/},
 	{"Delete", del, false, true, true /*unused*/},
+	{"Diff", diffx, false, true /*unused*/, true /*unused*/},
+	{"Discard", discardx, false, true /*unused*/, true /*unused*/},
 	{"Dump", dump, false, true, true /*unused*/},
 	{"Edit", edit, false, true /*unused*/, true /*unused*/},
+	{"Encoding", encodingx, false, true /*unused*/, true /*unused*/},
 	{"Exit", xexit, false, true /*unused*/, true /*unused*/},
 	{"Font", fontx, false, true /*unused*/, true /*unused*/},
 	{"Get", get, false, true, true /*unused*/},
@@ -71,11 +74,13 @@ var globalexectab = []Exectab{
 	{"Load", dump, false, false, true /*unused*/},
 	{"Local", local, false, true /*unused*/, true /*unused*/},
 	{"Look", look, false, true /*unused*/, true /*unused*/},
+	{"Merge", merge, false, true /*unused*/, true /*unused*/},
 	{"New", newx, false, true /*unused*/, true /*unused*/},
 	{"Newcol", newcol, false, true /*unused*/, true /*unused*/},
 	{"Paste", paste, true, true, true /*unused*/},
 	{"Put", put, false, true /*unused*/, true /*unused*/},
 	{"Putall", putall, false, true /*unused*/, true /*unused*/},
+	{"Recover", recoverx, false, true /*unused*/, true /*unused*/},
 	{"Redo", undo, false, false, true /*unused*/},
 	{"Send", sendx, true, true /*unused*/, true /*unused*/},
 	{"Snarf", cut, false, true, false},
@@ -187,18 +192,18 @@ func expandRuneOffsetsToWord(t *Text, q0 int, q1 int) (int, int) {
 // using the event file to control the operation of Edwood via the
 // filesystem.
 func delegateExecution(t *Text, e *Exectab, aq0, aq1, q0, q1 int, argt *Text) {
-	var r []rune
-
 	f := 0
 	if e != nil {
 		f |= 1
 	}
 	if q0 != aq0 || q1 != aq1 {
-		r = make([]rune, aq1-aq0)
-		t.file.Read(aq0, r)
 		f |= 2
 	}
 	a, aa := getarg(argt, true, true)
+	if len(a) > EVENTSIZE && !t.w.intercepted() {
+		// Only reported: leave it out.
+		a = ""
+	}
 	if a != "" {
 		if len(a) > EVENTSIZE { // too big; too bad
 			warning(nil, "argument string too long\n")
@@ -206,34 +211,18 @@ func delegateExecution(t *Text, e *Exectab, aq0, aq1, q0, q1 int, argt *Text) {
 		}
 		f |= 8
 	}
-	c := 'x'
+	c := "x"
 	if t.what == Body {
-		c = 'X'
-	}
-	n := aq1 - aq0
-	if n <= EVENTSIZE {
-		t.w.Eventf("%c%d %d %d %d %v\n", c, aq0, aq1, f, n, string(r))
-	} else {
-		t.w.Eventf("%c%d %d %d 0 \n", c, aq0, aq1, f)
+		c = "X"
 	}
+	ev := Event{Type: c, Q0: aq0, Q1: aq1, Flag: f, Text: eventtext(t, aq0, aq1)}
 	if q0 != aq0 || q1 != aq1 {
-		n = q1 - q0
-		r = make([]rune, n)
-		t.file.Read(q0, r)
-		if n <= EVENTSIZE {
-			t.w.Eventf("%c%d %d 0 %d %v\n", c, q0, q1, n, string(r))
-		} else {
-			t.w.Eventf("%c%d %d 0 0 \n", c, q0, q1)
-		}
+		ev.Expansion = &EventExpansion{Q0: q0, Q1: q1, Text: eventtext(t, q0, q1)}
 	}
 	if a != "" {
-		t.w.Eventf("%c0 0 0 %d %v\n", c, utf8.RuneCountInString(a), a)
-		if aa != "" {
-			t.w.Eventf("%c0 0 0 %d %v\n", c, utf8.RuneCountInString(aa), aa)
-		} else {
-			t.w.Eventf("%c0 0 0 0 \n", c)
-		}
+		ev.Arg = &EventArg{Text: a, Addr: aa}
 	}
+	t.w.Event(ev)
 }
 
 // execute must run with an existing lock on t's Window
@@ -246,9 +235,15 @@ func execute(t *Text, aq0 int, aq1 int, external bool, argt *Text) {
 
 	// Send commands to external client if the target window's event file is
 	// in use.
-	if !external && t.w != nil && t.w.nopen[QWevent] > 0 {
+	if !external && t.w != nil && t.w.reported() {
 		delegateExecution(t, e, aq0, aq1, q0, q1, argt)
-		return
+		if t.w.intercepted() {
+			return
+		}
+	}
+
+	if t.w != nil {
+		xfidlogf(t.w, "exec", "%s", strings.ReplaceAll(strings.TrimSpace(string(r)), "\n", " "))
 	}
 
 	// Invoke an internal command if it exists.
@@ -284,17 +279,22 @@ func edit(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
 		return
 	}
 	r, _ := getarg(argt, false, true)
+	arg, preview := cutoption(arg, "-n")
+	if r == "" {
+		r = arg
+	}
 
 	global.seq++
-	if r != "" {
-		editcmd(et, []rune(r))
+	if preview {
+		editpreviewcmd(et, []rune(r))
 	} else {
-		editcmd(et, []rune(arg))
+		editcmd(et, []rune(r))
 	}
 }
 
 func xexit(*Text, *Text, *Text, bool, bool, string) {
 	if global.row.Clean() {
+		removejournals()
 		close(global.cexit)
 		//	threadexits(nil);
 	}
@@ -384,7 +384,7 @@ func delcol(et *Text, _ *Text, _ *Text, _, _ bool, _ string) {
 	}
 	for i := 0; i < len(c.w); i++ {
 		w := c.w[i]
-		if w.nopen[QWevent]+w.nopen[QWaddr]+w.nopen[QWdata]+w.nopen[QWxdata] > 0 {
+		if w.external() || w.nopen[QWaddr]+w.nopen[QWdata]+w.nopen[QWxdata] > 0 {
 			warning(nil, "can't delete column; %s is running an external command\n", w.body.file.Name())
 			return
 		}
@@ -469,6 +469,10 @@ func get(et *Text, _ *Text, argt *Text, flag1 bool, _ bool, arg string) {
 		}
 	}
 
+	if et.w.body.file.ReadOnly() {
+		warning(nil, "%s is read-only\n", et.w.body.file.Name())
+		return
+	}
 	isclean := et.w.Clean(true)
 	if et.w.body.file.Nr() > 0 && !isclean {
 		return
@@ -496,6 +500,7 @@ func get(et *Text, _ *Text, argt *Text, flag1 bool, _ bool, arg string) {
 	// as it is on disk. So indicate this with file.Clean().
 	if samename {
 		t.file.Clean()
+		loadundohistory(t)
 	}
 	xfidlog(w, "get")
 }
@@ -548,21 +553,21 @@ func putfile(oeb *file.ObservableEditableBuffer, q0 int, q1 int, name string) er
 		}
 	}
 
-	fd, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
-	if err != nil {
-		return warnError(nil, "can't create file %s: %v", name, err)
+	if oeb.Encoding() != file.UTF8 {
+		// Check before writing name that every rune can be written.
+		if _, err := io.Copy(io.Discard, oeb.EncodedReader(q0, q1)); err != nil {
+			return warnError(nil, "%s not written; can't encode in %s: %v", name, oeb.Encoding(), err)
+		}
 	}
-	defer fd.Close()
-
-	h := sha1.New()
 
-	d, err = fd.Stat()
-	isapp := err == nil && d.Size() > 0 && (d.Mode()&os.ModeAppend) != 0
-	if isapp {
+	if err == nil && d.Size() > 0 && (d.Mode()&os.ModeAppend) != 0 {
 		return warnError(nil, "%s not written; file is append only", name)
 	}
 
-	_, err = io.Copy(io.MultiWriter(h, fd), oeb.Reader(q0, q1))
+	// oeb's contents may be memory-mapped from name: it must not be
+	// rewritten in place.
+	h := sha1.New()
+	d, err = writefile(name, io.TeeReader(oeb.EncodedReader(q0, q1), h), !oeb.Mapped())
 	if err != nil {
 		return warnError(nil, "can't write file %s: %v", name, err)
 	}
@@ -577,12 +582,10 @@ func putfile(oeb *file.ObservableEditableBuffer, q0 int, q1 int, name string) er
 		} else {
 			// A normal put operation of a file modified in Edwood but not
 			// modified on disk.
-			if d1, err := fd.Stat(); err == nil {
-				d = d1
-			}
 			oeb.SetInfo(d)
 			oeb.Set(h.Sum(nil))
 			oeb.Clean()
+			saveundohistory(oeb)
 		}
 	}
 	return nil
@@ -596,6 +599,10 @@ func put(et *Text, _0 *Text, argt *Text, _1 bool, _2 bool, arg string) {
 	}
 	w := et.w
 	f := w.body.file
+	if f.ReadOnly() {
+		warning(nil, "%s is read-only\n", f.Name())
+		return
+	}
 	name := getname(&w.body, argt, arg, true)
 	if name == "" {
 		warning(nil, "no file name\n")
@@ -609,7 +616,7 @@ func put(et *Text, _0 *Text, argt *Text, _1 bool, _2 bool, arg string) {
 func putall(et, _, _ *Text, _, _ bool, arg string) {
 	for _, col := range global.row.col {
 		for _, w := range col.w {
-			if w.nopen[QWevent] > 0 {
+			if w.external() {
 				continue
 			}
 			a := w.body.file.Name()
@@ -640,30 +647,126 @@ func seqof(w *Window, isundo bool) int {
 	return w.body.file.RedoSeq()
 }
 
-// TODO(rjk): Test the logic of Undo across multiple buffers very carefully: #383
-func undo(et *Text, _ *Text, _ *Text, flag1, _ bool, _ string) {
+// undo implements the Undo and Redo commands. With a -branch argument,
+// Undo reverts changes until reaching a point in the undo tree where
+// there are alternative branches and Redo switches to the next
+// alternative branch before redoing. Undo with a time argument (see
+// parseundotime) restores the body to its state at that time.
+func undo(et *Text, _ *Text, _ *Text, flag1, _ bool, arg string) {
 	if et == nil || et.w == nil {
 		return
 	}
-	seq := seqof(et.w, flag1)
+	branch := false
+	for _, a := range strings.Fields(arg) {
+		switch a {
+		case "-branch":
+			branch = true
+		default:
+			when, err := parseundotime(a, time.Now())
+			if !flag1 || err != nil {
+				warning(nil, "%s: unknown argument %q\n", undoname(flag1), a)
+				return
+			}
+			undotime(et.w, when)
+			return
+		}
+	}
+
+	switch {
+	case !branch:
+		undostep(et.w, flag1)
+	case flag1:
+		for undostep(et.w, true) && !et.w.body.file.AtBranch() {
+		}
+	default:
+		oeb := et.w.body.file
+		if !oeb.NextRedoBranch() {
+			warning(nil, "Redo: no alternative branch\n")
+			return
+		}
+		// Other files changed by the same multi-file Edit follow.
+		seq := oeb.RedoSeq()
+		for _, c := range global.row.col {
+			for _, w := range c.w {
+				if w.body.file != oeb {
+					w.body.file.SelectRedoBranch(seq)
+				}
+			}
+		}
+		undostep(et.w, false)
+	}
+}
+
+// parseundotime parses s as a point in time relative to now. s is
+// either a duration (e.g. 10m or 1h30m) before now or a wall-clock time
+// (e.g. 12:30 or 12:30:15) within the last day.
+func parseundotime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("negative duration %q", s)
+		}
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		c, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+		y, m, d := now.Date()
+		when := time.Date(y, m, d, c.Hour(), c.Minute(), c.Second(), 0, now.Location())
+		if when.After(now) {
+			when = when.AddDate(0, 0, -1)
+		}
+		return when, nil
+	}
+	return time.Time{}, fmt.Errorf("bad time %q", s)
+}
+
+// undotime undoes or redoes changes in w along the current branch of the
+// undo tree until w's body is in its state at time when. As with a
+// single Undo, changes made in other windows by the same command are also
+// undone.
+func undotime(w *Window, when time.Time) {
+	w.body.file.UndoTo(when, func(isundo bool) bool {
+		return undostep(w, isundo)
+	})
+}
+
+func undoname(isundo bool) string {
+	if isundo {
+		return "Undo"
+	}
+	return "Redo"
+}
+
+// undostep undoes (or redoes) the most recent change in w and the
+// changes in every other window that have the same sequence number. It
+// returns false if there was nothing to undo or w's body is read-only.
+//
+// TODO(rjk): Test the logic of Undo across multiple buffers very carefully: #383
+func undostep(et *Window, isundo bool) bool {
+	seq := seqof(et, isundo)
 	if seq == 0 {
 		// nothing to undo
-		return
+		return false
 	}
 	// Undo the executing window first. Its display will update. other windows
 	// in the same file will not call show() and jump to a different location in the file.
 	// Simultaneous changes to other files will be chaotic, however.
-	et.w.Undo(flag1)
+	if !et.Undo(isundo) {
+		return false
+	}
 	for _, c := range global.row.col {
 		for _, w := range c.w {
-			if w == et.w {
+			if w == et {
 				continue
 			}
-			if seqof(w, flag1) == seq {
-				w.Undo(flag1)
+			if seqof(w, isundo) == seq {
+				w.Undo(isundo)
 			}
 		}
 	}
+	return true
 }
 
 func run(win *Window, s string, rdir string, newns bool, argaddr string, xarg string, iseditcmd bool) {
@@ -705,11 +808,14 @@ func sendx(et, _, _ *Text, _, _ bool, _ string) {
 	t.Show(t.q1, t.q1, true)
 }
 
+// look implements the Look command: it searches the body for the
+// argument or the selection. With -i, letters match regardless of case.
 func look(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
 	if et != nil && et.w != nil {
 		t := &et.w.body
+		arg, fold := cutoption(arg, "-i")
 		if len(arg) > 0 {
-			search(t, []rune(arg))
+			searchcase(t, []rune(arg), fold)
 			return
 		}
 		r, _ := getarg(argt, false, false)
@@ -719,8 +825,18 @@ func look(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
 			t.file.Read(t.q0, rb[:n])
 			r = string(rb) // TODO(flux) Too many gross []rune-string conversions in here
 		}
-		search(t, []rune(r))
+		searchcase(t, []rune(r), fold)
+	}
+}
+
+// cutoption returns s without the option opt, such as -i, at its start
+// and true, or s and false if it doesn't start with opt.
+func cutoption(s, opt string) (string, bool) {
+	a, ok := strings.CutPrefix(s, opt)
+	if !ok || (a != "" && a[0] != ' ' && a[0] != '\t' && a[0] != '\n') {
+		return s, false
 	}
+	return strings.TrimLeft(a, " \t\n"), true
 }
 
 func tab(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
@@ -757,6 +873,49 @@ func tab(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
 	}
 }
 
+// encodingx implements the Encoding command: it sets the encoding of
+// the disk file of the window to the argument or, without one, reports
+// it.
+func encodingx(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
+	if et == nil || et.w == nil {
+		return
+	}
+	w := et.w
+	r, _ := getarg(argt, false, true)
+	if r == "" {
+		r = strings.TrimSpace(arg)
+	}
+	if r == "" {
+		warning(nil, "%s: Encoding %s\n", w.body.file.Name(), w.body.file.Encoding())
+		return
+	}
+	if err := setencoding(w, r); err != nil {
+		warning(nil, "Encoding: %v\n", err)
+	}
+}
+
+// setencoding sets the encoding used to write the body of w to its
+// disk file and to read it on the next Get.
+func setencoding(w *Window, name string) error {
+	var err error
+	setdiskformat(w, func(f *file.ObservableEditableBuffer) {
+		err = f.SetEncoding(name)
+	})
+	return err
+}
+
+// setdiskformat changes how the body of w is written to its disk file
+// with set. If this changes what Put would write, the body becomes
+// dirty.
+func setdiskformat(w *Window, set func(f *file.ObservableEditableBuffer)) {
+	f := w.body.file
+	enc, crlf, bom := f.Encoding(), f.CRLF(), f.BOM()
+	set(f)
+	if (f.Encoding() != enc || f.CRLF() != crlf || f.BOM() != bom) && !f.IsDirOrScratch() {
+		f.Modded()
+	}
+}
+
 func expandtab(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
 	if et == nil || et.w == nil {
 		return
@@ -817,7 +976,10 @@ func fontx(et *Text, _ *Text, argt *Text, _, _ bool, arg string) {
 	}
 }
 
-func zeroxx(et *Text, t *Text, _ *Text, _, _ bool, _4 string) {
+// zeroxx implements the Zerox command. With a time argument (see
+// parseundotime), it makes a read-only window showing the body as it was
+// at that time instead of a copy of the window.
+func zeroxx(et *Text, t *Text, _ *Text, _, _ bool, arg string) {
 	if t != nil && t.w != nil && t.w != et.w {
 		c := int('M')
 		if et.w != nil {
@@ -836,6 +998,15 @@ func zeroxx(et *Text, t *Text, _ *Text, _, _ bool, _4 string) {
 	if t.w.body.file.IsDir() {
 		// TODO(rjk): Why?
 		warning(nil, "%s is a directory; Zerox illegal\n", t.file.Name())
+	} else if arg = strings.TrimSpace(arg); arg != "" {
+		when, err := parseundotime(arg, time.Now())
+		if err != nil {
+			warning(nil, "Zerox: %v\n", err)
+			return
+		}
+		if err := timeview(t.w, when); err != nil {
+			warning(nil, "Zerox: %v\n", err)
+		}
 	} else {
 		nw := t.w.col.Add(nil, t.w, -1)
 		// ugly: fix locks so w.unlock works
@@ -845,6 +1016,24 @@ func zeroxx(et *Text, t *Text, _ *Text, _, _ bool, _4 string) {
 	}
 }
 
+// timeview makes a new read-only window showing the body of w as it was
+// at time when.
+func timeview(w *Window, when time.Time) error {
+	b, err := w.body.file.BytesAt(when)
+	if err != nil {
+		return err
+	}
+	name := w.body.file.Name() + "@" + when.Format("2006-01-02T15:04:05")
+	nw := w.col.Add(nil, nil, -1)
+	nw.SetName(name)
+	nw.body.LoadReader(0, name, bytes.NewReader(b), false)
+	nw.body.file.Clean()
+	nw.body.file.SetReadOnly(true)
+	nw.body.Show(0, 0, true)
+	xfidlog(nw, "new")
+	return nil
+}
+
 func runwaittask(c *Command, cpid chan *os.Process) {
 	c.proc = <-cpid
 
@@ -171,6 +171,55 @@ func TestPutfile(t *testing.T) {
 
 // TODO(rjk): Add A case here for partial writes.
 
+func TestPutfileEncoding(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "latin1.txt")
+	if err := os.WriteFile(filename, []byte("caf\xE9\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	f := file.MakeObservableEditableBuffer(filename, nil)
+	if _, _, err := f.Load(0, fd, true); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	fd.Close()
+	d, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	f.SetInfo(d)
+	if got, want := f.Encoding(), "latin-1"; got != want {
+		t.Fatalf("got encoding %q, want %q", got, want)
+	}
+
+	checkFile := func(want string) {
+		t.Helper()
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("file content is %q; expected %q", b, want)
+		}
+	}
+
+	f.InsertAt(0, []rune("¡"))
+	if err := putfile(f, 0, f.Nr(), filename); err != nil {
+		t.Fatalf("putfile failed: %v", err)
+	}
+	checkFile("\xA1caf\xE9\n")
+
+	// A rune that can't be encoded leaves the file untouched.
+	f.InsertAt(0, []rune("世界"))
+	err = putfile(f, 0, f.Nr(), filename)
+	if err == nil || !strings.Contains(err.Error(), "can't encode") {
+		t.Fatalf("putfile returned error %v; expected 'can't encode'", err)
+	}
+	checkFile("\xA1caf\xE9\n")
+}
+
 func TestExpandtabToggle(t *testing.T) {
 	want := true
 	w := &Window{
@@ -373,6 +422,33 @@ func mutateBranchedAndRejoined(t *testing.T, g *globals) {
 	undo(&secondwin.tag, nil, nil, false /* this is not undo */, false /* ignored */, "")
 }
 
+func mutateUndoBranches(t *testing.T, g *globals) {
+	t.Helper()
+
+	// Mutate firstwin, secondwin simultaneously and then undo it.
+	mutateWithEdit(t, g)
+
+	firstwin := g.row.col[0].w[0]
+
+	undo(&firstwin.tag, nil, nil, true /* this is an undo */, false /* ignored */, "")
+
+	// Start a second branch in firstwin with a cut.
+	firstwin.body.q0 = 3
+	firstwin.body.q1 = 10
+	global.seq++
+	firstwin.body.file.Mark(global.seq)
+	cut(&firstwin.tag, &firstwin.body, nil, false, true, "")
+
+	// Back to the branch point.
+	undo(&firstwin.tag, nil, nil, true /* this is an undo */, false /* ignored */, "-branch")
+	if got, want := firstwin.body.file.String(), contents; got != want {
+		t.Errorf("Undo -branch: got %q, want %q", got, want)
+	}
+
+	// Switch to the Edit branch: redoes it in both windows.
+	undo(&firstwin.tag, nil, nil, false /* this is a redo */, false /* ignored */, "-branch")
+}
+
 func mutatePut(t *testing.T, g *globals) {
 	t.Helper()
 
@@ -504,6 +580,46 @@ func TestUndoRedo(t *testing.T) {
 				},
 			},
 		},
+		{
+			// Undo a multi-file Edit, branch in one of the files and switch
+			// back to the branch with the Edit.
+			name: "mutateUndoBranches",
+			fn:   mutateUndoBranches,
+			want: &dumpfile.Content{
+				CurrentDir: cwd,
+				VarFont:    defaultVarFont,
+				FixedFont:  defaultFixedFont,
+				Columns: []dumpfile.Column{
+					{},
+				},
+				Windows: []*dumpfile.Window{
+					{
+						Type:   dumpfile.Unsaved,
+						Column: 0,
+						Tag: dumpfile.Text{
+							Buffer: firstfilename + " Del Snarf Undo Put | Look Edit ",
+						},
+						Body: dumpfile.Text{
+							Buffer: "This is a\nshort TEXT\nto try addressing\n",
+							Q0:     16,
+							Q1:     20,
+						},
+					},
+					{
+						Type:   dumpfile.Unsaved,
+						Column: 0,
+						Tag: dumpfile.Text{
+							Buffer: secondfilename + " Del Snarf Undo Put | Look Edit ",
+						},
+						Body: dumpfile.Text{
+							Buffer: "A different TEXT\nWith other contents\nSo there!\n",
+							Q0:     12,
+							Q1:     16,
+						},
+					},
+				},
+			},
+		},
 		{
 			// Having mutated both buffers, Undo one and Redo the other to get back
 			// to the initial mutated state.
@@ -772,7 +888,7 @@ func TestUndoRedo(t *testing.T) {
 
 			t.Log(*varfontflag, defaultVarFont)
 
-			got, err := global.row.dump()
+			got, err := global.row.dumpstate(false)
 			if err != nil {
 				t.Fatalf("dump failed: %v", err)
 			}
@@ -849,3 +965,29 @@ func TestDelegateExecution(t *testing.T) {
 		w.events = w.events[0:0]
 	}
 }
+
+func TestParseundotime(t *testing.T) {
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		s    string
+		want time.Time
+		err  bool
+	}{
+		{"10m", now.Add(-10 * time.Minute), false},
+		{"1h30m", now.Add(-90 * time.Minute), false},
+		{"11:45", time.Date(2021, 6, 15, 11, 45, 0, 0, time.Local), false},
+		{"11:45:30", time.Date(2021, 6, 15, 11, 45, 30, 0, time.Local), false},
+		{"13:00", time.Date(2021, 6, 14, 13, 0, 0, 0, time.Local), false},
+		{"-10m", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	} {
+		got, err := parseundotime(tc.s, now)
+		if (err != nil) != tc.err {
+			t.Errorf("parseundotime(%q): got error %v, want error %v", tc.s, err, tc.err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("parseundotime(%q): got %v, want %v", tc.s, got, tc.want)
+		}
+	}
+}
@@ -91,6 +91,7 @@ import (
 	"errors"
 	"io"
 	"log"
+	"time"
 	"unicode/utf8"
 
 	"github.com/rjkroege/edwood/sam"
@@ -105,6 +106,11 @@ const expensiveCheckedExecution = false
 
 var ErrWrongOffset = errors.New("offset is greater than buffer size")
 
+// maxPieceSize is the largest piece made from content loaded into a
+// Buffer. Finding an offset inside a piece requires scanning it so
+// large content is split into several pieces.
+const maxPieceSize = 64 * 1024
+
 // A Buffer is a structure capable of two operations: inserting or deleting.
 // All operations could be ultimately undone or redone.
 type Buffer struct {
@@ -112,40 +118,70 @@ type Buffer struct {
 	begin, end  *piece // sentinel nodes which always exists but don't hold any data
 	cachedPiece *piece // most recently modified piece
 
-	actions       []*action // stack holding all actions performed to the file
+	root          *action   // root of the undo tree: the state before any action
+	actions       []*action // path through the undo tree followed by Undo and Redo
 	head          int       // index for the next action to add
+	actionsCnt    int       // number of actions allocated
 	currentAction *action   // action for the current change group
 	savedAction   *action
 
 	oeb *ObservableEditableBuffer
 
+	mappings []*mapping // memory-mapped files referenced by pieces
+
 	viewed *piece      // piece most recently accessed by ReadRuneAt
 	vws    OffsetTuple // OffsetTuple for start of viewed
+	lines  lineCache   // piece most recently found by LineToRune or RuneToLine
 	vwl    OffsetTuple // Last determined OffsetTuple
 	pend   OffsetTuple // Cached end of the buffer.
 }
 
 // NewBuffer initializes a new buffer with the given content as a starting point.
 // To start with an empty buffer pass nil as a content.
-// TODO(rjk): Should we chunk very large content arrays?
 func NewBuffer(content []byte, nr int) *Buffer {
 	// give the actions stack some default capacity
-	t := &Buffer{actions: make([]*action, 0, 100)}
+	t := &Buffer{actions: make([]*action, 0, 100), root: &action{}}
 
 	t.begin = t.newEmptyPiece()
 	t.end = t.newPiece(nil, t.begin, nil, 0)
 	t.begin.next = t.end
 
-	if content != nil {
-		p := t.newPiece(content, t.begin, t.end, nr)
-		t.begin.next = p
+	prev := t.begin
+	chunks(content, func(c []byte, cnr int) {
+		p := t.newPiece(c, prev, t.end, cnr)
+		prev.next = p
 		t.end.prev = p
-		t.pend = Ot(len(content), nr)
-	}
+		prev = p
+	})
+	t.pend = Ot(len(content), nr)
 	return t
 }
 
+// chunks invokes f on successive pieces of data of at most maxPieceSize
+// bytes (unless a single rune is longer) that don't split runes. nr is
+// the number of runes in c.
+func chunks(data []byte, f func(c []byte, nr int)) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxPieceSize {
+			n = maxPieceSize
+			for n > 0 && !utf8.RuneStart(data[n]) {
+				n--
+			}
+			if n == 0 {
+				_, n = utf8.DecodeRune(data)
+			}
+		}
+		// Limit the capacity so that appending to the chunk can't
+		// overwrite its successor.
+		c := data[:n:n]
+		f(c, utf8.RuneCount(c))
+		data = data[n:]
+	}
+}
+
 func (b *Buffer) FlattenHistory() {
+	b.root = &action{}
 	b.actions = make([]*action, 0, 100)
 	b.head = 0
 	b.currentAction = nil
@@ -161,6 +197,7 @@ func (b *Buffer) Insert(start OffsetTuple, data []byte, nr, seq int) error {
 	if len(data) == 0 {
 		return nil
 	}
+	b.lines = lineCache{}
 
 	if expensiveCheckedExecution {
 		if c := utf8.RuneCount(data); c != nr {
@@ -217,12 +254,49 @@ func (b *Buffer) Insert(start OffsetTuple, data []byte, nr, seq int) error {
 	return nil
 }
 
+// insertPieces inserts the chain of pieces from first to last, holding
+// nb bytes and nr runes, at start as a single change. The pieces are
+// never modified in place so they can hold read-only data.
+func (b *Buffer) insertPieces(start OffsetTuple, first, last *piece, nb, nr, seq int) error {
+	b.lines = lineCache{}
+	b.validateInvariant()
+
+	p, offset, roffset := b.findPiece(start)
+	if p == nil {
+		b.validateInvariant()
+		return ErrWrongOffset
+	}
+	b.pend = b.pend.Add(nb, nr)
+
+	c := b.newChange(start.B, start.R, seq)
+	b.viewed = nil
+	if offset == p.len() {
+		first.prev, last.next = p, p.next
+		c.new = newSpan(first, last)
+		c.old = newSpan(nil, nil)
+	} else {
+		before := b.newPiece(p.data[:offset], p.prev, first, roffset)
+		after := b.newPiece(p.data[offset:], last, p.next, p.nr-roffset)
+		first.prev, last.next = before, after
+		c.new = newSpan(before, after)
+		c.old = newSpan(p, p)
+	}
+
+	// Make a new piece for the next insertion instead of appending to
+	// one of these.
+	b.cachedPiece = nil
+	swapSpans(c.old, c.new)
+	b.validateInvariant()
+	return nil
+}
+
 // Delete deletes the portion of the length at the given offset. An error is returned
 // if the portion isn't in the range of the buffer size. If the length exceeds the
 // size of the buffer, the portions from off to the end of the buffer will be
 // deleted.
 func (b *Buffer) Delete(startOff, endOff OffsetTuple, seq int) error {
 	b.validateInvariant()
+	b.lines = lineCache{}
 	off := startOff.B
 	length := endOff.B - startOff.B
 	rlength := endOff.R - startOff.R
@@ -299,6 +373,7 @@ func (b *Buffer) Delete(startOff, endOff OffsetTuple, seq int) error {
 		before.data = newBuf
 		before.prev, before.next = start.prev, after
 		before.nr = utf8.RuneCount(newBuf)
+		before.nl = countNewlines(newBuf)
 
 		newStart = before
 		if !midwayEnd {
@@ -322,12 +397,26 @@ func (b *Buffer) Delete(startOff, endOff OffsetTuple, seq int) error {
 	return nil
 }
 
-// newAction creates a new action and throws away all undone actions.
+// newAction creates a new action. Undone actions are not discarded:
+// they remain in the undo tree as an alternate branch.
 func (b *Buffer) newAction(seq int) *action {
 	a := &action{seq: seq}
+	b.pushAction(a)
+	return a
+}
+
+// pushAction adds a as a new child of the current action in the undo
+// tree and makes it the current action.
+func (b *Buffer) pushAction(a *action) {
+	b.actionsCnt++
+	a.id = b.actionsCnt
+	a.time = time.Now()
+	a.parent = b.currentNode()
+	a.parent.children = append(a.parent.children, a)
+	a.parent.redo = a
+
 	b.actions = append(b.actions[:b.head], a)
 	b.head++
-	return a
 }
 
 // newChange is associated with the current action or a newly allocated one if
@@ -363,6 +452,7 @@ func (b *Buffer) newPiece(data []byte, prev, next *piece, nr int) *piece {
 		next: next,
 		data: data,
 		nr:   nr,
+		nl:   countNewlines(data),
 	}
 }
 
@@ -419,16 +509,19 @@ func (b *Buffer) Undo(_ int) (int, int, bool, int) {
 	// log.Println("Undo start")
 	// defer log.Println("Undo end")
 	b.validateInvariant()
+	b.lines = lineCache{}
 	b.SetUndoPoint()
 	a := b.unshiftAction()
 	if a == nil {
 		return -1, 0, false, 0
 	}
 
-	// TODO(rjk): This is wrong if a filename change and edits are part of
-	// the same action?
 	if a.kind == sam.Filename {
-		return b.filenameChangeAction(a)
+		if len(a.changes) == 0 {
+			return b.filenameChangeAction(a)
+		}
+		// A rename merged with edits (see MergeSince).
+		b.oeb.setfilename(a.fname)
 	}
 
 	var roff, nr int
@@ -530,6 +623,7 @@ func (b *Buffer) Redo(_ int) (int, int, bool, int) {
 	//	log.Println("Redo start")
 	//	defer log.Println("Redo end")
 	b.validateInvariant()
+	b.lines = lineCache{}
 	b.SetUndoPoint()
 	a := b.shiftAction()
 	if a == nil {
@@ -537,7 +631,10 @@ func (b *Buffer) Redo(_ int) (int, int, bool, int) {
 	}
 
 	if a.kind == sam.Filename {
-		return b.filenameChangeAction(a)
+		if len(a.changes) == 0 {
+			return b.filenameChangeAction(a)
+		}
+		b.oeb.setfilename(a.fname)
 	}
 
 	var roff, nr int
@@ -654,13 +751,11 @@ func (b *Buffer) Nr() int {
 
 // UnsetName records a filename change at seq to fname.
 func (b *Buffer) UnsetName(fname string, seq int) {
-	a := &action{
+	b.pushAction(&action{
 		seq:   seq,
 		kind:  sam.Filename,
 		fname: fname,
-	}
-	b.actions = append(b.actions[:b.head], a)
-	b.head++
+	})
 
 	b.cachedPiece = nil
 	b.currentAction = nil
@@ -671,7 +766,7 @@ func (b *Buffer) UnsetName(fname string, seq int) {
 func (b *Buffer) validateInvariant() {
 	if expensiveCheckedExecution {
 		for p := b.begin; p != b.end; p = p.next {
-			if p.nr != utf8.RuneCount(p.data) {
+			if p.nr != utf8.RuneCount(p.data) || p.nl != countNewlines(p.data) {
 				log.Printf("invariant violated in piece %#v", *p)
 				panic("file.Buffer piece invariant violated")
 			}
@@ -680,12 +775,20 @@ func (b *Buffer) validateInvariant() {
 }
 
 // action is a list of changes which are used to undo/redo all modifications.
+// Actions form a tree: the children of an action are the alternative
+// actions that have been performed after it.
 type action struct {
 	changes []*change
 	seq     int
+	id      int       // unique within the Buffer, in order of creation
+	time    time.Time // when the action was created
 
 	kind  int
 	fname string
+
+	parent   *action
+	children []*action
+	redo     *action // child followed by Redo
 }
 
 // change keeps all needed information to redo/undo an insertion/deletion.
@@ -765,6 +868,7 @@ type piece struct {
 	prev, next *piece
 	data       []byte
 	nr         int
+	nl         int // number of newlines in data
 }
 
 func (p *piece) len() int {
@@ -774,12 +878,14 @@ func (p *piece) len() int {
 func (p *piece) insert(off int, data []byte, nr int) {
 	p.data = append(p.data[:off], append(data, p.data[off:]...)...)
 	p.nr += nr
+	p.nl += countNewlines(data)
 }
 
 func (p *piece) delete(off int, length int, nr int) bool {
 	if off+length > len(p.data) {
 		return false
 	}
+	p.nl -= countNewlines(p.data[off : off+length])
 	p.data = append(p.data[:off], p.data[off+int(length):]...)
 	p.nr -= nr
 	return true
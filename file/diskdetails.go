@@ -10,6 +10,13 @@ type DiskDetails struct {
 	Info  os.FileInfo
 	Hash  Hash // Used to check if the file has changed on disk since loaded.
 	isdir bool // Used to track if this File is populated from a directory list. [private]
+
+	hashof *mapping // If not nil, Hash is that of its contents, once computed (see hash).
+
+	encoding      string // Name of the encoding of the disk file. "" is UTF-8.
+	fixedencoding bool   // encoding was set explicitly rather than detected.
+	bom           bool   // The disk file starts with a byte order mark.
+	crlf          bool   // The lines of the disk file end with CRLF.
 }
 
 // IsDir returns true if the File has a synthetic backing of
@@ -28,13 +35,22 @@ func (f *DiskDetails) SetDir(isdir bool) {
 	f.isdir = isdir
 }
 
+// hash returns Hash, computing it first if it is that of a mapped file.
+func (f *DiskDetails) hash() Hash {
+	if f.hashof != nil {
+		f.Hash = CalcHash(f.hashof.data)
+		f.hashof = nil
+	}
+	return f.Hash
+}
+
 // UpdateInfo updates File's info to d if file hash hasn't changed.
 func (f *DiskDetails) UpdateInfo(filename string, d os.FileInfo) error {
 	h, err := HashFor(filename)
 	if err != nil {
 		return fmt.Errorf("failed to compute hash for %v: %v", filename, err)
 	}
-	if h.Eq(f.Hash) {
+	if h.Eq(f.hash()) {
 		f.Info = d
 	}
 	return nil
@@ -0,0 +1,214 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// UTF8 is the name of the default encoding of disk files.
+const UTF8 = "utf-8"
+
+// encodings maps the name of each supported encoding of disk files to its
+// implementation. A byte order mark is decoded into (and encoded from)
+// U+FEFF at the start of the text and is handled with the line endings
+// (see normalize).
+var encodings = map[string]encoding.Encoding{
+	UTF8:        unicode.UTF8,
+	"utf-16le":  unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":  unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"latin-1":   charmap.ISO8859_1,
+	"shift-jis": japanese.ShiftJIS,
+}
+
+// encodingaliases maps alternative names of encodings, stripped of
+// punctuation, to their names in encodings.
+var encodingaliases = map[string]string{
+	"utf8":       UTF8,
+	"utf16le":    "utf-16le",
+	"utf16be":    "utf-16be",
+	"latin1":     "latin-1",
+	"iso88591":   "latin-1",
+	"shiftjis":   "shift-jis",
+	"sjis":       "shift-jis",
+	"windows31j": "shift-jis",
+}
+
+// LookupEncoding returns the name of the encoding called name. Case and
+// punctuation in name are ignored and common aliases are accepted.
+func LookupEncoding(name string) (string, error) {
+	key := strings.Map(func(r rune) rune {
+		switch r {
+		case '-', '_', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+	if n, ok := encodingaliases[key]; ok {
+		return n, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", name)
+}
+
+// DetectEncoding returns the name of the encoding that the contents of a
+// disk file d are most likely in. A byte order mark identifies UTF-8 and
+// UTF-16. Otherwise, UTF-16 is recognised by its pattern of zero bytes
+// and text that is not UTF-8 is taken to be Shift-JIS if it looks like
+// Japanese and Latin-1 otherwise.
+func DetectEncoding(d []byte) string {
+	switch {
+	case bytes.HasPrefix(d, []byte{0xEF, 0xBB, 0xBF}):
+		return UTF8
+	case bytes.HasPrefix(d, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(d, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+	if len(d)%2 == 0 {
+		var even, odd int
+		for i, c := range d {
+			if c == 0 {
+				if i%2 == 0 {
+					even++
+				} else {
+					odd++
+				}
+			}
+		}
+		switch {
+		case odd > len(d)/4 && even == 0:
+			return "utf-16le"
+		case even > len(d)/4 && odd == 0:
+			return "utf-16be"
+		}
+	}
+	if utf8.Valid(d) || mostlyUTF8(d) {
+		// Text that is UTF-8 with a few bad bytes is UTF-8.
+		return UTF8
+	}
+	if looksLikeShiftJIS(d) {
+		return "shift-jis"
+	}
+	return "latin-1"
+}
+
+// mostlyUTF8 returns true if d contains more valid multi-byte UTF-8
+// sequences than bytes that aren't valid UTF-8. (Text in other encodings
+// can contain valid UTF-8 sequences by chance.)
+func mostlyUTF8(d []byte) bool {
+	var valid, invalid int
+	for len(d) > 0 {
+		r, n := utf8.DecodeRune(d)
+		switch {
+		case r == utf8.RuneError && n == 1:
+			invalid++
+		case n > 1:
+			valid++
+		}
+		d = d[n:]
+	}
+	return valid > invalid
+}
+
+// looksLikeShiftJIS returns true if d is valid Shift-JIS and most of its
+// double-byte characters have a trail byte outside of ASCII. (Latin-1
+// text can also be valid Shift-JIS but then an accented letter is the
+// lead byte and an ASCII letter the trail byte.)
+func looksLikeShiftJIS(d []byte) bool {
+	var high, low int
+	for i := 0; i < len(d); i++ {
+		c := d[i]
+		switch {
+		case c < 0x80, 0xA1 <= c && c <= 0xDF:
+			// ASCII or half-width katakana.
+		case 0x81 <= c && c <= 0x9F, 0xE0 <= c && c <= 0xEF:
+			if i+1 == len(d) {
+				return false
+			}
+			i++
+			switch t := d[i]; {
+			case 0x40 <= t && t <= 0x7E:
+				low++
+			case 0x80 <= t && t <= 0xFC:
+				high++
+			default:
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return high > low
+}
+
+// lookupCodec returns the implementation of the encoding called name or
+// nil if no conversion is needed.
+func lookupCodec(name string) encoding.Encoding {
+	if name == "" || name == UTF8 {
+		return nil
+	}
+	return encodings[name]
+}
+
+// Encoding returns the name of the encoding of the disk file backing e.
+func (e *ObservableEditableBuffer) Encoding() string {
+	if e.details.encoding == "" {
+		return UTF8
+	}
+	return e.details.encoding
+}
+
+// SetEncoding sets the encoding of the disk file backing e to the
+// encoding called name (see LookupEncoding). The encoding is used when
+// writing e (see EncodedReader) and, instead of detecting it, when
+// loading the file again.
+func (e *ObservableEditableBuffer) SetEncoding(name string) error {
+	n, err := LookupEncoding(name)
+	if err != nil {
+		return err
+	}
+	e.details.encoding = n
+	e.details.fixedencoding = true
+	return nil
+}
+
+// decode converts the contents d of a disk file from its encoding to
+// UTF-8. The encoding is detected from d unless it was set with
+// SetEncoding.
+func (e *ObservableEditableBuffer) decode(d []byte) ([]byte, error) {
+	if !e.details.fixedencoding {
+		e.details.encoding = DetectEncoding(d)
+	}
+	codec := lookupCodec(e.details.encoding)
+	if codec == nil {
+		return d, nil
+	}
+	return codec.NewDecoder().Bytes(d)
+}
+
+// EncodedReader returns a reader of the runes in [q0, q1) as they are
+// written to the disk file: in the encoding, line ending style and with
+// the byte order mark of e. Reading fails if a rune can't be represented
+// in the encoding.
+func (e *ObservableEditableBuffer) EncodedReader(q0, q1 int) io.Reader {
+	r := e.Reader(q0, q1)
+	if e.details.crlf {
+		r = transform.NewReader(r, crlfTransformer{})
+	}
+	if e.details.bom && q0 == 0 {
+		r = io.MultiReader(strings.NewReader(bom), r)
+	}
+	codec := lookupCodec(e.details.encoding)
+	if codec == nil {
+		return r
+	}
+	return transform.NewReader(r, codec.NewEncoder())
+}
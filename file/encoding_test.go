@@ -0,0 +1,105 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Encodings of "café 日本".
+var (
+	utf16le  = []byte{0xFF, 0xFE, 'c', 0, 'a', 0, 'f', 0, 0xE9, 0, ' ', 0, 0xE5, 0x65, 0x2C, 0x67}
+	utf16be  = []byte{0xFE, 0xFF, 0, 'c', 0, 'a', 0, 'f', 0, 0xE9, 0, ' ', 0x65, 0xE5, 0x67, 0x2C}
+	shiftjis = []byte{0x93, 0xFA, 0x96, 0x7B, 0x8C, 0xEA, 0x82, 0xC5, 0x82, 0xB7}
+)
+
+func TestDetectEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, UTF8},
+		{"ascii", []byte("hello\n"), UTF8},
+		{"utf-8", []byte("café 日本\n"), UTF8},
+		{"utf-8 bom", []byte("\uFEFFhello"), UTF8},
+		{"mostly utf-8", []byte("café 日本 \xFF"), UTF8},
+		{"utf-16le bom", utf16le, "utf-16le"},
+		{"utf-16be bom", utf16be, "utf-16be"},
+		{"utf-16le", []byte{'h', 0, 'i', 0}, "utf-16le"},
+		{"utf-16be", []byte{0, 'h', 0, 'i'}, "utf-16be"},
+		{"latin-1", []byte("caf\xE9\n"), "latin-1"},
+		{"latin-1 valid shift-jis", []byte("\xE9lan"), "latin-1"},
+		{"latin-1 umlauts", []byte("Gr\xFC\xDFe"), "latin-1"},
+		{"shift-jis", shiftjis, "shift-jis"},
+	} {
+		if got := DetectEncoding(tc.data); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLookupEncoding(t *testing.T) {
+	for name, want := range map[string]string{
+		"UTF-8":      UTF8,
+		"utf16le":    "utf-16le",
+		"ISO-8859-1": "latin-1",
+		"Shift_JIS":  "shift-jis",
+		"sjis":       "shift-jis",
+	} {
+		if got, err := LookupEncoding(name); err != nil || got != want {
+			t.Errorf("LookupEncoding(%q): got %q %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := LookupEncoding("ebcdic"); err == nil {
+		t.Errorf("LookupEncoding accepted an unknown encoding")
+	}
+}
+
+func TestLoadEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		data     []byte
+		encoding string
+		want     string
+	}{
+		{[]byte("café 日本"), UTF8, "café 日本"},
+		{utf16le, "utf-16le", "café 日本"},
+		{utf16be, "utf-16be", "café 日本"},
+		{[]byte("caf\xE9"), "latin-1", "café"},
+		{shiftjis, "shift-jis", "日本語です"},
+	} {
+		oeb := MakeObservableEditableBuffer("", nil)
+		if _, _, err := oeb.Load(0, bytes.NewReader(tc.data), true); err != nil {
+			t.Fatalf("%s: Load failed: %v", tc.encoding, err)
+		}
+		if got := oeb.Encoding(); got != tc.encoding {
+			t.Errorf("%s: got encoding %q", tc.encoding, got)
+		}
+		if got := oeb.String(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.encoding, got, tc.want)
+		}
+		b, err := io.ReadAll(oeb.EncodedReader(0, oeb.Nr()))
+		if err != nil || !bytes.Equal(b, tc.data) {
+			t.Errorf("%s: didn't round-trip: got %q %v, want %q", tc.encoding, b, err, tc.data)
+		}
+	}
+}
+
+func TestSetEncoding(t *testing.T) {
+	oeb := MakeObservableEditableBuffer("", nil)
+	if err := oeb.SetEncoding("latin1"); err != nil {
+		t.Fatalf("SetEncoding failed: %v", err)
+	}
+	// The encoding set is used instead of detecting Shift-JIS.
+	if _, _, err := oeb.Load(0, bytes.NewReader(shiftjis[:4]), true); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got, want := oeb.String(), "\u0093ú\u0096{"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	oeb.InsertAt(0, []rune("日"))
+	if _, err := io.ReadAll(oeb.EncodedReader(0, oeb.Nr())); err == nil {
+		t.Errorf("encoded a rune that Latin-1 can't represent")
+	}
+}
@@ -3,6 +3,7 @@ package file
 import (
 	"bytes"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -46,6 +47,34 @@ func TestDelObserver(t *testing.T) {
 	}
 }
 
+func TestRecorder(t *testing.T) {
+	f := MakeObservableEditableBuffer("", []rune{})
+	text := MakeTestObserver(t)
+	f.AddObserver(text)
+	rec := MakeTestObserver(t)
+	f.AddRecorder(rec)
+
+	if f.HasMultipleObservers() {
+		t.Errorf("HasMultipleObservers counted the recorder")
+	}
+	if got := f.GetCurObserver(); got != text {
+		t.Errorf("GetCurObserver: got %v, want %v", got, text)
+	}
+
+	f.InsertAt(0, []rune(s1))
+	f.DeleteAt(0, 3)
+	rec.Check([]*observation{
+		{callback: "Inserted", q0: 0, payload: s1},
+		{callback: "Deleted", q0: 0, q1: 3},
+	})
+
+	f.DelRecorder(rec)
+	f.InsertAt(0, []rune(s2))
+	if got, want := len(rec.tape), 0; got != want {
+		t.Errorf("DelRecorder: recorder saw %d changes, want %d", got, want)
+	}
+}
+
 const s1 = "hi 海老麺"
 const s2 = "bye"
 
@@ -586,3 +615,40 @@ func TestTagObserversFireCorrectly(t *testing.T) {
 		t.Errorf("got %+v, want %+v", got, want)
 	}
 }
+
+func TestMarks(t *testing.T) {
+	f := MakeObservableEditableBuffer("", []rune("one two three"))
+	m := f.Marks()
+	check := func(name string, q0, q1 int) {
+		t.Helper()
+		if g0, g1, ok := m.Get(name); !ok || g0 != q0 || g1 != q1 {
+			t.Errorf("got mark %q %d,%d,%v; want %d,%d", name, g0, g1, ok, q0, q1)
+		}
+	}
+	if _, _, ok := m.Get(""); ok {
+		t.Errorf("got a mark before setting one")
+	}
+	m.Set("", 4, 7)
+	m.Set("end", 13, 13)
+	f.InsertAt(0, []rune("zero "))
+	check("", 9, 12)
+	check("end", 18, 18)
+	f.InsertAt(10, []rune("w"))
+	check("", 9, 13)
+	f.InsertAt(13, []rune("!"))
+	check("", 9, 13)
+	f.DeleteAt(0, 5)
+	check("", 4, 8)
+	f.DeleteAt(6, 10)
+	check("", 4, 6)
+	f.DeleteAt(2, 12)
+	check("", 2, 2)
+	check("end", 2, 2)
+
+	if got, want := m.Names(), []string{"", "end"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %q; want %q", got, want)
+	}
+	if !m.Delete("end") || m.Delete("end") {
+		t.Errorf("Delete of a mark failed or succeeded twice")
+	}
+}
@@ -0,0 +1,382 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrHistoryMismatch is returned when restoring a History whose contents
+// differ from the contents of the buffer that it is being restored into.
+var ErrHistoryMismatch = errors.New("undo history does not match buffer contents")
+
+// History is a serializable snapshot of a Buffer's piece table and its
+// undo tree. It lets Undo and Redo continue to work after the
+// History has been persisted (e.g. in a dump file) and restored into a
+// new ObservableEditableBuffer.
+//
+// The piece table is stored as a graph: every piece reachable from the
+// current piece chain or from a change is recorded along with the ids of
+// its neighbours. A piece id of 0 represents nil.
+type History struct {
+	Pieces  []HistoryPiece
+	Begin   int             // id of the sentinel piece at the start of the chain
+	End     int             // id of the sentinel piece at the end of the chain
+	Actions []HistoryAction // every action in the undo tree in order of creation
+	Path    []int           // ids of the actions followed by Undo and Redo
+	Head    int             // number of actions in Path that are applied
+	Saved   int             // id of the action at the last Clean or 0
+
+	Seq    int // ObservableEditableBuffer undo sequence
+	PutSeq int // ObservableEditableBuffer sequence at the last Put
+}
+
+// HistoryPiece is the serialized form of a piece.
+type HistoryPiece struct {
+	ID   int
+	Prev int
+	Next int
+	Data string `json:",omitempty"`
+}
+
+// HistoryAction is the serialized form of an action.
+type HistoryAction struct {
+	ID      int
+	Parent  int // id of the parent action or 0 for the unmodified state
+	Seq     int
+	Time    time.Time
+	Kind    int    `json:",omitempty"`
+	Fname   string `json:",omitempty"`
+	Changes []HistoryChange
+}
+
+// HistoryChange is the serialized form of a change.
+type HistoryChange struct {
+	Off  int
+	Roff int
+	Old  HistorySpan
+	New  HistorySpan
+}
+
+// HistorySpan is the serialized form of a span.
+type HistorySpan struct {
+	Start int
+	End   int
+	Len   int
+}
+
+// MaxSeq returns the largest undo sequence number referenced by h.
+func (h *History) MaxSeq() int {
+	m := h.Seq
+	for _, a := range h.Actions {
+		if a.Seq > m {
+			m = a.Seq
+		}
+	}
+	return m
+}
+
+// Rebase adds base to every undo sequence number in h. Sequence numbers
+// are shared by all buffers so a restored History must be moved past
+// the sequence numbers already in use to avoid coupling its undo points
+// with those of unrelated buffers. Non-positive values (no sequence,
+// modified backing) are left alone.
+func (h *History) Rebase(base int) {
+	shift := func(s int) int {
+		if s > 0 {
+			return s + base
+		}
+		return s
+	}
+	for i := range h.Actions {
+		h.Actions[i].Seq = shift(h.Actions[i].Seq)
+	}
+	h.Seq = shift(h.Seq)
+	h.PutSeq = shift(h.PutSeq)
+}
+
+// LoadHistory reads a History previously written with History.Save.
+func LoadHistory(filename string) (*History, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var h History
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Save writes h to filename.
+func (h *History) Save(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return h.encode(f)
+}
+
+func (h *History) encode(w io.Writer) error {
+	return json.NewEncoder(w).Encode(h)
+}
+
+// history returns a snapshot of b's piece table and actions.
+func (b *Buffer) history() *History {
+	actions := b.allActions()
+	pieces := b.allPieces(actions)
+
+	h := &History{
+		Pieces:  make([]HistoryPiece, 0, len(pieces)),
+		Begin:   b.begin.id,
+		End:     b.end.id,
+		Actions: make([]HistoryAction, 0, len(actions)),
+		Path:    make([]int, 0, len(b.actions)),
+		Head:    b.head,
+	}
+	for _, p := range pieces {
+		h.Pieces = append(h.Pieces, HistoryPiece{
+			ID:   p.id,
+			Prev: p.prev.historyid(),
+			Next: p.next.historyid(),
+			Data: string(p.data),
+		})
+	}
+	if b.savedAction != nil {
+		h.Saved = b.savedAction.id
+	}
+	for _, a := range b.actions {
+		h.Path = append(h.Path, a.id)
+	}
+	for _, a := range actions {
+		ha := HistoryAction{
+			ID:      a.id,
+			Parent:  a.parent.id,
+			Seq:     a.seq,
+			Time:    a.time,
+			Kind:    a.kind,
+			Fname:   a.fname,
+			Changes: make([]HistoryChange, 0, len(a.changes)),
+		}
+		for _, c := range a.changes {
+			ha.Changes = append(ha.Changes, HistoryChange{
+				Off:  c.off,
+				Roff: c.roff,
+				Old:  c.old.history(),
+				New:  c.new.history(),
+			})
+		}
+		h.Actions = append(h.Actions, ha)
+	}
+	return h
+}
+
+// allPieces returns every piece reachable from the current piece chain
+// or from a change of one of actions, in order of creation.
+func (b *Buffer) allPieces(actions []*action) []*piece {
+	seen := make(map[*piece]bool)
+	work := []*piece{b.begin, b.end}
+	for _, a := range actions {
+		for _, c := range a.changes {
+			work = append(work, c.old.start, c.old.end, c.new.start, c.new.end)
+		}
+	}
+	var pieces []*piece
+	for len(work) > 0 {
+		p := work[len(work)-1]
+		work = work[:len(work)-1]
+		if p == nil || seen[p] {
+			continue
+		}
+		seen[p] = true
+		pieces = append(pieces, p)
+		work = append(work, p.prev, p.next)
+	}
+	sort.Slice(pieces, func(i, j int) bool { return pieces[i].id < pieces[j].id })
+	return pieces
+}
+
+func (p *piece) historyid() int {
+	if p == nil {
+		return 0
+	}
+	return p.id
+}
+
+func (s span) history() HistorySpan {
+	return HistorySpan{
+		Start: s.start.historyid(),
+		End:   s.end.historyid(),
+		Len:   s.len,
+	}
+}
+
+// newBufferFromHistory reconstructs a Buffer from h.
+func newBufferFromHistory(h *History) (*Buffer, error) {
+	b := &Buffer{pend: Ot(-1, -1)}
+
+	pieces := make(map[int]*piece, len(h.Pieces))
+	for _, hp := range h.Pieces {
+		if hp.ID <= 0 {
+			return nil, fmt.Errorf("invalid piece id %d in undo history", hp.ID)
+		}
+		data := []byte(hp.Data)
+		pieces[hp.ID] = &piece{
+			id:   hp.ID,
+			data: data,
+			nr:   utf8.RuneCount(data),
+			nl:   countNewlines(data),
+		}
+		if hp.ID > b.piecesCnt {
+			b.piecesCnt = hp.ID
+		}
+	}
+	lookup := func(id int) (*piece, error) {
+		if id == 0 {
+			return nil, nil
+		}
+		p, ok := pieces[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown piece id %d in undo history", id)
+		}
+		return p, nil
+	}
+	for _, hp := range h.Pieces {
+		p := pieces[hp.ID]
+		var err error
+		if p.prev, err = lookup(hp.Prev); err != nil {
+			return nil, err
+		}
+		if p.next, err = lookup(hp.Next); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if b.begin, err = lookup(h.Begin); err != nil || b.begin == nil {
+		return nil, fmt.Errorf("bad beginning sentinel in undo history")
+	}
+	if b.end, err = lookup(h.End); err != nil || b.end == nil {
+		return nil, fmt.Errorf("bad ending sentinel in undo history")
+	}
+	// Make sure that the current chain is well-formed before trusting it.
+	n := 0
+	for p := b.begin; p != b.end; p = p.next {
+		if p == nil || n > len(pieces) {
+			return nil, fmt.Errorf("broken piece chain in undo history")
+		}
+		n++
+	}
+
+	span := func(hs HistorySpan) (span, error) {
+		start, err := lookup(hs.Start)
+		if err != nil {
+			return span{}, err
+		}
+		end, err := lookup(hs.End)
+		if err != nil {
+			return span{}, err
+		}
+		return span{start: start, end: end, len: hs.Len}, nil
+	}
+
+	if h.Head < 0 || h.Head > len(h.Path) {
+		return nil, fmt.Errorf("undo history head %d out of range", h.Head)
+	}
+	b.root = &action{}
+	actions := map[int]*action{0: b.root}
+	for _, ha := range h.Actions {
+		parent, ok := actions[ha.Parent]
+		if !ok || ha.ID <= 0 || actions[ha.ID] != nil {
+			return nil, fmt.Errorf("bad action %d in undo history", ha.ID)
+		}
+		a := &action{
+			id:     ha.ID,
+			seq:    ha.Seq,
+			time:   ha.Time,
+			kind:   ha.Kind,
+			fname:  ha.Fname,
+			parent: parent,
+		}
+		parent.children = append(parent.children, a)
+		parent.redo = a
+		actions[ha.ID] = a
+		if ha.ID > b.actionsCnt {
+			b.actionsCnt = ha.ID
+		}
+		for _, hc := range ha.Changes {
+			c := &change{off: hc.Off, roff: hc.Roff}
+			if c.old, err = span(hc.Old); err != nil {
+				return nil, err
+			}
+			if c.new, err = span(hc.New); err != nil {
+				return nil, err
+			}
+			a.changes = append(a.changes, c)
+		}
+	}
+	if h.Saved != 0 {
+		b.savedAction = actions[h.Saved]
+	}
+
+	b.actions = make([]*action, 0, len(h.Path))
+	parent := b.root
+	for _, id := range h.Path {
+		a := actions[id]
+		if a == nil || a.parent != parent {
+			return nil, fmt.Errorf("bad path through undo history at action %d", id)
+		}
+		parent.redo = a
+		b.actions = append(b.actions, a)
+		parent = a
+	}
+	b.head = h.Head
+	b.End()
+	b.validateInvariant()
+	return b, nil
+}
+
+// History returns a snapshot of e's contents and undo history suitable
+// for persisting with History.Save or in a dump file.
+func (e *ObservableEditableBuffer) History() *History {
+	h := e.f.history()
+	h.Seq = e.seq
+	h.PutSeq = e.putseq
+	return h
+}
+
+// RestoreHistory replaces e's undo history with h so that the changes
+// recorded in h can be undone and redone. The contents recorded in h must
+// be the same as e's current contents: RestoreHistory returns
+// ErrHistoryMismatch otherwise and leaves e unchanged. Observers are not
+// notified of any insertions or deletions because the contents are
+// unchanged.
+func (e *ObservableEditableBuffer) RestoreHistory(h *History) error {
+	nb, err := newBufferFromHistory(h)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(nb.Bytes(), e.f.Bytes()) {
+		return ErrHistoryMismatch
+	}
+
+	e.filtertagobservers = false
+	before := e.getTagStatus()
+	defer e.notifyTagObservers(before)
+
+	nb.oeb = e
+	e.f = nb
+	e.seq = h.Seq
+	e.putseq = h.PutSeq
+	return nil
+}
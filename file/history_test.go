@@ -0,0 +1,148 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeHistoryTestBuffer() *ObservableEditableBuffer {
+	oeb := MakeObservableEditableBuffer("history.txt", []rune("hello world\n"))
+	oeb.Mark(1)
+	oeb.InsertAt(5, []rune(", 世界"))
+	oeb.Mark(2)
+	oeb.DeleteAt(0, 1)
+	oeb.InsertAt(0, []rune("J"))
+	oeb.Mark(3)
+	oeb.InsertAt(oeb.Nr(), []rune("bye\n"))
+	oeb.Clean()
+	oeb.Undo(true)
+	return oeb
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	oeb := makeHistoryTestBuffer()
+	if got, want := oeb.String(), "Jello, 世界 world\n"; got != want {
+		t.Fatalf("setup: got %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := oeb.History().encode(&buf); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "history.json")
+	if err := os.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	h, err := LoadHistory(filename)
+	if err != nil {
+		t.Fatalf("LoadHistory failed: %v", err)
+	}
+
+	restored := MakeObservableEditableBuffer("history.txt", nil)
+	if _, _, err := restored.Load(0, bytes.NewReader([]byte(oeb.String())), false); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	to := MakeTestObserver(t)
+	restored.AddObserver(to)
+	if err := restored.RestoreHistory(h); err != nil {
+		t.Fatalf("RestoreHistory failed: %v", err)
+	}
+	to.Check([]*observation{})
+
+	if got, want := restored.Seq(), oeb.Seq(); got != want {
+		t.Errorf("Seq: got %d, want %d", got, want)
+	}
+	if got, want := restored.Dirty(), oeb.Dirty(); got != want {
+		t.Errorf("Dirty: got %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		isundo bool
+		want   string
+	}{
+		{false, "Jello, 世界 world\nbye\n"},
+		{true, "Jello, 世界 world\n"},
+		{true, "hello, 世界 world\n"},
+		{true, "hello world\n"},
+		{false, "hello, 世界 world\n"},
+	} {
+		restored.Undo(tc.isundo)
+		if got := restored.String(); got != tc.want {
+			t.Errorf("Undo(%v): got %q, want %q", tc.isundo, got, tc.want)
+		}
+		if got, want := restored.Nr(), len([]rune(tc.want)); got != want {
+			t.Errorf("Undo(%v): Nr got %d, want %d", tc.isundo, got, want)
+		}
+	}
+
+	// New edits after a restore must not collide with restored pieces.
+	restored.Mark(restored.Seq() + 1)
+	restored.InsertAt(0, []rune("> "))
+	if got, want := restored.String(), "> hello, 世界 world\n"; got != want {
+		t.Errorf("insert after restore: got %q, want %q", got, want)
+	}
+	restored.Undo(true)
+	if got, want := restored.String(), "hello, 世界 world\n"; got != want {
+		t.Errorf("undo after restore: got %q, want %q", got, want)
+	}
+}
+
+func TestHistorySave(t *testing.T) {
+	oeb := makeHistoryTestBuffer()
+	filename := filepath.Join(t.TempDir(), "history.json")
+	if err := oeb.History().Save(filename); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	h, err := LoadHistory(filename)
+	if err != nil {
+		t.Fatalf("LoadHistory failed: %v", err)
+	}
+	if got, want := h.MaxSeq(), 3; got != want {
+		t.Errorf("MaxSeq: got %d, want %d", got, want)
+	}
+}
+
+func TestRestoreHistoryMismatch(t *testing.T) {
+	h := makeHistoryTestBuffer().History()
+
+	oeb := MakeObservableEditableBuffer("history.txt", []rune("something else\n"))
+	if err := oeb.RestoreHistory(h); err != ErrHistoryMismatch {
+		t.Fatalf("RestoreHistory: got error %v, want %v", err, ErrHistoryMismatch)
+	}
+	if got, want := oeb.String(), "something else\n"; got != want {
+		t.Errorf("buffer modified by failed restore: got %q, want %q", got, want)
+	}
+	if oeb.HasUndoableChanges() {
+		t.Errorf("failed restore left undoable changes")
+	}
+}
+
+func TestRestoreHistoryCorrupt(t *testing.T) {
+	h := makeHistoryTestBuffer().History()
+	h.Pieces[len(h.Pieces)-1].Next = 1000
+
+	oeb := MakeObservableEditableBuffer("history.txt", []rune("Jello, 世界 world\n"))
+	if err := oeb.RestoreHistory(h); err == nil {
+		t.Fatalf("RestoreHistory succeeded on a corrupt history")
+	}
+}
+
+func TestHistoryRebase(t *testing.T) {
+	oeb := makeHistoryTestBuffer()
+	oeb.Modded()
+	h := oeb.History()
+	h.Rebase(10)
+
+	if got, want := h.MaxSeq(), 13; got != want {
+		t.Errorf("MaxSeq: got %d, want %d", got, want)
+	}
+	if got, want := h.Seq, oeb.Seq()+10; got != want {
+		t.Errorf("Seq: got %d, want %d", got, want)
+	}
+	if got, want := h.PutSeq, -1; got != want {
+		t.Errorf("PutSeq: got %d, want %d", got, want)
+	}
+}
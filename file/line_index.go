@@ -0,0 +1,137 @@
+package file
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// Each piece records the number of newlines that it holds so that
+// converting between lines and rune offsets only needs to count the
+// pieces up to the one holding the line and scan that piece. Loaded
+// content is split into pieces of at most maxPieceSize bytes so that
+// scan is bounded. The piece found by the last conversion is cached:
+// conversions usually run through a file, or hit the same place, so
+// counting starts from there.
+
+// lineCache is the position of the piece most recently found by
+// LineToRune or RuneToLine. It is reset by every change to the Buffer.
+type lineCache struct {
+	p *piece // nil if not set
+	r int    // number of runes before p
+	l int    // number of newlines before p
+}
+
+// countNewlines returns the number of newlines in data.
+func countNewlines(data []byte) int {
+	return bytes.Count(data, []byte{'\n'})
+}
+
+// linestart returns the piece at which to start counting for a
+// conversion and the number of runes and newlines before it.
+func (b *Buffer) linestart() (*piece, int, int) {
+	if b.lines.p == nil {
+		return b.begin.next, 0, 0
+	}
+	return b.lines.p, b.lines.r, b.lines.l
+}
+
+// LineToRune returns the offset in runes of the start of line l where
+// the first line is line 0. If b has fewer than l+1 lines, LineToRune
+// returns the number of runes in b and false.
+//
+// LineToRune takes time proportional to the number of pieces between
+// the line and the piece found by the last conversion, plus the size of
+// the piece holding the line.
+func (b *Buffer) LineToRune(l int) (int, bool) {
+	if l <= 0 {
+		return 0, l == 0
+	}
+	p, r, nl := b.linestart()
+	for p.prev != b.begin && l <= nl {
+		p = p.prev
+		r -= p.nr
+		nl -= p.nl
+	}
+	for p != b.end && l > nl+p.nl {
+		r += p.nr
+		nl += p.nl
+		p = p.next
+	}
+	if p == b.end {
+		return r, false
+	}
+	b.lines = lineCache{p: p, r: r, l: nl}
+
+	// Line l starts after the (l-nl)-th newline in p.
+	data := p.data
+	for l -= nl; ; {
+		i := bytes.IndexByte(data, '\n')
+		r += utf8.RuneCount(data[:i+1])
+		data = data[i+1:]
+		if l--; l == 0 {
+			return r, true
+		}
+	}
+}
+
+// RuneToLine returns the line (where the first line is line 0) holding
+// the rune offset q. That is, the number of newlines before q.
+//
+// RuneToLine takes time proportional to the number of pieces between
+// q and the piece found by the last conversion, plus the size of the
+// piece holding q.
+func (b *Buffer) RuneToLine(q int) int {
+	if q <= 0 {
+		return 0
+	}
+	p, r, l := b.linestart()
+	for p.prev != b.begin && q < r {
+		p = p.prev
+		r -= p.nr
+		l -= p.nl
+	}
+	for p != b.end && q >= r+p.nr {
+		r += p.nr
+		l += p.nl
+		p = p.next
+	}
+	if p == b.end {
+		return l
+	}
+	b.lines = lineCache{p: p, r: r, l: l}
+
+	data := p.data
+	for q -= r; q > 0; q-- {
+		c, sz := utf8.DecodeRune(data)
+		if c == '\n' {
+			l++
+		}
+		data = data[sz:]
+	}
+	return l
+}
+
+// Nl returns the number of newlines in b. It takes time proportional to
+// the number of pieces.
+func (b *Buffer) Nl() int {
+	n := 0
+	for p := b.begin.next; p != b.end; p = p.next {
+		n += p.nl
+	}
+	return n
+}
+
+// LineToRune is a forwarding function for file.LineToRune.
+func (e *ObservableEditableBuffer) LineToRune(l int) (int, bool) {
+	return e.f.LineToRune(l)
+}
+
+// RuneToLine is a forwarding function for file.RuneToLine.
+func (e *ObservableEditableBuffer) RuneToLine(q int) int {
+	return e.f.RuneToLine(q)
+}
+
+// Nl is a forwarding function for file.Nl.
+func (e *ObservableEditableBuffer) Nl() int {
+	return e.f.Nl()
+}
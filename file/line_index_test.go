@@ -0,0 +1,100 @@
+package file
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkLineIndex compares LineToRune and RuneToLine on b with the
+// results of scanning rs. To keep the test fast, only a sample of the
+// offsets and lines are checked, first forwards and then backwards
+// through b.
+func checkLineIndex(t *testing.T, b *Buffer, rs []rune) {
+	t.Helper()
+	type sample struct{ q, l int }
+	var runes, lines []sample
+	l := 0
+	for q, r := range rs {
+		if q%61 == 0 {
+			runes = append(runes, sample{q, l})
+		}
+		if (q == 0 || rs[q-1] == '\n') && l%61 == 0 {
+			lines = append(lines, sample{q, l})
+		}
+		if r == '\n' {
+			l++
+		}
+	}
+	check := func(i int) {
+		t.Helper()
+		if i < len(runes) {
+			s := runes[i]
+			if got := b.RuneToLine(s.q); got != s.l {
+				t.Fatalf("RuneToLine(%d): got %d, want %d", s.q, got, s.l)
+			}
+		}
+		if i < len(lines) {
+			s := lines[i]
+			if got, ok := b.LineToRune(s.l); !ok || got != s.q {
+				t.Fatalf("LineToRune(%d): got %d %v, want %d", s.l, got, ok, s.q)
+			}
+		}
+	}
+	n := max(len(runes), len(lines))
+	for i := 0; i < n; i++ {
+		check(i)
+	}
+	for i := n - 1; i >= 0; i-- {
+		check(i)
+	}
+	if got := b.RuneToLine(len(rs)); got != l {
+		t.Errorf("RuneToLine(end): got %d, want %d", got, l)
+	}
+	if got := b.Nl(); got != l {
+		t.Errorf("Nl: got %d, want %d", got, l)
+	}
+	if got, ok := b.LineToRune(l + 1); ok || got != len(rs) {
+		t.Errorf("LineToRune past the end: got %d %v, want %d false", got, ok, len(rs))
+	}
+}
+
+func insertRunes(rs []rune, q int, s string) []rune {
+	return append(rs[:q:q], append([]rune(s), rs[q:]...)...)
+}
+
+func TestLineIndex(t *testing.T) {
+	b := NewBufferNoNr(nil)
+	checkLineIndex(t, b, nil)
+
+	initial := strings.Repeat("héllo\n世界\n\n", maxPieceSize/8)
+	rs := []rune(initial)
+	b = NewBufferNoNr([]byte(initial))
+	checkLineIndex(t, b, rs)
+
+	b.insertString(3, "a\nb", t)
+	rs = insertRunes(rs, 3, "a\nb")
+	checkLineIndex(t, b, rs)
+
+	// Append to the cached piece.
+	b.cacheInsertString(6, "\nc", t)
+	rs = insertRunes(rs, 6, "\nc")
+	checkLineIndex(t, b, rs)
+	snapshot := append([]rune(nil), rs...)
+
+	b.delete(4, 3, t)
+	rs = append(rs[:4], rs[7:]...)
+	checkLineIndex(t, b, rs)
+
+	// Delete across pieces.
+	n := len(rs) / 2
+	b.delete(n-100, 200, t)
+	rs = append(rs[:n-100], rs[n+100:]...)
+	checkLineIndex(t, b, rs)
+
+	b.Undo(0)
+	b.Undo(0)
+	checkLineIndex(t, b, snapshot)
+	if got := string(b.Bytes()); got != string(snapshot) {
+		t.Errorf("Undo: contents differ")
+	}
+}
@@ -0,0 +1,82 @@
+package file
+
+import (
+	"bytes"
+
+	"golang.org/x/text/transform"
+)
+
+// bom is the byte order mark in UTF-8.
+const bom = "\uFEFF"
+
+// hasLineEndingStyle returns true if normalize would alter the UTF-8
+// contents d of a disk file.
+func hasLineEndingStyle(d []byte) bool {
+	return bytes.HasPrefix(d, []byte(bom)) || isCRLF(d)
+}
+
+// isCRLF returns true if every line of d ends with CRLF. Files with
+// mixed line endings are left alone so that they round-trip.
+func isCRLF(d []byte) bool {
+	n := bytes.Count(d, []byte("\n"))
+	return n > 0 && bytes.Count(d, []byte("\r\n")) == n
+}
+
+// normalize records whether the UTF-8 contents d of the disk file
+// backing e start with a byte order mark and have CRLF line endings and
+// returns d without them. EncodedReader restores them.
+func (e *ObservableEditableBuffer) normalize(d []byte) []byte {
+	e.details.bom = bytes.HasPrefix(d, []byte(bom))
+	if e.details.bom {
+		d = d[len(bom):]
+	}
+	e.details.crlf = isCRLF(d)
+	if e.details.crlf {
+		d = bytes.ReplaceAll(d, []byte("\r\n"), []byte("\n"))
+	}
+	return d
+}
+
+// CRLF returns true if the lines of the disk file backing e end with
+// CRLF instead of LF.
+func (e *ObservableEditableBuffer) CRLF() bool {
+	return e.details.crlf
+}
+
+// SetCRLF sets whether the lines of e end with CRLF when written.
+func (e *ObservableEditableBuffer) SetCRLF(crlf bool) {
+	e.details.crlf = crlf
+}
+
+// BOM returns true if the disk file backing e starts with a byte order
+// mark.
+func (e *ObservableEditableBuffer) BOM() bool {
+	return e.details.bom
+}
+
+// SetBOM sets whether e is written with a byte order mark.
+func (e *ObservableEditableBuffer) SetBOM(bom bool) {
+	e.details.bom = bom
+}
+
+// crlfTransformer converts LF line endings to CRLF.
+type crlfTransformer struct{ transform.NopResetter }
+
+func (crlfTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		c := src[nSrc]
+		if c == '\n' {
+			if nDst+2 > len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = '\r'
+			nDst++
+		} else if nDst+1 > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		dst[nDst] = c
+		nDst++
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
@@ -0,0 +1,88 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLineEndings(t *testing.T) {
+	for _, tc := range []struct {
+		data      string
+		want      string
+		crlf, bom bool
+	}{
+		{"a\nb\n", "a\nb\n", false, false},
+		{"a\r\nb\r\n", "a\nb\n", true, false},
+		{"a\r\nb", "a\nb", true, false},
+		{"a\r\nb\n", "a\r\nb\n", false, false},
+		{"\uFEFFa\r\n", "a\n", true, true},
+		{"\uFEFF", "", false, true},
+		{"a\rb", "a\rb", false, false},
+	} {
+		oeb := MakeObservableEditableBuffer("", nil)
+		if _, _, err := oeb.Load(0, bytes.NewReader([]byte(tc.data)), true); err != nil {
+			t.Fatalf("%q: Load failed: %v", tc.data, err)
+		}
+		if got := oeb.String(); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.data, got, tc.want)
+		}
+		if oeb.CRLF() != tc.crlf || oeb.BOM() != tc.bom {
+			t.Errorf("%q: got crlf %v bom %v, want %v %v", tc.data, oeb.CRLF(), oeb.BOM(), tc.crlf, tc.bom)
+		}
+		b, err := io.ReadAll(oeb.EncodedReader(0, oeb.Nr()))
+		if err != nil || string(b) != tc.data {
+			t.Errorf("%q: didn't round-trip: got %q %v", tc.data, b, err)
+		}
+	}
+}
+
+func TestLoadLineEndingsMapped(t *testing.T) {
+	defer func(old int64) { mapThreshold = old }(mapThreshold)
+	mapThreshold = 1
+
+	s := "\uFEFFhello\r\nworld\r\n"
+	filename := filepath.Join(t.TempDir(), "crlf")
+	if err := os.WriteFile(filename, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	oeb := MakeObservableEditableBuffer(filename, nil)
+	if _, _, err := oeb.Load(0, fd, true); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if oeb.Mapped() {
+		t.Errorf("mapped a file that must be converted")
+	}
+	if got, want := oeb.String(), "hello\nworld\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !oeb.CRLF() || !oeb.BOM() {
+		t.Errorf("got crlf %v bom %v, want true true", oeb.CRLF(), oeb.BOM())
+	}
+}
+
+func TestSetLineEndings(t *testing.T) {
+	oeb := MakeObservableEditableBuffer("", []rune("a\nb\n"))
+	oeb.SetCRLF(true)
+	oeb.SetBOM(true)
+	for _, tc := range []struct {
+		q0, q1 int
+		want   string
+	}{
+		{0, 4, "\uFEFFa\r\nb\r\n"},
+		{2, 4, "b\r\n"},
+	} {
+		b, err := io.ReadAll(oeb.EncodedReader(tc.q0, tc.q1))
+		if err != nil || string(b) != tc.want {
+			t.Errorf("EncodedReader(%d, %d): got %q %v, want %q", tc.q0, tc.q1, b, err, tc.want)
+		}
+	}
+}
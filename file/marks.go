@@ -0,0 +1,83 @@
+package file
+
+import "sort"
+
+// Marks holds the named marks of a buffer: ranges of runes that move
+// with the text around them as text is inserted and deleted. The mark
+// named "" is the one set by the k command of Edit without a name.
+//
+// Marks is a BufferObserver, which the buffer adds as a recorder.
+type Marks struct {
+	m map[string][2]int
+}
+
+var _ BufferObserver = (*Marks)(nil)
+
+// Marks returns the named marks of e.
+func (e *ObservableEditableBuffer) Marks() *Marks {
+	if e.marks == nil {
+		e.marks = &Marks{}
+		e.AddRecorder(e.marks)
+	}
+	return e.marks
+}
+
+// Set sets the mark called name to the runes [q0, q1).
+func (m *Marks) Set(name string, q0, q1 int) {
+	if m.m == nil {
+		m.m = make(map[string][2]int)
+	}
+	m.m[name] = [2]int{q0, q1}
+}
+
+// Get returns the mark called name and true, or the empty range at 0
+// and false if there is no such mark.
+func (m *Marks) Get(name string) (q0, q1 int, ok bool) {
+	r, ok := m.m[name]
+	return r[0], r[1], ok
+}
+
+// Delete removes the mark called name. It returns false if there was no
+// such mark.
+func (m *Marks) Delete(name string) bool {
+	_, ok := m.m[name]
+	delete(m.m, name)
+	return ok
+}
+
+// Names returns the names of the marks in sorted order.
+func (m *Marks) Names() []string {
+	names := make([]string, 0, len(m.m))
+	for name := range m.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Inserted moves the marks after the insertion of nr runes at q0. A
+// mark grows if the insertion is inside it.
+func (m *Marks) Inserted(q0 OffsetTuple, _ []byte, nr int) {
+	for name, r := range m.m {
+		if q0.R < r[1] {
+			r[1] += nr
+		}
+		if q0.R < r[0] {
+			r[0] += nr
+		}
+		m.m[name] = r
+	}
+}
+
+// Deleted moves the marks after the deletion of the runes [q0, q1). A
+// mark shrinks by the part of it deleted.
+func (m *Marks) Deleted(q0, q1 OffsetTuple) {
+	for name, r := range m.m {
+		for i, q := range r {
+			if q > q0.R {
+				r[i] = q - (min(q, q1.R) - q0.R)
+			}
+		}
+		m.m[name] = r
+	}
+}
@@ -0,0 +1,129 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"unicode/utf8"
+)
+
+// mapThreshold is the size above which files are memory-mapped instead
+// of read into memory by ObservableEditableBuffer.Load.
+var mapThreshold int64 = 8 << 20
+
+// A mapping is a read-only memory-mapped file. The pieces of a Buffer
+// can refer to the mapped memory but must never modify it. The mapping
+// is released when the ObservableEditableBuffer holding it is closed
+// (see Close) or, failing that, once it is garbage.
+//
+// What is mapped is a private copy of the file, removed once it is
+// unmapped: changes made to the file by other programs can't show
+// through and truncating it can't crash Edwood on access to the missing
+// pages.
+type mapping struct {
+	data []byte
+}
+
+// mapOpenFile maps a copy of the contents of fd if fd is a regular file
+// larger than mapThreshold. It returns nil if fd can't or shouldn't be
+// mapped, having read none of it.
+func mapOpenFile(fd *os.File) *mapping {
+	if !mapSupported {
+		return nil
+	}
+	fi, err := fd.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() < mapThreshold || fi.Size() > math.MaxInt {
+		return nil
+	}
+	off, err := fd.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	tmp, err := os.CreateTemp("", "edwood")
+	if err != nil {
+		return nil
+	}
+	defer tmp.Close()
+	os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, fd)
+	var data []byte
+	if err == nil && n > 0 && n <= math.MaxInt {
+		data, err = mapFile(tmp, int(n))
+	}
+	if err != nil || data == nil {
+		fd.Seek(off, io.SeekStart)
+		return nil
+	}
+	m := &mapping{data: data}
+	runtime.SetFinalizer(m, (*mapping).release)
+	return m
+}
+
+// release unmaps m.
+func (m *mapping) release() {
+	runtime.SetFinalizer(m, nil)
+	if m.data != nil {
+		unmapFile(m.data)
+		m.data = nil
+	}
+}
+
+// mappedPieces returns the chain of pieces, made as by chunks, that hold
+// data and the number of runes in them. It returns nil if data isn't
+// valid UTF-8 or has NUL bytes (which Load would have to alter) or, if
+// style is true, has a line ending style (see hasLineEndingStyle). Each
+// piece is checked and counted in turn so that data is read only once.
+func (b *Buffer) mappedPieces(data []byte, style bool) (first, last *piece, nr int) {
+	if style && bytes.HasPrefix(data, []byte(bom)) {
+		return nil, nil, 0
+	}
+	ok := true
+	var nl, ncrlf int
+	prev := b.begin
+	chunks(data, func(c []byte, cnr int) {
+		if !ok {
+			return
+		}
+		if !utf8.Valid(c) || bytes.IndexByte(c, 0) >= 0 {
+			ok = false
+			return
+		}
+		p := b.newPiece(c, prev, nil, cnr)
+		if first == nil {
+			first = p
+		} else {
+			prev.next = p
+		}
+		if style {
+			ncrlf += bytes.Count(c, []byte("\r\n"))
+			if c[0] == '\n' && prev != b.begin && prev.data[len(prev.data)-1] == '\r' {
+				ncrlf++
+			}
+		}
+		prev = p
+		nl += p.nl
+		nr += cnr
+	})
+	if !ok || style && nl > 0 && ncrlf == nl {
+		return nil, nil, 0
+	}
+	return first, prev, nr
+}
+
+// Close releases the memory-mapped files that the contents of e refer
+// to. e must not be used afterwards.
+func (e *ObservableEditableBuffer) Close() {
+	for _, m := range e.f.mappings {
+		m.release()
+	}
+	e.f.mappings = nil
+}
+
+// Mapped returns true if some of the contents of e are backed by a
+// memory-mapped file.
+func (e *ObservableEditableBuffer) Mapped() bool {
+	return len(e.f.mappings) > 0
+}
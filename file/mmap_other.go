@@ -0,0 +1,21 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package file
+
+import (
+	"errors"
+	"os"
+)
+
+// mapSupported is true if files can be memory-mapped.
+const mapSupported = false
+
+// mapFile isn't supported: files are always read into memory.
+func mapFile(fd *os.File, size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
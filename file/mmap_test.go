@@ -0,0 +1,218 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// checkPieces verifies that the pieces of b are no larger than
+// maxPieceSize and don't split runes.
+func checkPieces(t *testing.T, b *Buffer) int {
+	t.Helper()
+	n := 0
+	for p := b.begin.next; p != b.end; p = p.next {
+		if p.len() > maxPieceSize {
+			t.Errorf("piece %d has %d bytes", p.id, p.len())
+		}
+		if !utf8.Valid(p.data) {
+			t.Errorf("piece %d splits a rune", p.id)
+		}
+		if got := utf8.RuneCount(p.data); got != p.nr {
+			t.Errorf("piece %d has %d runes, recorded %d", p.id, got, p.nr)
+		}
+		n++
+	}
+	return n
+}
+
+func TestNewBufferChunks(t *testing.T) {
+	s := strings.Repeat("a海老麺", maxPieceSize/5)
+	b := NewBufferNoNr([]byte(s))
+	if n := checkPieces(t, b); n < 2 {
+		t.Errorf("got %d pieces, want several", n)
+	}
+	if got := string(b.Bytes()); got != s {
+		t.Errorf("contents differ")
+	}
+	if got, want := b.Nr(), utf8.RuneCountInString(s); got != want {
+		t.Errorf("Nr: got %d, want %d", got, want)
+	}
+}
+
+func TestLoadMapped(t *testing.T) {
+	defer func(old int64) { mapThreshold = old }(mapThreshold)
+	mapThreshold = 1
+
+	s := strings.Repeat("hello 世界\n", maxPieceSize/4)
+	filename := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(filename, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	oeb := MakeObservableEditableBuffer(filename, nil)
+	to := &testObserver{t: t}
+	oeb.AddObserver(to)
+	n, hasNulls, err := oeb.Load(0, fd, true)
+	if err != nil || hasNulls {
+		t.Fatalf("Load failed: %v %v", err, hasNulls)
+	}
+	if !oeb.Mapped() {
+		t.Skip("memory-mapped files aren't supported")
+	}
+	if len(to.tape) != 1 {
+		t.Errorf("Load notified the observers %d times, want once", len(to.tape))
+	}
+	oeb.DelObserver(to)
+	if got, want := n, utf8.RuneCountInString(s); got != want {
+		t.Errorf("Load: got %d runes, want %d", got, want)
+	}
+	if got, want := oeb.Hash(), CalcHash([]byte(s)); !got.Eq(want) {
+		t.Errorf("Load set the wrong hash")
+	}
+	if n := checkPieces(t, oeb.f); n < 2 {
+		t.Errorf("got %d pieces, want several", n)
+	}
+
+	// Edits must not modify the mapped file.
+	oeb.Mark(1)
+	nr := oeb.Nr()
+	oeb.InsertAt(nr, []rune("end"))
+	oeb.InsertAt(nr+3, []rune("!"))
+	oeb.InsertAt(3, []rune("X"))
+	oeb.DeleteAt(nr/2, nr/2+100)
+	want := []rune(s)
+	want = append(want, []rune("end!")...)
+	want = append(want[:3], append([]rune("X"), want[3:]...)...)
+	want = append(want[:nr/2], want[nr/2+100:]...)
+	if got := oeb.String(); got != string(want) {
+		t.Errorf("edits of mapped contents went wrong")
+	}
+	if d, err := os.ReadFile(filename); err != nil || string(d) != s {
+		t.Errorf("mapped file was modified: %v", err)
+	}
+	oeb.Undo(true)
+	if got := oeb.String(); got != s {
+		t.Errorf("Undo: contents differ")
+	}
+}
+
+func TestMappedPieces(t *testing.T) {
+	long := strings.Repeat("x", maxPieceSize-1)
+	for _, tc := range []struct {
+		name  string
+		data  string
+		style bool
+		ok    bool
+	}{
+		{"Text", "hello\n世界\n", true, true},
+		{"NUL", "a\x00b\n", false, false},
+		{"Invalid", "a\xffb\n", false, false},
+		{"BOM", bom + "a\n", true, false},
+		{"BOMKept", bom + "a\n", false, true},
+		{"CRLF", "a\r\nb\r\n", true, false},
+		{"MixedCRLF", "a\r\nb\n", true, true},
+		// A CRLF split between two pieces.
+		{"CRLFSplit", long + "\r\nb\r\n", true, false},
+		{"MixedCRLFSplit", long + "\r\nb\n", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBuffer(nil, 0)
+			first, last, nr := b.mappedPieces([]byte(tc.data), tc.style)
+			if got := first != nil; got != tc.ok {
+				t.Fatalf("got pieces %v, want %v", got, tc.ok)
+			}
+			if !tc.ok {
+				return
+			}
+			var got []byte
+			for p := first; ; p = p.next {
+				got = append(got, p.data...)
+				if p == last {
+					break
+				}
+			}
+			if string(got) != tc.data || nr != utf8.RuneCountInString(tc.data) {
+				t.Errorf("got %q with %d runes, want %q", got, nr, tc.data)
+			}
+		})
+	}
+}
+
+func TestMappedFileTruncated(t *testing.T) {
+	defer func(old int64) { mapThreshold = old }(mapThreshold)
+	mapThreshold = 1
+
+	s := strings.Repeat("hello 世界\n", maxPieceSize/4)
+	filename := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(filename, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	oeb := MakeObservableEditableBuffer(filename, nil)
+	if _, _, err := oeb.Load(0, fd, true); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !oeb.Mapped() {
+		t.Skip("memory-mapped files aren't supported")
+	}
+
+	// What is mapped is a copy, so neither truncating the file nor
+	// changing it shows through.
+	if err := os.Truncate(filename, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := oeb.String(); got != s {
+		t.Errorf("contents changed with the file")
+	}
+	if got, want := oeb.Hash(), CalcHash([]byte(s)); !got.Eq(want) {
+		t.Errorf("hash changed with the file")
+	}
+
+	oeb.Close()
+	if oeb.Mapped() {
+		t.Errorf("Close didn't release the mapping")
+	}
+}
+
+func TestLoadNotMapped(t *testing.T) {
+	defer func(old int64) { mapThreshold = old }(mapThreshold)
+	mapThreshold = 1
+
+	// NUL bytes are elided so a file holding them can't be mapped.
+	filename := filepath.Join(t.TempDir(), "nul")
+	if err := os.WriteFile(filename, []byte("a\x00b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	oeb := MakeObservableEditableBuffer(filename, nil)
+	if _, hasNulls, err := oeb.Load(0, fd, true); err != nil || !hasNulls {
+		t.Fatalf("Load failed: %v %v", err, hasNulls)
+	}
+	if oeb.Mapped() {
+		t.Errorf("file with NUL bytes was mapped")
+	}
+	if got, want := oeb.String(), "ab"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapSupported is true if files can be memory-mapped.
+const mapSupported = true
+
+// mapFile maps the first size bytes of fd read-only and private into
+// memory.
+func mapFile(fd *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(fd.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_PRIVATE)
+}
+
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -18,6 +18,7 @@ import (
 type ObservableEditableBuffer struct {
 	currobserver    BufferObserver
 	observers       map[BufferObserver]struct{}
+	recorders       map[BufferObserver]struct{}
 	statusobservers map[TagStatusObserver]struct{}
 
 	f *Buffer
@@ -34,9 +35,12 @@ type ObservableEditableBuffer struct {
 	seq    int // undo sequencing
 	putseq int // seq on last put
 
+	marks *Marks // Created when first used.
+
 	// TODO(rjk): Can we get rid of these two booleans?
 	isscratch    bool // Used to track if this File should warn on unsaved deletion.
 	treatasclean bool // Toggle to override the Dirty check on closing a buffer with unsaved changes.
+	readonly     bool // If true, e is not to be modified (see ReadOnly).
 
 	filtertagobservers bool // If true, TagStatus updates are filtered.
 }
@@ -53,6 +57,7 @@ const (
 // Set is a forwarding function for file_hash.Set
 func (e *ObservableEditableBuffer) Set(hash []byte) {
 	e.details.Hash.Set(hash)
+	e.details.hashof = nil
 }
 
 func (e *ObservableEditableBuffer) SetInfo(info os.FileInfo) {
@@ -84,6 +89,22 @@ func (e *ObservableEditableBuffer) DelObserver(observer BufferObserver) error {
 	return fmt.Errorf("can't find editor in File.DelObserver")
 }
 
+// AddRecorder adds recorder as an observer for edits to this File.
+// Unlike the observers added with AddObserver, a recorder isn't a view
+// of the File: it is never the current observer and doesn't count
+// towards HasMultipleObservers.
+func (e *ObservableEditableBuffer) AddRecorder(recorder BufferObserver) {
+	if e.recorders == nil {
+		e.recorders = make(map[BufferObserver]struct{})
+	}
+	e.recorders[recorder] = struct{}{}
+}
+
+// DelRecorder removes recorder as an observer for edits to this File.
+func (e *ObservableEditableBuffer) DelRecorder(recorder BufferObserver) {
+	delete(e.recorders, recorder)
+}
+
 // SetCurObserver sets the current observer.
 func (e *ObservableEditableBuffer) SetCurObserver(observer BufferObserver) {
 	e.currobserver = observer
@@ -258,26 +279,111 @@ func (e *ObservableEditableBuffer) SaveableAndDirty() bool {
 //
 // TODO(rjk): Consider renaming InsertAtFromFd or something similar.
 //
-// TODO(rjk): Read and insert in chunks.
+// Large files are memory-mapped instead of being read (see mapOpenFile)
+// so that only the edited parts of them use the heap. The contents are
+// inserted in chunks to bound the size of the pieces. The hash of a
+// mapped file is only computed when it is first needed.
 //
 // TODO(flux): Innefficient to load the file, then copy into the slice,
 // but I need the UTF-8 interpretation. I could fix this by using a UTF-8
 // -> []rune reader on top of the os.File instead.
 func (e *ObservableEditableBuffer) Load(q0 int, fd io.Reader, sethash bool) (int, bool, error) {
-	d, err := io.ReadAll(fd)
-	// TODO(rjk): improve handling of read errors.
-	if err != nil {
-		err = errors.New("read error in RuneArray.Load")
+	var d []byte
+	var err error
+
+	// Only UTF-8 disk files are mapped: others are converted.
+	mappable := !sethash || !e.details.fixedencoding || lookupCodec(e.details.encoding) == nil
+	if f, ok := fd.(*os.File); ok && mappable {
+		if m := mapOpenFile(f); m != nil {
+			if nr, ok := e.loadMapped(q0, m, sethash); ok {
+				return nr, false, nil
+			}
+			// The contents must be converted.
+			d = m.data
+			defer m.release()
+		}
+	}
+
+	if d == nil {
+		d, err = io.ReadAll(fd)
+		// TODO(rjk): improve handling of read errors.
+		if err != nil {
+			err = errors.New("read error in RuneArray.Load")
+		}
 	}
 	if sethash {
 		e.SetHash(CalcHash(d))
+		// Only the disk file backing e is in its encoding and line
+		// ending style.
+		if dd, derr := e.decode(d); derr != nil {
+			if err == nil {
+				err = derr
+			}
+		} else {
+			d = e.normalize(dd)
+		}
 	}
 
 	runes, _, hasNulls := util.Cvttorunes(d, len(d))
-	e.InsertAt(q0, runes)
+	s, _ := RunesToBytes(runes)
+	e.insertChunks(e.f.RuneTuple(q0), s)
 	return len(runes), hasNulls, err
 }
 
+// loadMapped inserts the contents of the mapping m at q0, as Load
+// does, and returns the number of runes inserted. It returns false,
+// inserting nothing, if the contents must be converted first.
+func (e *ObservableEditableBuffer) loadMapped(q0 int, m *mapping, sethash bool) (int, bool) {
+	first, last, nr := e.f.mappedPieces(m.data, sethash)
+	if first == nil {
+		return 0, false
+	}
+	if sethash {
+		e.details.hashof = m
+		e.details.encoding = UTF8
+		e.details.bom, e.details.crlf = false, false
+	}
+	e.f.mappings = append(e.f.mappings, m)
+	e.insertPieces(e.f.RuneTuple(q0), first, last, m.data, nr)
+	return nr, true
+}
+
+// insertChunks inserts data at p0 as a sequence of pieces (see chunks)
+// and returns the number of runes inserted. The pieces are never modified
+// in place so data can be read-only.
+func (e *ObservableEditableBuffer) insertChunks(p0 OffsetTuple, data []byte) int {
+	var first, last *piece
+	nr := 0
+	chunks(data, func(c []byte, cnr int) {
+		p := e.f.newPiece(c, last, nil, cnr)
+		if first == nil {
+			first = p
+		} else {
+			last.next = p
+		}
+		last = p
+		nr += cnr
+	})
+	e.insertPieces(p0, first, last, data, nr)
+	return nr
+}
+
+// insertPieces inserts the chain of pieces from first to last, which
+// hold data and nr runes, at p0 as Insert would insert data.
+func (e *ObservableEditableBuffer) insertPieces(p0 OffsetTuple, first, last *piece, data []byte, nr int) {
+	if first == nil || e.readonly {
+		return
+	}
+	before := e.getTagStatus()
+	defer e.notifyTagObservers(before)
+
+	e.f.insertPieces(p0, first, last, len(data), nr, e.seq)
+	if e.seq < 1 {
+		e.f.FlattenHistory()
+	}
+	e.inserted(p0, data, nr)
+}
+
 // Dirty returns true when the ObservableEditableBuffer differs from its disk
 // backing as tracked by the undo system.
 func (e *ObservableEditableBuffer) Dirty() bool {
@@ -293,8 +399,12 @@ func (e *ObservableEditableBuffer) InsertAt(rp0 int, rs []rune) {
 	e.Insert(p0, s, nr)
 }
 
-// Insert is a forwarding function for file.Insert.
+// Insert is a forwarding function for file.Insert. It does nothing if
+// e is read-only.
 func (e *ObservableEditableBuffer) Insert(p0 OffsetTuple, s []byte, nr int) {
+	if e.readonly {
+		return
+	}
 	before := e.getTagStatus()
 	defer e.notifyTagObservers(before)
 
@@ -327,8 +437,12 @@ func (e *ObservableEditableBuffer) SetName(name string) {
 	e.setfilename(name)
 }
 
-// Undo is a forwarding function for file.Undo.
+// Undo is a forwarding function for file.Undo. It does nothing, and
+// returns false, if e is read-only.
 func (e *ObservableEditableBuffer) Undo(isundo bool) (q0, q1 int, ok bool) {
+	if e.readonly {
+		return 0, 0, false
+	}
 	before := e.getTagStatus()
 	defer e.notifyTagObservers(before)
 
@@ -349,8 +463,12 @@ func (e *ObservableEditableBuffer) DeleteAt(rp0, rp1 int) {
 	e.Delete(p0, p1)
 }
 
-// Delete is a forwarding function for buffer.Delete.
+// Delete is a forwarding function for buffer.Delete. It does nothing if
+// e is read-only.
 func (e *ObservableEditableBuffer) Delete(q0, q1 OffsetTuple) {
+	if e.readonly {
+		return
+	}
 	before := e.getTagStatus()
 	defer e.notifyTagObservers(before)
 
@@ -394,12 +512,13 @@ func (e *ObservableEditableBuffer) UpdateInfo(filename string, d os.FileInfo) er
 
 // Hash is a getter for DiskDetails.Hash
 func (e *ObservableEditableBuffer) Hash() Hash {
-	return e.details.Hash
+	return e.details.hash()
 }
 
 // SetHash is a setter for DiskDetails.Hash
 func (e *ObservableEditableBuffer) SetHash(hash Hash) {
 	e.details.Hash = hash
+	e.details.hashof = nil
 }
 
 // Seq is a getter for file.details.Seq.
@@ -413,6 +532,41 @@ func (e *ObservableEditableBuffer) RedoSeq() int {
 	return e.f.RedoSeq()
 }
 
+// AtBranch is a forwarding function for file.AtBranch.
+func (e *ObservableEditableBuffer) AtBranch() bool {
+	return e.f.AtBranch()
+}
+
+// NextRedoBranch is a forwarding function for file.NextRedoBranch.
+func (e *ObservableEditableBuffer) NextRedoBranch() bool {
+	return e.f.NextRedoBranch()
+}
+
+// SelectRedoBranch is a forwarding function for file.SelectRedoBranch.
+func (e *ObservableEditableBuffer) SelectRedoBranch(seq int) bool {
+	return e.f.SelectRedoBranch(seq)
+}
+
+// UndoTree is a forwarding function for file.UndoTree.
+func (e *ObservableEditableBuffer) UndoTree() string {
+	return e.f.UndoTree()
+}
+
+// UndoDepth is a forwarding function for file.UndoDepth.
+func (e *ObservableEditableBuffer) UndoDepth() int {
+	return e.f.UndoDepth()
+}
+
+// SeqsSince is a forwarding function for file.SeqsSince.
+func (e *ObservableEditableBuffer) SeqsSince(depth int) ([]int, bool) {
+	return e.f.SeqsSince(depth)
+}
+
+// MergeSince is a forwarding function for file.MergeSince.
+func (e *ObservableEditableBuffer) MergeSince(depth int) {
+	e.f.MergeSince(depth)
+}
+
 // inserted is a package-only entry point from the underlying
 // buffer (file.Buffer or file.File) to run the registered observers
 // on a change in the buffer.
@@ -421,6 +575,9 @@ func (e *ObservableEditableBuffer) inserted(q0 OffsetTuple, b []byte, nr int) {
 	for observer := range e.observers {
 		observer.Inserted(q0, b, nr)
 	}
+	for recorder := range e.recorders {
+		recorder.Inserted(q0, b, nr)
+	}
 }
 
 // deleted is a package-only entry point from the underlying
@@ -431,6 +588,9 @@ func (e *ObservableEditableBuffer) deleted(q0, q1 OffsetTuple) {
 	for observer := range e.observers {
 		observer.Deleted(q0, q1)
 	}
+	for recorder := range e.recorders {
+		recorder.Deleted(q0, q1)
+	}
 }
 
 // IsDirOrScratch returns true if the File has a synthetic backing of
@@ -0,0 +1,142 @@
+package file
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSavedUnknown is returned by SavedBytes when the contents of an
+// ObservableEditableBuffer at its last Clean aren't known.
+var ErrSavedUnknown = errors.New("contents when last saved unknown")
+
+// UndoTime returns the time of the action that Undo would revert and
+// false if there is no such action.
+func (b *Buffer) UndoTime() (time.Time, bool) {
+	if b.head == 0 {
+		return time.Time{}, false
+	}
+	return b.actions[b.head-1].time, true
+}
+
+// RedoTime returns the time of the action that Redo would reapply and
+// false if there is no such action.
+func (b *Buffer) RedoTime() (time.Time, bool) {
+	if b.head >= len(b.actions) {
+		return time.Time{}, false
+	}
+	return b.actions[b.head].time, true
+}
+
+// UndoTime is a forwarding function for file.UndoTime.
+func (e *ObservableEditableBuffer) UndoTime() (time.Time, bool) {
+	if !e.HasUndoableChanges() {
+		return time.Time{}, false
+	}
+	return e.f.UndoTime()
+}
+
+// RedoTime is a forwarding function for file.RedoTime.
+func (e *ObservableEditableBuffer) RedoTime() (time.Time, bool) {
+	return e.f.RedoTime()
+}
+
+// UndoTo undoes or redoes actions along the current branch of the undo
+// tree until e is in its state at time when: every action made at or
+// before when is applied and every later action is not. step undoes (or
+// redoes) the next action of e and returns false if it couldn't, which
+// ends UndoTo.
+func (e *ObservableEditableBuffer) UndoTo(when time.Time, step func(isundo bool) bool) {
+	for t, ok := e.UndoTime(); ok && t.After(when); t, ok = e.UndoTime() {
+		if !step(true) {
+			return
+		}
+	}
+	for t, ok := e.RedoTime(); ok && !t.After(when); t, ok = e.RedoTime() {
+		if !step(false) {
+			return
+		}
+	}
+}
+
+// undoTo is UndoTo with the steps made by Undo.
+func (e *ObservableEditableBuffer) undoTo(when time.Time) {
+	e.UndoTo(when, func(isundo bool) bool {
+		_, _, ok := e.Undo(isundo)
+		return ok
+	})
+}
+
+// BytesAt returns the contents of e as they were at time when along the
+// current branch of the undo tree. e is not modified.
+func (e *ObservableEditableBuffer) BytesAt(when time.Time) ([]byte, error) {
+	s, err := e.copyWithHistory()
+	if err != nil {
+		return nil, err
+	}
+	s.undoTo(when)
+	return s.f.Bytes(), nil
+}
+
+// SavedBytes returns the contents of e as they were when e was last the
+// same as its disk file (see Clean) along the current branch of the undo
+// tree or ErrSavedUnknown if that isn't known. e is not modified.
+func (e *ObservableEditableBuffer) SavedBytes() ([]byte, error) {
+	if e.putseq < 0 {
+		return nil, ErrSavedUnknown
+	}
+	s, err := e.copyWithHistory()
+	if err != nil {
+		return nil, err
+	}
+	for seq, ok := s.undoSeq(); ok && seq > e.putseq; seq, ok = s.undoSeq() {
+		s.Undo(true)
+	}
+	for seq, ok := s.redoSeq(); ok && seq <= e.putseq; seq, ok = s.redoSeq() {
+		s.Undo(false)
+	}
+	return s.f.Bytes(), nil
+}
+
+// undoSeq returns the sequence number of the action that Undo would
+// revert and false if there is no such action.
+func (e *ObservableEditableBuffer) undoSeq() (int, bool) {
+	b := e.f
+	if !e.HasUndoableChanges() || b.head == 0 {
+		return 0, false
+	}
+	return b.actions[b.head-1].seq, true
+}
+
+// redoSeq returns the sequence number of the action that Redo would
+// reapply and false if there is no such action.
+func (e *ObservableEditableBuffer) redoSeq() (int, bool) {
+	b := e.f
+	if b.head >= len(b.actions) {
+		return 0, false
+	}
+	return b.actions[b.head].seq, true
+}
+
+// copyWithHistory returns a copy of e with the same undo history.
+func (e *ObservableEditableBuffer) copyWithHistory() (*ObservableEditableBuffer, error) {
+	nb, err := newBufferFromHistory(e.f.history())
+	if err != nil {
+		return nil, err
+	}
+	s := MakeObservableEditableBuffer(e.Name(), nil)
+	nb.oeb = s
+	s.f = nb
+	s.seq = e.seq
+	return s, nil
+}
+
+// SetReadOnly sets whether e can be modified. Insert, Delete and Undo
+// do nothing to a read-only e.
+func (e *ObservableEditableBuffer) SetReadOnly(readonly bool) {
+	e.readonly = readonly
+}
+
+// ReadOnly returns true if e can't be modified.
+func (e *ObservableEditableBuffer) ReadOnly() bool {
+	return e.readonly
+}
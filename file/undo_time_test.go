@@ -0,0 +1,123 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+// makeTimedBuffer returns an ObservableEditableBuffer with three
+// actions made a minute apart starting at start.
+func makeTimedBuffer(start time.Time) *ObservableEditableBuffer {
+	oeb := MakeObservableEditableBuffer("", []rune("abc"))
+	for i, s := range []string{"d", "e", "f"} {
+		oeb.Mark(i + 1)
+		oeb.InsertAt(oeb.Nr(), []rune(s))
+		oeb.f.actions[i].time = start.Add(time.Duration(i) * time.Minute)
+	}
+	return oeb
+}
+
+func TestUndoTime(t *testing.T) {
+	start := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	oeb := makeTimedBuffer(start)
+
+	if got, ok := oeb.UndoTime(); !ok || !got.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("UndoTime: got %v %v, want %v", got, ok, start.Add(2*time.Minute))
+	}
+	if _, ok := oeb.RedoTime(); ok {
+		t.Errorf("RedoTime succeeded with nothing to redo")
+	}
+
+	oeb.Undo(true)
+	if got, ok := oeb.RedoTime(); !ok || !got.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("RedoTime: got %v %v, want %v", got, ok, start.Add(2*time.Minute))
+	}
+	oeb.Undo(true)
+	oeb.Undo(true)
+	if _, ok := oeb.UndoTime(); ok {
+		t.Errorf("UndoTime succeeded with nothing to undo")
+	}
+}
+
+func TestUndoTo(t *testing.T) {
+	start := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	oeb := makeTimedBuffer(start)
+
+	for _, tc := range []struct {
+		when time.Time
+		want string
+	}{
+		{start.Add(90 * time.Second), "abcde"},
+		{start.Add(-time.Minute), "abc"},
+		{start, "abcd"},
+		{start.Add(time.Hour), "abcdef"},
+	} {
+		oeb.undoTo(tc.when)
+		if got := oeb.String(); got != tc.want {
+			t.Errorf("undoTo(%v): got %q, want %q", tc.when, got, tc.want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	start := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	oeb := makeTimedBuffer(start)
+	oeb.SetReadOnly(true)
+
+	oeb.Mark(4)
+	oeb.InsertAt(0, []rune("x"))
+	oeb.DeleteAt(0, 1)
+	if _, _, ok := oeb.Undo(true); ok {
+		t.Errorf("Undo of a read-only buffer succeeded")
+	}
+	oeb.undoTo(start)
+	if got, want := oeb.String(), "abcdef"; got != want {
+		t.Errorf("read-only buffer modified: got %q, want %q", got, want)
+	}
+}
+
+func TestBytesAt(t *testing.T) {
+	start := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	oeb := makeTimedBuffer(start)
+
+	b, err := oeb.BytesAt(start.Add(30 * time.Second))
+	if err != nil {
+		t.Fatalf("BytesAt failed: %v", err)
+	}
+	if got, want := string(b), "abcd"; got != want {
+		t.Errorf("BytesAt: got %q, want %q", got, want)
+	}
+	if got, want := oeb.String(), "abcdef"; got != want {
+		t.Errorf("BytesAt modified the buffer: got %q, want %q", got, want)
+	}
+}
+
+func TestSavedBytes(t *testing.T) {
+	oeb := MakeObservableEditableBuffer("", []rune("abc"))
+	for i, s := range []string{"d", "e", "f"} {
+		oeb.Mark(i + 1)
+		oeb.InsertAt(oeb.Nr(), []rune(s))
+		if i == 1 {
+			oeb.Clean()
+		}
+	}
+
+	check := func(step string) {
+		t.Helper()
+		if got, err := oeb.SavedBytes(); err != nil || string(got) != "abcde" {
+			t.Errorf("%s: SavedBytes got %q %v, want %q", step, got, err, "abcde")
+		}
+	}
+	check("after edit")
+	oeb.Undo(true)
+	oeb.Undo(true)
+	check("after undo")
+	if got, want := oeb.String(), "abcd"; got != want {
+		t.Errorf("SavedBytes modified the buffer: got %q, want %q", got, want)
+	}
+
+	oeb.Modded()
+	if _, err := oeb.SavedBytes(); err != ErrSavedUnknown {
+		t.Errorf("SavedBytes after Modded: got error %v, want %v", err, ErrSavedUnknown)
+	}
+}
@@ -0,0 +1,171 @@
+package file
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rjkroege/edwood/sam"
+)
+
+// The undo history of a Buffer is a tree of actions rooted at b.root.
+// Performing an action after an Undo starts a new branch instead of
+// discarding the undone actions. b.actions is the path through the tree
+// from the root to the tip of the branch that Redo will follow and
+// b.head is the number of actions on that path that are applied.
+
+// currentNode returns the most recently applied action or the root if
+// no actions are applied.
+func (b *Buffer) currentNode() *action {
+	if b.head == 0 {
+		return b.root
+	}
+	return b.actions[b.head-1]
+}
+
+// followRedo rebuilds the redo part of the path by following the redo
+// links from the current action.
+func (b *Buffer) followRedo() {
+	b.actions = b.actions[:b.head]
+	for a := b.currentNode().redo; a != nil; a = a.redo {
+		b.actions = append(b.actions, a)
+	}
+}
+
+// AtBranch reports whether there are several alternative actions that
+// can be redone from the current state.
+func (b *Buffer) AtBranch() bool {
+	return len(b.currentNode().children) > 1
+}
+
+// NextRedoBranch makes Redo follow the next (in order of creation,
+// wrapping around) alternative branch from the current state. It returns
+// false if there is no alternative branch.
+func (b *Buffer) NextRedoBranch() bool {
+	n := b.currentNode()
+	if len(n.children) < 2 {
+		return false
+	}
+	for i, c := range n.children {
+		if c == n.redo {
+			n.redo = n.children[(i+1)%len(n.children)]
+			break
+		}
+	}
+	b.followRedo()
+	return true
+}
+
+// SelectRedoBranch makes Redo follow the branch from the current state
+// whose first action has sequence number seq. It returns false if there
+// is no such branch. This lets a branch switch in one Buffer be mirrored
+// in the other Buffers changed by the same multi-file Edit command.
+func (b *Buffer) SelectRedoBranch(seq int) bool {
+	n := b.currentNode()
+	for i := len(n.children) - 1; i >= 0; i-- {
+		if c := n.children[i]; c.seq == seq {
+			n.redo = c
+			b.followRedo()
+			return true
+		}
+	}
+	return false
+}
+
+// UndoDepth returns the number of actions that Undo can revert in turn.
+func (b *Buffer) UndoDepth() int {
+	return b.head
+}
+
+// SeqsSince returns the sequence numbers of the actions that Undo can
+// revert in turn back to depth (see UndoDepth), the most recent last. It
+// returns false if the actions at depth were since undone.
+func (b *Buffer) SeqsSince(depth int) ([]int, bool) {
+	if depth < 0 || depth > b.head {
+		return nil, false
+	}
+	seqs := make([]int, 0, b.head-depth)
+	for _, a := range b.actions[depth:b.head] {
+		seqs = append(seqs, a.seq)
+	}
+	return seqs, true
+}
+
+// MergeSince makes the actions that Undo can revert in turn back to depth
+// (see UndoDepth) a single action that Undo reverts at once. The merged
+// action restores the name of the first rename among them, if any. The
+// branches of the undo tree that start within these actions are dropped.
+func (b *Buffer) MergeSince(depth int) {
+	if depth < 0 || depth >= b.head-1 {
+		return
+	}
+	m := b.actions[depth]
+	last := b.actions[b.head-1]
+	for _, a := range b.actions[depth+1 : b.head] {
+		if a.kind == sam.Filename && m.kind != sam.Filename {
+			m.kind = sam.Filename
+			m.fname = a.fname
+		}
+		m.changes = append(m.changes, a.changes...)
+	}
+	m.children = last.children
+	for _, c := range m.children {
+		c.parent = m
+	}
+	m.redo = last.redo
+	if b.savedAction == last {
+		b.savedAction = m
+	}
+	b.actions = append(b.actions[:depth+1], b.actions[b.head:]...)
+	b.head = depth + 1
+	b.SetUndoPoint()
+}
+
+// allActions returns every action in the undo tree in order of creation.
+func (b *Buffer) allActions() []*action {
+	var all []*action
+	work := append([]*action(nil), b.root.children...)
+	for len(work) > 0 {
+		a := work[len(work)-1]
+		work = work[:len(work)-1]
+		all = append(all, a)
+		work = append(work, a.children...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].id < all[j].id })
+	return all
+}
+
+// UndoTree returns a textual description of the undo tree. Each line
+// describes one action, in order of creation, with 5 space-separated
+// fields: the action's id, the id of its parent (0 if it is a child of
+// the unmodified state), its undo sequence number, the time it was
+// made and its state. The state is one of:
+//
+//	current	the most recently applied action
+//	undo	an applied action that Undo will revert
+//	redo	an action that Redo will reapply
+//	branch	an action on an alternative branch
+func (b *Buffer) UndoTree() string {
+	state := make(map[*action]string)
+	for i, a := range b.actions {
+		switch {
+		case i < b.head-1:
+			state[a] = "undo"
+		case i == b.head-1:
+			state[a] = "current"
+		default:
+			state[a] = "redo"
+		}
+	}
+
+	var sb strings.Builder
+	for _, a := range b.allActions() {
+		st, ok := state[a]
+		if !ok {
+			st = "branch"
+		}
+		fmt.Fprintf(&sb, "%d %d %d %s %s\n", a.id, a.parent.id, a.seq, a.time.Format(time.RFC3339), st)
+	}
+	return sb.String()
+}
@@ -0,0 +1,177 @@
+package file
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUndoTreeKeepsBranches(t *testing.T) {
+	b := NewBufferNoNr([]byte("abc"))
+	b.insertString(3, "d", t)
+	b.insertString(4, "e", t)
+	b.checkContent("#0", t, "abcde")
+
+	b.Undo(0)
+	b.Undo(0)
+	b.checkContent("#1", t, "abc")
+	if b.AtBranch() {
+		t.Errorf("AtBranch true with a single branch")
+	}
+
+	// A new action after an undo starts a second branch.
+	b.insertString(0, "X", t)
+	b.checkContent("#2", t, "Xabc")
+	if b.HasRedoableChanges() {
+		t.Errorf("HasRedoableChanges true at the tip of a new branch")
+	}
+
+	b.Undo(0)
+	b.checkContent("#3", t, "abc")
+	if !b.AtBranch() {
+		t.Errorf("AtBranch false at a branch point")
+	}
+
+	// Redo follows the most recent branch.
+	b.Redo(0)
+	b.checkContent("#4", t, "Xabc")
+	b.Undo(0)
+
+	// Switch back to the original branch and redo all of it.
+	if !b.NextRedoBranch() {
+		t.Fatalf("NextRedoBranch failed at a branch point")
+	}
+	b.Redo(0)
+	b.checkContent("#5", t, "abcd")
+	b.Redo(0)
+	b.checkContent("#6", t, "abcde")
+	if b.HasRedoableChanges() {
+		t.Errorf("HasRedoableChanges true at the tip of the original branch")
+	}
+
+	// And back to the new branch again.
+	b.Undo(0)
+	b.Undo(0)
+	if !b.NextRedoBranch() {
+		t.Fatalf("NextRedoBranch failed at a branch point")
+	}
+	b.Redo(0)
+	b.checkContent("#7", t, "Xabc")
+	if b.NextRedoBranch() {
+		t.Errorf("NextRedoBranch succeeded without a branch")
+	}
+	b.checkContent("#8", t, "Xabc")
+}
+
+func TestSelectRedoBranch(t *testing.T) {
+	oeb := MakeObservableEditableBuffer("", []rune("abc"))
+	oeb.Mark(1)
+	oeb.InsertAt(3, []rune("d"))
+	oeb.Undo(true)
+	oeb.Mark(2)
+	oeb.InsertAt(0, []rune("X"))
+	oeb.Undo(true)
+
+	if got, want := oeb.RedoSeq(), 2; got != want {
+		t.Errorf("RedoSeq: got %d, want %d", got, want)
+	}
+	if oeb.SelectRedoBranch(3) {
+		t.Errorf("SelectRedoBranch succeeded for a missing seq")
+	}
+	if !oeb.SelectRedoBranch(1) {
+		t.Fatalf("SelectRedoBranch failed")
+	}
+	if got, want := oeb.RedoSeq(), 1; got != want {
+		t.Errorf("RedoSeq: got %d, want %d", got, want)
+	}
+	oeb.Undo(false)
+	if got, want := oeb.String(), "abcd"; got != want {
+		t.Errorf("after redo: got %q, want %q", got, want)
+	}
+}
+
+func TestMergeSince(t *testing.T) {
+	oeb := MakeObservableEditableBuffer("/a", []rune("abc"))
+	oeb.Mark(1)
+	oeb.InsertAt(3, []rune("d"))
+	depth := oeb.UndoDepth()
+
+	oeb.Mark(2)
+	oeb.SetName("/b")
+	oeb.InsertAt(0, []rune("X"))
+	oeb.InsertAt(5, []rune("e"))
+	seqs, ok := oeb.SeqsSince(depth)
+	if !ok || len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 2 {
+		t.Fatalf("SeqsSince: got %v, %v; want [2 2], true", seqs, ok)
+	}
+
+	oeb.MergeSince(depth)
+	if got, want := oeb.UndoDepth(), depth+1; got != want {
+		t.Errorf("UndoDepth after merge: got %d, want %d", got, want)
+	}
+	oeb.Undo(true)
+	if got, want := oeb.String(), "abcd"; got != want {
+		t.Errorf("after undo: got %q, want %q", got, want)
+	}
+	if got, want := oeb.Name(), "/a"; got != want {
+		t.Errorf("after undo: got name %q, want %q", got, want)
+	}
+	if _, ok := oeb.SeqsSince(depth + 1); ok {
+		t.Errorf("SeqsSince succeeded for an undone action")
+	}
+	oeb.Undo(false)
+	if got, want := oeb.String(), "Xabcde"; got != want {
+		t.Errorf("after redo: got %q, want %q", got, want)
+	}
+}
+
+func TestUndoTree(t *testing.T) {
+	oeb := MakeObservableEditableBuffer("", []rune("abc"))
+	oeb.Mark(1)
+	oeb.InsertAt(3, []rune("d"))
+	oeb.Mark(2)
+	oeb.InsertAt(4, []rune("e"))
+	oeb.Undo(true)
+	oeb.Undo(true)
+	oeb.Mark(3)
+	oeb.InsertAt(0, []rune("X"))
+	oeb.Undo(true)
+	oeb.Undo(false)
+
+	want := []struct {
+		id, parent, seq, state string
+	}{
+		{"1", "0", "1", "branch"},
+		{"2", "1", "2", "branch"},
+		{"3", "0", "3", "current"},
+	}
+	lines := strings.Split(strings.TrimSuffix(oeb.UndoTree(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("UndoTree: got %d lines, want %d:\n%s", len(lines), len(want), oeb.UndoTree())
+	}
+	for i, l := range lines {
+		f := strings.Fields(l)
+		if len(f) != 5 {
+			t.Errorf("line %d: got %d fields, want 5: %q", i, len(f), l)
+			continue
+		}
+		if w := want[i]; f[0] != w.id || f[1] != w.parent || f[2] != w.seq || f[4] != w.state {
+			t.Errorf("line %d: got %q, want id %s parent %s seq %s state %s", i, l, w.id, w.parent, w.seq, w.state)
+		}
+	}
+
+	// The branch survives a round trip through History.
+	h := oeb.History()
+	restored := MakeObservableEditableBuffer("", []rune(oeb.String()))
+	if err := restored.RestoreHistory(h); err != nil {
+		t.Fatalf("RestoreHistory failed: %v", err)
+	}
+	restored.Undo(true)
+	if !restored.NextRedoBranch() {
+		t.Fatalf("NextRedoBranch failed after restore")
+	}
+	restored.Undo(false)
+	restored.Undo(false)
+	if got, want := restored.String(), "abcde"; got != want {
+		t.Errorf("after restore: got %q, want %q", got, want)
+	}
+}
@@ -282,7 +282,7 @@ func TestFilenameChangeUndo(t *testing.T) {
 
 			t.Log(*varfontflag, defaultVarFont)
 
-			got, err := global.row.dump()
+			got, err := global.row.dumpstate(false)
 			if err != nil {
 				t.Fatalf("dump failed: %v", err)
 			}
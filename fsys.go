@@ -23,6 +23,14 @@ type fileServer struct {
 	closing     bool
 	username    string
 	messagesize int
+
+	// For connections accepted by listen9p, whose clients can hang up
+	// without clunking their fids.
+	lk        sync.Mutex      // Protects inflight and hungup.
+	inflight  map[uint16]bool // Tags of the requests not yet responded to.
+	hungup    bool
+	secret    []byte // Keys the responses to auth challenges; nil if auth isn't required.
+	authfails int    // Wrong responses to auth challenges.
 }
 
 const DEBUG = false
@@ -56,14 +64,19 @@ var (
 var dirtab = []*DirTab{
 	{".", plan9.QTDIR, Qdir, 0500 | plan9.DMDIR},
 	{"acme", plan9.QTDIR, Qacme, 0500 | plan9.DMDIR},
+	{"col", plan9.QTDIR, Qcol, 0500 | plan9.DMDIR},
 	{"cons", plan9.QTFILE, Qcons, 0600},
 	{"consctl", plan9.QTFILE, Qconsctl, 0000},
+	{"ctl", plan9.QTFILE, Qctl, 0600},
 	{"draw", plan9.QTDIR, Qdraw, 0000 | plan9.DMDIR}, // to suppress graphics progs started in acme
 	{"editout", plan9.QTFILE, Qeditout, 0200},
+	{"eventjson", plan9.QTFILE, Qeventjson, 0600},
 	{"index", plan9.QTFILE, Qindex, 0400},
+	{"index.json", plan9.QTFILE, Qindexjson, 0400},
 	{"label", plan9.QTFILE, Qlabel, 0600},
-	{"log", plan9.QTFILE, Qlog, 0400},
+	{"log", plan9.QTFILE, Qlog, 0600},
 	{"new", plan9.QTDIR, Qnew, 0500 | plan9.DMDIR},
+	{"snarf", plan9.QTFILE, Qsnarf, 0600},
 }
 
 var dirtabw = []*DirTab{
@@ -72,15 +85,38 @@ var dirtabw = []*DirTab{
 	{"body", plan9.QTAPPEND, QWbody, 0600 | plan9.DMAPPEND},
 	{"ctl", plan9.QTFILE, QWctl, 0600},
 	{"data", plan9.QTFILE, QWdata, 0600},
+	{"diff", plan9.QTFILE, QWdiff, 0400},
 	{"editout", plan9.QTFILE, QWeditout, 0200},
 	{"errors", plan9.QTFILE, QWerrors, 0200},
 	{"event", plan9.QTFILE, QWevent, 0600},
+	{"eventjson", plan9.QTFILE, QWeventjson, 0600},
+	{"history", plan9.QTFILE, QWhistory, 0400},
+	{"info.json", plan9.QTFILE, QWinfojson, 0400},
+	{"lines", plan9.QTFILE, QWlines, 0600},
+	{"marks", plan9.QTFILE, QWmarks, 0600},
 	{"rdsel", plan9.QTFILE, QWrdsel, 0400},
 	{"wrsel", plan9.QTFILE, QWwrsel, 0200},
 	{"tag", plan9.QTAPPEND, QWtag, 0600 | plan9.DMAPPEND},
 	{"xdata", plan9.QTFILE, QWxdata, 0600},
 }
 
+var dirtabc = []*DirTab{
+	{".", plan9.QTDIR, QCdir, 0500 | plan9.DMDIR},
+	{"ctl", plan9.QTFILE, QCctl, 0600},
+	{"index", plan9.QTFILE, QCindex, 0400},
+	{"tag", plan9.QTAPPEND, QCtag, 0600 | plan9.DMAPPEND},
+}
+
+// columnDirTab returns the DirTab entry for the directory of column n.
+func columnDirTab(n int) *DirTab {
+	return &DirTab{
+		name: fmt.Sprintf("%d", n),
+		t:    plan9.QTDIR,
+		qid:  QCdir,
+		perm: plan9.DMDIR | 0500,
+	}
+}
+
 // windowDirTab returns the DirTab entry for window directory for the window with given id.
 func windowDirTab(id int) *DirTab {
 	return &DirTab{
@@ -133,17 +169,25 @@ func (fs *fileServer) fsysproc() {
 			if fs.closing {
 				break
 			}
+			if fs.inflight != nil {
+				fs.hangup()
+				break
+			}
 			util.AcmeError("fsysproc", err)
 		}
 		if DEBUG {
 			fmt.Fprintf(os.Stderr, "<-- %v\n", fc)
 		}
 		if x == nil {
-			global.cxfidalloc <- nil
-			x = <-global.cxfidalloc
+			x = allocxfid()
 		}
 		x.fcall = *fc
 		x.fs = fs
+		if fs.inflight != nil {
+			fs.lk.Lock()
+			fs.inflight[fc.Tag] = true
+			fs.lk.Unlock()
+		}
 		switch x.fcall.Type {
 		case plan9.Tversion:
 			fallthrough
@@ -160,12 +204,31 @@ func (fs *fileServer) fsysproc() {
 				x = fs.respond(x, fc, fmt.Errorf("fid not in use"))
 				continue
 			}
+			if f.auth {
+				x.f = f
+				x = fs.authrpc(x, f)
+				continue
+			}
 		}
 		x.f = f
 		x = fs.fcall[x.fcall.Type](x, f)
 	}
 }
 
+// allocxfid returns a free Xfid from xfidallocthread. The request and
+// the reply share a channel, so the file servers of several connections
+// must take turns.
+func allocxfid() *Xfid {
+	fsysthreadlk.Lock()
+	defer fsysthreadlk.Unlock()
+	global.cxfidalloc <- nil
+	return <-global.cxfidalloc
+}
+
+// fsysthreadlk serializes the requests of file servers to the threads
+// that they share.
+var fsysthreadlk sync.Mutex
+
 // Add creates a new MntDir and returns a new reference to it.
 func (mnt *Mnt) Add(dir string, incl []string) *MntDir {
 	mnt.lk.Lock()
@@ -241,7 +304,20 @@ func (fs *fileServer) respond(x *Xfid, t *plan9.Fcall, err error) *Xfid {
 	}
 	t.Fid = x.fcall.Fid
 	t.Tag = x.fcall.Tag
+	if fs.inflight != nil {
+		fs.lk.Lock()
+		delete(fs.inflight, t.Tag)
+		hungup := fs.hungup
+		fs.lk.Unlock()
+		if hungup {
+			return x
+		}
+	}
 	if err := plan9.WriteFcall(fs.conn, t); err != nil {
+		if fs.inflight != nil {
+			// The client hung up; fsysproc cleans up.
+			return x
+		}
 		util.AcmeError("write error in respond", err)
 	}
 	if DEBUG {
@@ -267,7 +343,24 @@ func (fs *fileServer) version(x *Xfid, f *Fid) *Xfid {
 
 func (fs *fileServer) auth(x *Xfid, f *Fid) *Xfid {
 	var t plan9.Fcall
-	return fs.respond(x, &t, fmt.Errorf("acme: authentication not required"))
+	if fs.secret == nil {
+		return fs.respond(x, &t, fmt.Errorf("acme: authentication not required"))
+	}
+	af := fs.newfid(x.fcall.Afid)
+	if af.busy {
+		return fs.respond(x, &t, fmt.Errorf("fid already in use"))
+	}
+	challenge, err := newchallenge()
+	if err != nil {
+		return fs.respond(x, &t, err)
+	}
+	af.challenge = challenge
+	af.busy = true
+	af.auth = true
+	af.authok = false
+	af.qid = plan9.Qid{Type: plan9.QTAUTH}
+	t.Aqid = af.qid
+	return fs.respond(x, &t, nil)
 }
 
 func (fs *fileServer) flush(x *Xfid, f *Fid) *Xfid {
@@ -276,6 +369,12 @@ func (fs *fileServer) flush(x *Xfid, f *Fid) *Xfid {
 }
 
 func (fs *fileServer) attach(x *Xfid, f *Fid) *Xfid {
+	if fs.secret != nil {
+		af, ok := fs.fids[x.fcall.Afid]
+		if x.fcall.Afid == plan9.NOFID || !ok || !af.auth || !af.authok {
+			return fs.respond(x, nil, fmt.Errorf("authentication required"))
+		}
+	}
 	if x.fcall.Uname != fs.username {
 		// Ignore mismatch because some libraries gets it wrong
 		// anyway. 9fans.net/go/plan9/client just uses the
@@ -334,6 +433,7 @@ func (fs *fileServer) walk(x *Xfid, f *Fid) *Xfid {
 		nf.dir = f.dir
 		nf.qid = f.qid
 		nf.w = f.w
+		nf.col = f.col
 		nf.nrpart = 0 // not open, so must be zero
 		if nf.w != nil {
 			nf.w.lk.Lock()
@@ -388,6 +488,9 @@ func (fs *fileServer) walk(x *Xfid, f *Fid) *Xfid {
 		if wf.dir != nil {
 			f.dir = wf.dir
 		}
+		if wf.col != nil {
+			f.col = wf.col
+		}
 		f.qid = wf.qid
 	}
 
@@ -405,6 +508,10 @@ func (f *Fid) Walk1(wname string) (found bool, err error) {
 		return false, ErrNotDir
 	}
 
+	if wname == ".." && FILE(f.qid) == QCdir {
+		f.qid.Path = QID(0, Qcol)
+		return true, nil
+	}
 	if wname == ".." {
 		if f.w != nil {
 			f.w.Close()
@@ -416,6 +523,22 @@ func (f *Fid) Walk1(wname string) (found bool, err error) {
 		return true, nil
 	}
 
+	switch FILE(f.qid) {
+	case Qcol:
+		return f.walkcol(wname), nil
+	case QCdir:
+		for _, de := range dirtabc[1:] {
+			if wname == de.name {
+				f.dir = de
+				f.qid.Type = de.t
+				f.qid.Vers = 0
+				f.qid.Path = QID(WIN(f.qid), de.qid)
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
 	// is it a numeric name?
 	_, err = strconv.ParseInt(wname, 10, 32)
 	if err == nil {
@@ -450,8 +573,10 @@ func (f *Fid) Walk1(wname string) (found bool, err error) {
 		if f.w != nil {
 			util.AcmeError("w set in walk to new", nil)
 		}
+		fsysthreadlk.Lock()
 		global.cnewwindow <- nil  // signal newwindowthread
 		f.w = <-global.cnewwindow // receive new window
+		fsysthreadlk.Unlock()
 		f.w.ref.Inc()
 		f.dir = dirtabw[0]
 		f.qid.Type = plan9.QTDIR
@@ -474,9 +599,41 @@ func (f *Fid) Walk1(wname string) (found bool, err error) {
 			return true, nil
 		}
 	}
+	if id == 0 {
+		global.row.lk.Lock()
+		n, ok := snarfhistnum(wname)
+		global.row.lk.Unlock()
+		if ok {
+			f.dir = snarfDirTab(n)
+			f.qid.Type = plan9.QTFILE
+			f.qid.Vers = 0
+			f.qid.Path = QID(n, Qsnarfhist)
+			return true, nil
+		}
+	}
 	return false, nil // file not found
 }
 
+// walkcol walks f from the col directory to the directory of the column
+// numbered wname and returns true if there is one.
+func (f *Fid) walkcol(wname string) bool {
+	n, err := strconv.Atoi(wname)
+	if err != nil || n < 0 {
+		return false
+	}
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+	if n >= len(global.row.col) {
+		return false
+	}
+	f.col = global.row.col[n]
+	f.dir = dirtabc[0]
+	f.qid.Type = plan9.QTDIR
+	f.qid.Vers = 0
+	f.qid.Path = QID(n, QCdir)
+	return true
+}
+
 func (fs *fileServer) open(x *Xfid, f *Fid) *Xfid {
 	var m plan9.Perm
 	// can't truncate anything, so just disregard
@@ -527,16 +684,28 @@ func (fs *fileServer) read(x *Xfid, f *Fid) *Xfid {
 		if id > 0 {
 			d = dirtabw
 		}
+		var ncol int // for column sub-directories
+		switch FILE(f.qid) {
+		case Qcol:
+			d = d[:1]
+			global.row.lk.Lock()
+			ncol = len(global.row.col)
+			global.row.lk.Unlock()
+		case QCdir:
+			d = dirtabc
+		}
 		d = d[1:] // Skip '.'
 
-		var ids []int // for window sub-directories
-		if id == 0 {
+		var ids []int  // for window sub-directories
+		var nsnarf int // for snarf.<n> files
+		if FILE(f.qid) == Qdir && id == 0 {
 			global.row.lk.Lock()
 			for _, c := range global.row.col {
 				for _, w := range c.w {
 					ids = append(ids, w.id)
 				}
 			}
+			nsnarf = len(global.snarfhist)
 			global.row.lk.Unlock()
 			sort.Ints(ids)
 		}
@@ -551,6 +720,14 @@ func (fs *fileServer) read(x *Xfid, f *Fid) *Xfid {
 				k := ids[i]
 				return windowDirTab(k).Dir(k, fs.username, clock)
 			}
+			i -= len(ids)
+			if i < ncol {
+				return columnDirTab(i).Dir(i, fs.username, clock)
+			}
+			i -= ncol
+			if i < nsnarf {
+				return snarfDirTab(i+1).Dir(i+1, fs.username, clock)
+			}
 			return nil
 		})
 
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"9fans.net/go/plan9"
+)
+
+// Besides the posted service, Edwood can serve its file system directly
+// on a Unix socket or TCP address given with -L. Each connection gets
+// its own fileServer. A client that hangs up has its outstanding
+// requests flushed and its fids clunked, as 9pserve does.
+//
+// With -K, a client must authenticate before attaching, without sending
+// the secret held in the key file: it reads a random challenge from an
+// auth fid, writes back the hex HMAC-SHA256 of the challenge keyed with
+// the secret, and then attaches with that fid. A connection is hung up
+// after maxauthfails wrong responses. TCP addresses require a key.
+
+// maxauthfails is the number of wrong responses to an auth challenge
+// after which a connection is hung up.
+const maxauthfails = 3
+
+// newchallenge returns a random challenge for an auth fid.
+func newchallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// authresponse returns the response to challenge expected from a client
+// that knows secret.
+func authresponse(secret, challenge []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write(challenge)
+	return []byte(hex.EncodeToString(m.Sum(nil)))
+}
+
+// parseaddr9p returns the network and address of a listen address in
+// the form of a dial string: unix!/path or tcp!host!port. A bare path
+// is a Unix socket.
+func parseaddr9p(addr string) (network, address string, err error) {
+	f := strings.Split(addr, "!")
+	switch {
+	case len(f) == 1 && strings.Contains(addr, "/"):
+		return "unix", addr, nil
+	case len(f) == 2 && f[0] == "unix" && f[1] != "":
+		return "unix", f[1], nil
+	case len(f) == 3 && f[0] == "tcp" && f[2] != "":
+		return "tcp", net.JoinHostPort(f[1], f[2]), nil
+	}
+	return "", "", fmt.Errorf("bad listen address %q: want unix!path or tcp!host!port", addr)
+}
+
+// readsecret returns the secret held in keyfile, which must not be
+// readable by others.
+func readsecret(keyfile string) ([]byte, error) {
+	fi, err := os.Stat(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key file %s is accessible by others", keyfile)
+	}
+	b, err := os.ReadFile(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(b)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", keyfile)
+	}
+	return secret, nil
+}
+
+// listen9p serves the file system on addr (see parseaddr9p) to clients
+// that know the secret in keyfile, or to any client if keyfile is
+// empty.
+func listen9p(addr, keyfile string) error {
+	network, address, err := parseaddr9p(addr)
+	if err != nil {
+		return err
+	}
+	var secret []byte
+	if keyfile != "" {
+		if secret, err = readsecret(keyfile); err != nil {
+			return err
+		}
+	} else if network == "tcp" {
+		return fmt.Errorf("listening on %s requires a key file", addr)
+	}
+	if network == "unix" {
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			// Left behind by an earlier Edwood.
+			os.Remove(address)
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Printf("9P listener on %s failed: %v", addr, err)
+				return
+			}
+			fs := &fileServer{
+				conn:     conn,
+				fids:     make(map[uint32]*Fid),
+				username: getuser(),
+				inflight: make(map[uint16]bool),
+				secret:   secret,
+			}
+			fs.initfcall()
+			go fs.fsysproc()
+		}
+	}()
+	return nil
+}
+
+// hangup cleans up after the client of fs hung up: it flushes the
+// requests not yet responded to and clunks the fids in use.
+func (fs *fileServer) hangup() {
+	fs.lk.Lock()
+	fs.hungup = true
+	var tags []uint16
+	for tag := range fs.inflight {
+		tags = append(tags, tag)
+	}
+	fs.lk.Unlock()
+	fs.conn.Close()
+
+	for _, tag := range tags {
+		x := allocxfid()
+		x.fcall = plan9.Fcall{Type: plan9.Tflush, Tag: plan9.NOTAG, Oldtag: tag}
+		x.fs = fs
+		fs.flush(x, nil)
+	}
+	for _, f := range fs.fids {
+		if !f.busy || f.auth {
+			continue
+		}
+		x := allocxfid()
+		x.fcall = plan9.Fcall{Type: plan9.Tclunk, Tag: plan9.NOTAG, Fid: f.fid}
+		x.fs = fs
+		x.f = f
+		fs.clunk(x, f)
+	}
+}
+
+// authrpc responds to x, a request on the auth fid f. Reading f returns
+// its challenge; writing the response to it (see authresponse) allows
+// attaching with f.
+func (fs *fileServer) authrpc(x *Xfid, f *Fid) *Xfid {
+	var t plan9.Fcall
+	switch x.fcall.Type {
+	case plan9.Tread:
+		off := x.fcall.Offset
+		if off > uint64(len(f.challenge)) {
+			off = uint64(len(f.challenge))
+		}
+		t.Data = f.challenge[off:]
+		if len(t.Data) > int(x.fcall.Count) {
+			t.Data = t.Data[:x.fcall.Count]
+		}
+		return fs.respond(x, &t, nil)
+	case plan9.Twrite:
+		if fs.authfails >= maxauthfails {
+			return fs.respond(x, &t, fmt.Errorf("too many authentication failures"))
+		}
+		want := authresponse(fs.secret, f.challenge)
+		if subtle.ConstantTimeCompare(bytes.TrimSpace(x.fcall.Data), want) != 1 {
+			fs.authfails++
+			x = fs.respond(x, &t, fmt.Errorf("authentication failed"))
+			if fs.authfails >= maxauthfails {
+				log.Printf("hanging up after %d authentication failures", fs.authfails)
+				fs.conn.Close()
+			}
+			return x
+		}
+		f.authok = true
+		t.Count = uint32(len(x.fcall.Data))
+		return fs.respond(x, &t, nil)
+	case plan9.Tclunk:
+		f.busy = false
+		f.auth = false
+		f.authok = false
+		f.challenge = nil
+		return fs.respond(x, &t, nil)
+	}
+	return fs.respond(x, &t, fmt.Errorf("not allowed on an auth fid"))
+}
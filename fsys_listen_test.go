@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseaddr9p(t *testing.T) {
+	for _, tc := range []struct {
+		addr             string
+		network, address string
+		err              bool
+	}{
+		{"unix!/tmp/acme", "unix", "/tmp/acme", false},
+		{"/tmp/acme", "unix", "/tmp/acme", false},
+		{"tcp!localhost!5640", "tcp", "localhost:5640", false},
+		{"tcp!!5640", "tcp", ":5640", false},
+		{"tcp!localhost", "", "", true},
+		{"unix!", "", "", true},
+		{"udp!localhost!5640", "", "", true},
+		{"acme", "", "", true},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			network, address, err := parseaddr9p(tc.addr)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v; want error %v", err, tc.err)
+			}
+			if network != tc.network || address != tc.address {
+				t.Errorf("got %q, %q; want %q, %q", network, address, tc.network, tc.address)
+			}
+		})
+	}
+}
+
+func TestReadsecret(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key")
+	if err := os.WriteFile(key, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := readsecret(key)
+	if err != nil {
+		t.Fatalf("readsecret failed: %v", err)
+	}
+	if got, want := string(secret), "s3cret"; got != want {
+		t.Errorf("got secret %q; want %q", got, want)
+	}
+
+	if err := os.Chmod(key, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readsecret(key); err == nil {
+		t.Errorf("readsecret of a key file readable by others succeeded")
+	}
+}
+
+func TestFileServerAuthSecret(t *testing.T) {
+	mc := new(mockConn)
+	fs := &fileServer{
+		conn:     mc,
+		fids:     make(map[uint32]*Fid),
+		username: "gopher",
+		secret:   []byte("s3cret"),
+	}
+	const afid = 7
+
+	attach := func(afid uint32) *plan9.Fcall {
+		x := &Xfid{
+			fcall: plan9.Fcall{Type: plan9.Tattach, Afid: afid, Uname: fs.username},
+			f:     &Fid{},
+		}
+		fs.attach(x, x.f)
+		return mc.ReadFcall(t)
+	}
+	authrpc := func(typ uint8, data string) *plan9.Fcall {
+		x := &Xfid{fcall: plan9.Fcall{Type: typ, Fid: afid, Data: []byte(data)}}
+		fs.authrpc(x, fs.fids[afid])
+		return mc.ReadFcall(t)
+	}
+	required := errorFcall(fmt.Errorf("authentication required"))
+
+	if got := attach(plan9.NOFID); !cmp.Equal(got, required) {
+		t.Fatalf("attach without auth got %v; want %v", got, required)
+	}
+
+	fs.auth(&Xfid{fcall: plan9.Fcall{Type: plan9.Tauth, Afid: afid}}, nil)
+	want := &plan9.Fcall{Type: plan9.Rauth, Aqid: plan9.Qid{Type: plan9.QTAUTH}}
+	if got := mc.ReadFcall(t); !cmp.Equal(got, want) {
+		t.Fatalf("auth got %v; want %v", got, want)
+	}
+	if got := attach(afid); !cmp.Equal(got, required) {
+		t.Fatalf("attach before writing the secret got %v; want %v", got, required)
+	}
+
+	x := &Xfid{fcall: plan9.Fcall{Type: plan9.Tread, Fid: afid, Count: 8192}}
+	fs.authrpc(x, fs.fids[afid])
+	rc := mc.ReadFcall(t)
+	if len(rc.Data) == 0 {
+		t.Fatalf("read of the auth fid returned no challenge")
+	}
+	challenge := fs.fids[afid].challenge
+	if got, want := string(rc.Data), string(challenge); got != want {
+		t.Fatalf("read of the auth fid got %q; want %q", got, want)
+	}
+
+	failed := errorFcall(fmt.Errorf("authentication failed"))
+	if got := authrpc(plan9.Twrite, "s3cret"); !cmp.Equal(got, failed) {
+		t.Fatalf("write of the secret got %v; want %v", got, failed)
+	}
+	response := string(authresponse([]byte("s3cret"), challenge)) + "\n"
+	want = &plan9.Fcall{Type: plan9.Rwrite, Count: uint32(len(response))}
+	if got := authrpc(plan9.Twrite, response); !cmp.Equal(got, want) {
+		t.Fatalf("write of the response got %v; want %v", got, want)
+	}
+	notallowed := errorFcall(fmt.Errorf("not allowed on an auth fid"))
+	if got := authrpc(plan9.Topen, ""); !cmp.Equal(got, notallowed) {
+		t.Fatalf("open of the auth fid got %v; want %v", got, notallowed)
+	}
+
+	want = &plan9.Fcall{Type: plan9.Rattach, Qid: plan9.Qid{Path: Qdir, Type: plan9.QTDIR}}
+	if got := attach(afid); !cmp.Equal(got, want) {
+		t.Fatalf("attach got %v; want %v", got, want)
+	}
+}
+
+type closeConn struct {
+	mockConn
+	closed bool
+}
+
+func (cc *closeConn) Close() error {
+	cc.closed = true
+	return nil
+}
+
+func TestFileServerAuthFailures(t *testing.T) {
+	cc := new(closeConn)
+	fs := &fileServer{
+		conn:     cc,
+		fids:     make(map[uint32]*Fid),
+		username: "gopher",
+		secret:   []byte("s3cret"),
+	}
+	const afid = 7
+
+	fs.auth(&Xfid{fcall: plan9.Fcall{Type: plan9.Tauth, Afid: afid}}, nil)
+	cc.ReadFcall(t)
+	failed := errorFcall(fmt.Errorf("authentication failed"))
+	for i := 0; i < maxauthfails; i++ {
+		if cc.closed {
+			t.Fatalf("connection hung up after %d failures", i)
+		}
+		x := &Xfid{fcall: plan9.Fcall{Type: plan9.Twrite, Fid: afid, Data: []byte("guess")}}
+		fs.authrpc(x, fs.fids[afid])
+		if got := cc.ReadFcall(t); !cmp.Equal(got, failed) {
+			t.Fatalf("write of a wrong response got %v; want %v", got, failed)
+		}
+	}
+	if !cc.closed {
+		t.Fatalf("connection not hung up after %d failures", maxauthfails)
+	}
+
+	response := authresponse(fs.secret, fs.fids[afid].challenge)
+	x := &Xfid{fcall: plan9.Fcall{Type: plan9.Twrite, Fid: afid, Data: response}}
+	fs.authrpc(x, fs.fids[afid])
+	want := errorFcall(fmt.Errorf("too many authentication failures"))
+	if got := cc.ReadFcall(t); !cmp.Equal(got, want) {
+		t.Fatalf("write of the response got %v; want %v", got, want)
+	}
+	if fs.fids[afid].authok {
+		t.Errorf("auth fid accepted after %d failures", maxauthfails)
+	}
+}
@@ -1218,6 +1218,7 @@ func TestFileServerReadQacme(t *testing.T) {
 func TestFileServerRead(t *testing.T) {
 	useFixedClock = true
 	global.WinID = 0
+	global.snarfhist = nil
 	global.row.col = []*Column{
 		{
 			w: []*Window{
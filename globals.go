@@ -42,6 +42,7 @@ type globals struct {
 	activewin  *Window
 	activecol  *Column
 	snarfbuf   []byte
+	snarfhist  [][]byte // Most recent first; see recordsnarf.
 	home       string
 	acmeshell  string
 	tagcolors  [frame.NumColours]draw.Image
@@ -13,6 +13,7 @@ require (
 	github.com/pkg/term v1.1.0
 	github.com/sanity-io/litter v1.1.0
 	golang.org/x/sys v0.20.0
+	golang.org/x/text v0.16.0
 )
 
 require (
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+)
+
+// windowInfo describes a window in the index.json and info.json files.
+// These give tools the contents of the ctl and index files, and more,
+// without having to parse them.
+type windowInfo struct {
+	ID       int
+	Name     string
+	Column   int             // Index of the column holding the window, -1 if none.
+	Rect     image.Rectangle // Of the whole window, including the tag.
+	IsDir    bool
+	Dirty    bool
+	Seq      int
+	Q0, Q1   int // Selection in the body, in runes.
+	TagSize  int // In runes.
+	BodySize int // In runes.
+	Font     string
+	TabWidth int
+	Encoding string
+
+	DumpCommand string `json:",omitempty"` // Set by the dump ctl message.
+	DumpDir     string `json:",omitempty"` // Set by the dumpdir ctl message.
+
+	Tag string // All of it.
+}
+
+// makewindowinfo returns the windowInfo for w. Must be called with the
+// row and window locks held.
+func makewindowinfo(w *Window) windowInfo {
+	col := -1
+	for i, c := range global.row.col {
+		if c == w.col {
+			col = i
+		}
+	}
+	wi := windowInfo{
+		ID:          w.id,
+		Name:        w.body.file.Name(),
+		Column:      col,
+		Rect:        w.r,
+		IsDir:       w.body.file.IsDir(),
+		Dirty:       w.body.file.Dirty(),
+		Seq:         w.body.file.Seq(),
+		Q0:          w.body.q0,
+		Q1:          w.body.q1,
+		TagSize:     w.tag.Nc(),
+		BodySize:    w.body.Nc(),
+		TabWidth:    w.body.tabstop,
+		Encoding:    w.body.file.Encoding(),
+		DumpCommand: w.dumpstr,
+		DumpDir:     w.dumpdir,
+		Tag:         w.tag.file.String(),
+	}
+	if w.display != nil {
+		wi.Font = fontget(w.body.font, w.display).Name()
+	}
+	return wi
+}
+
+// indexjson returns the contents of the index.json file: a JSON array of
+// the windowInfo of every window.
+func indexjson() []byte {
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+
+	wis := []windowInfo{}
+	global.row.AllWindows(func(w *Window) {
+		wis = append(wis, makewindowinfo(w))
+	})
+	return marshalinfo(wis)
+}
+
+// infojson returns the contents of the info.json file of w: its
+// windowInfo. Must be called with the row and window locks held.
+func infojson(w *Window) []byte {
+	return marshalinfo(makewindowinfo(w))
+}
+
+func marshalinfo(v interface{}) []byte {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		// Not possible: windowInfo has no types that can't be marshalled.
+		panic(err)
+	}
+	return append(b, '\n')
+}
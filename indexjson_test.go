@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+// readsnapshot opens the file q of w (nil for the top-level files) and
+// reads all of it count bytes at a time, calling between after the
+// first read.
+func readsnapshot(t *testing.T, w *Window, q uint64, count uint32, between func()) []byte {
+	t.Helper()
+	if w != nil {
+		// Released by xfidclose, as after a walk.
+		w.ref.Inc()
+	}
+	mr := new(mockResponder)
+	f := &Fid{
+		qid: plan9.Qid{Path: QID(0, q)},
+		w:   w,
+	}
+	xfidopen(&Xfid{f: f, fs: mr})
+	if mr.err != nil {
+		t.Fatalf("open failed: %v", mr.err)
+	}
+
+	var b []byte
+	for {
+		xfidread(&Xfid{
+			f:     f,
+			fcall: plan9.Fcall{Offset: uint64(len(b)), Count: count},
+			fs:    mr,
+		})
+		if mr.err != nil {
+			t.Fatalf("read failed: %v", mr.err)
+		}
+		if mr.fcall.Count == 0 {
+			break
+		}
+		b = append(b, mr.fcall.Data...)
+		if between != nil {
+			between()
+			between = nil
+		}
+	}
+	xfidclose(&Xfid{f: f, fs: mr})
+	return b
+}
+
+func TestIndexJSON(t *testing.T) {
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("/a/b"),
+		ScBody("/a/b", "hello\n"),
+		ScBodyRange("/a/b", Range{1, 3}),
+		ScWin("/a/c"),
+	)
+	w := global.row.col[0].w[0]
+	w.dumpstr = "win"
+
+	// Windows created while reading don't corrupt the snapshot.
+	ws := global.row.col[0].w
+	b := readsnapshot(t, nil, Qindexjson, 16, func() {
+		global.row.col[0].w = append(ws[:len(ws):len(ws)], NewWindow().initHeadless(nil))
+	})
+	global.row.col[0].w = ws
+	var wis []windowInfo
+	if err := json.Unmarshal(b, &wis); err != nil {
+		t.Fatalf("bad JSON %q: %v", b, err)
+	}
+	if len(wis) != 2 {
+		t.Fatalf("got %d windows, want 2", len(wis))
+	}
+	got := wis[0]
+	if got.ID != w.id || got.Name != "/a/b" || got.Column != 0 || got.BodySize != 6 ||
+		got.Q0 != 1 || got.Q1 != 3 || got.DumpCommand != "win" || got.Tag != w.tag.file.String() {
+		t.Errorf("got %+v for window %d", got, w.id)
+	}
+	if wis[1].Name != "/a/c" {
+		t.Errorf("got %q for the second window, want %q", wis[1].Name, "/a/c")
+	}
+
+	b = readsnapshot(t, w, QWinfojson, 8192, nil)
+	var wi windowInfo
+	if err := json.Unmarshal(b, &wi); err != nil {
+		t.Fatalf("bad JSON %q: %v", b, err)
+	}
+	if wi != got {
+		t.Errorf("info.json is %+v; want %+v", wi, got)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rjkroege/edwood/file"
+)
+
+// A journal records the unsaved changes to the body of a window in a
+// file in *journaldir so that they can be recovered if Edwood crashes.
+// The file is a sequence of JSON-encoded journalRecords: a snapshot of
+// the body followed by the changes made to it since the snapshot.
+//
+// A journal observes every change made to the body but only writes to
+// its file every journalinterval. Once the body is clean, there is
+// nothing to recover and the file is removed.
+type journal struct {
+	oeb  *file.ObservableEditableBuffer
+	path string
+
+	started  bool            // path holds a snapshot
+	name     string          // name most recently written to path
+	format   diskformat      // format most recently written to path
+	pending  []journalRecord // changes not yet written to path
+	snapsize int             // size of the snapshot in path
+	size     int             // size of the changes written after the snapshot
+	failed   bool            // a write error has been reported
+}
+
+// journalRecord is a single entry in a journal file.
+type journalRecord struct {
+	Op   string // One of "snapshot", "name", "format", "insert" or "delete".
+	Name string `json:",omitempty"`
+	Seq  int    `json:",omitempty"`
+	Q0   int    `json:",omitempty"` // In runes.
+	Q1   int    `json:",omitempty"` // In runes.
+	Text string `json:",omitempty"`
+	diskformat
+}
+
+// A diskformat is how a body is written to its disk file.
+type diskformat struct {
+	Encoding string `json:",omitempty"` // "" for UTF-8.
+	CRLF     bool   `json:",omitempty"`
+	BOM      bool   `json:",omitempty"`
+}
+
+// formatof returns the format of the disk file of oeb.
+func formatof(oeb *file.ObservableEditableBuffer) diskformat {
+	f := diskformat{CRLF: oeb.CRLF(), BOM: oeb.BOM()}
+	if e := oeb.Encoding(); e != file.UTF8 {
+		f.Encoding = e
+	}
+	return f
+}
+
+// set makes f the format of the disk file of oeb.
+func (f diskformat) set(oeb *file.ObservableEditableBuffer) error {
+	if f.Encoding != "" {
+		if err := oeb.SetEncoding(f.Encoding); err != nil {
+			return err
+		}
+	}
+	oeb.SetCRLF(f.CRLF)
+	oeb.SetBOM(f.BOM)
+	return nil
+}
+
+const (
+	journalinterval = 5 * time.Second
+	// Rewrite the journal with a new snapshot when the changes written
+	// after the snapshot exceed its size by this much.
+	journalslack = 1 << 20
+)
+
+// journals holds the journal of each body being journalled. Only
+// accessed with the row lock held.
+var journals = make(map[*file.ObservableEditableBuffer]*journal)
+
+var _ file.BufferObserver = (*journal)(nil) // Enforce at compile time that journal implements BufferObserver
+
+func newjournal(oeb *file.ObservableEditableBuffer, path string) *journal {
+	j := &journal{
+		oeb:  oeb,
+		path: path,
+	}
+	oeb.AddRecorder(j)
+	return j
+}
+
+// openjournal starts journalling the body of w if journalling is
+// enabled.
+func openjournal(w *Window) {
+	if *journaldir == "" {
+		return
+	}
+	journals[w.body.file] = newjournal(w.body.file, journalpath(w.id))
+}
+
+// journalpath returns the path of the journal file for the window with
+// the given id.
+func journalpath(id int) string {
+	return filepath.Join(*journaldir, fmt.Sprintf("%d.%d", os.Getpid(), id))
+}
+
+// closejournal stops journalling oeb and removes its journal file.
+func closejournal(oeb *file.ObservableEditableBuffer) {
+	j, ok := journals[oeb]
+	if !ok {
+		return
+	}
+	oeb.DelRecorder(j)
+	j.remove()
+	delete(journals, oeb)
+}
+
+// Inserted implements file.BufferObserver.
+func (j *journal) Inserted(q0 file.OffsetTuple, b []byte, nr int) {
+	if !j.started {
+		// The next snapshot will include this change.
+		return
+	}
+	j.pending = append(j.pending, journalRecord{
+		Op:   "insert",
+		Seq:  j.oeb.Seq(),
+		Q0:   q0.R,
+		Text: string(b),
+	})
+}
+
+// Deleted implements file.BufferObserver.
+func (j *journal) Deleted(q0, q1 file.OffsetTuple) {
+	if !j.started {
+		return
+	}
+	j.pending = append(j.pending, journalRecord{
+		Op:  "delete",
+		Seq: j.oeb.Seq(),
+		Q0:  q0.R,
+		Q1:  q1.R,
+	})
+}
+
+// flush brings the journal file up to date.
+func (j *journal) flush() error {
+	if !j.oeb.Dirty() || j.oeb.IsDirOrScratch() {
+		j.remove()
+		return nil
+	}
+	if !j.started || j.size > j.snapsize+journalslack {
+		return j.snapshot()
+	}
+	if name := j.oeb.Name(); name != j.name {
+		j.pending = append(j.pending, journalRecord{Op: "name", Name: name})
+		j.name = name
+	}
+	if f := formatof(j.oeb); f != j.format {
+		j.pending = append(j.pending, journalRecord{Op: "format", diskformat: f})
+		j.format = f
+	}
+	if len(j.pending) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range j.pending {
+		if err := enc.Encode(&j.pending[i]); err != nil {
+			return err
+		}
+	}
+	j.pending = j.pending[:0]
+
+	fd, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		j.started = false
+		return err
+	}
+	defer fd.Close()
+	if _, err := fd.Write(buf.Bytes()); err != nil {
+		j.started = false
+		return err
+	}
+	j.size += buf.Len()
+	return nil
+}
+
+// snapshot replaces the journal file with one holding only the current
+// contents of the body.
+func (j *journal) snapshot() error {
+	j.started = false
+	j.pending = j.pending[:0]
+	if err := os.MkdirAll(*journaldir, 0700); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	r := journalRecord{
+		Op:         "snapshot",
+		Name:       j.oeb.Name(),
+		Seq:        j.oeb.Seq(),
+		Text:       j.oeb.String(),
+		diskformat: formatof(j.oeb),
+	}
+	if err := json.NewEncoder(&buf).Encode(&r); err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.started = true
+	j.name = r.Name
+	j.format = r.diskformat
+	j.snapsize = buf.Len()
+	j.size = 0
+	return nil
+}
+
+// remove removes the journal file.
+func (j *journal) remove() {
+	if j.started {
+		os.Remove(j.path)
+	}
+	j.started = false
+	j.pending = j.pending[:0]
+}
+
+// window returns a window whose body is that of j or nil if there is
+// none.
+func (j *journal) window() *Window {
+	var w *Window
+	j.oeb.AllObservers(func(i interface{}) {
+		if t, ok := i.(*Text); ok && t.w != nil && w == nil {
+			w = t.w
+		}
+	})
+	return w
+}
+
+// flushjournals brings every journal file up to date. Must be called
+// with the row lock held. The body of a window is changed with the
+// window locked, so each journal is flushed with the lock of its
+// window, and of the window's clones, held.
+func flushjournals() {
+	for _, j := range journals {
+		w := j.window()
+		if w != nil {
+			w.Lock('J')
+		}
+		err := j.flush()
+		if w != nil {
+			w.Unlock()
+		}
+		if err != nil && !j.failed {
+			j.failed = true
+			warning(nil, "can't write journal for %q: %v\n", j.oeb.Name(), err)
+		}
+	}
+}
+
+// removejournals removes every journal file. Invoked on a normal exit.
+func removejournals() {
+	for _, j := range journals {
+		j.remove()
+	}
+}
+
+// journalthread periodically flushes the journals.
+func journalthread(g *globals) {
+	for range time.Tick(journalinterval) {
+		g.row.lk.Lock()
+		flushjournals()
+		g.row.lk.Unlock()
+	}
+}
+
+var errBadJournal = errors.New("journal doesn't start with a snapshot")
+
+// readjournal returns the name, contents and disk file format recorded
+// in the journal file path. A journal that is truncated (e.g. because
+// Edwood crashed while writing it) is read up to the last complete
+// change.
+func readjournal(path string) (name string, contents string, format diskformat, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", "", format, err
+	}
+	defer fd.Close()
+
+	dec := json.NewDecoder(fd)
+	var r journalRecord
+	if err := dec.Decode(&r); err != nil || r.Op != "snapshot" {
+		return "", "", format, errBadJournal
+	}
+	name = r.Name
+	format = r.diskformat
+	oeb := file.MakeObservableEditableBuffer(name, []rune(r.Text))
+
+	for {
+		var r journalRecord
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			// Truncated by a crash.
+			break
+		}
+		switch r.Op {
+		case "name":
+			name = r.Name
+		case "format":
+			format = r.diskformat
+		case "insert":
+			if r.Q0 < 0 || r.Q0 > oeb.Nr() {
+				return "", "", format, fmt.Errorf("insert at %d out of range", r.Q0)
+			}
+			oeb.InsertAt(r.Q0, []rune(r.Text))
+		case "delete":
+			if r.Q0 < 0 || r.Q0 > r.Q1 || r.Q1 > oeb.Nr() {
+				return "", "", format, fmt.Errorf("delete of %d,%d out of range", r.Q0, r.Q1)
+			}
+			oeb.DeleteAt(r.Q0, r.Q1)
+		default:
+			return "", "", format, fmt.Errorf("unknown journal record %q", r.Op)
+		}
+	}
+	return name, oeb.String(), format, nil
+}
+
+// recoverablejournals returns the paths of the journal files in
+// *journaldir left behind by earlier instances of Edwood. The journals
+// of instances that are still running, this one included, are skipped.
+func recoverablejournals() []string {
+	if *journaldir == "" {
+		return nil
+	}
+	des, err := os.ReadDir(*journaldir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, de := range des {
+		n := de.Name()
+		if de.IsDir() || strings.HasSuffix(n, ".tmp") {
+			continue
+		}
+		p, _, _ := strings.Cut(n, ".")
+		pid, err := strconv.Atoi(p)
+		if err == nil && (pid == os.Getpid() || processalive(pid)) {
+			continue
+		}
+		paths = append(paths, filepath.Join(*journaldir, n))
+	}
+	return paths
+}
+
+// offerrecovery tells the user about the unsaved changes that can be
+// recovered with the Recover command.
+func offerrecovery() {
+	for _, path := range recoverablejournals() {
+		name, _, _, err := readjournal(path)
+		if err != nil {
+			continue
+		}
+		if name == "" {
+			name = "(unnamed window)"
+		}
+		warning(nil, "unsaved changes to %s can be restored with Recover or discarded with Recover -discard\n", name)
+	}
+}
+
+// recoverx implements the Recover command: it restores the unsaved
+// changes recorded in journals left behind by earlier instances of
+// Edwood into new dirty windows, which are written in the format
+// (encoding, line endings and byte order mark) of the files they were
+// read from. With a -discard argument, the journals are removed instead.
+func recoverx(et *Text, _ *Text, _ *Text, _, _ bool, arg string) {
+	discard := false
+	switch arg = strings.TrimSpace(arg); arg {
+	case "":
+	case "-discard":
+		discard = true
+	default:
+		warning(nil, "Recover: unknown argument %q\n", arg)
+		return
+	}
+
+	for _, path := range recoverablejournals() {
+		if discard {
+			os.Remove(path)
+			continue
+		}
+		name, contents, format, err := readjournal(path)
+		if err != nil {
+			warning(nil, "Recover: can't read %s: %v\n", path, err)
+			continue
+		}
+		w := makenewwindow(et)
+		w.SetName(name)
+		w.body.LoadReader(0, name, strings.NewReader(contents), false)
+		if err := format.set(w.body.file); err != nil {
+			warning(nil, "Recover: %s: %v\n", name, err)
+		}
+		w.body.file.Modded()
+		w.body.Show(0, 0, true)
+		xfidlog(w, "new")
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+// processalive returns false: there is no portable way to tell if
+// another Edwood is still running, so only the journals of this one are
+// left alone.
+func processalive(pid int) bool {
+	return false
+}
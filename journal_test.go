@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/edwoodtest"
+	"github.com/rjkroege/edwood/file"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { *journaldir = old }(*journaldir)
+	*journaldir = dir
+
+	path := filepath.Join(dir, "1.1")
+	oeb := file.MakeObservableEditableBuffer("/a/b", []rune("hello"))
+	j := newjournal(oeb, path)
+
+	// Nothing is written for a clean body.
+	if err := j.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("journal written for a clean body: %v", err)
+	}
+
+	check := func(step, wantname string) {
+		t.Helper()
+		if err := j.flush(); err != nil {
+			t.Fatalf("%s: flush failed: %v", step, err)
+		}
+		name, contents, format, err := readjournal(path)
+		if err != nil {
+			t.Fatalf("%s: readjournal failed: %v", step, err)
+		}
+		if name != wantname || contents != oeb.String() {
+			t.Errorf("%s: got %q %q, want %q %q", step, name, contents, wantname, oeb.String())
+		}
+		if want := formatof(oeb); format != want {
+			t.Errorf("%s: got format %+v, want %+v", step, format, want)
+		}
+	}
+
+	oeb.Mark(1)
+	oeb.InsertAt(5, []rune(" world"))
+	check("snapshot", "/a/b")
+
+	oeb.Mark(2)
+	oeb.DeleteAt(0, 1)
+	oeb.InsertAt(0, []rune("Hαllo"))
+	oeb.SetName("/a/c")
+	check("changes", "/a/c")
+
+	// Undo also reverts the renaming.
+	oeb.Undo(true)
+	check("undo", "/a/b")
+
+	if err := oeb.SetEncoding("latin-1"); err != nil {
+		t.Fatal(err)
+	}
+	oeb.SetCRLF(true)
+	oeb.SetBOM(true)
+	check("format", "/a/b")
+
+	// A crash while writing leaves a partial record behind.
+	oeb.Mark(3)
+	oeb.InsertAt(0, []rune(">"))
+	check("before truncation", "/a/b")
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("can't open journal: %v", err)
+	}
+	fd.WriteString(`{"Op":"insert","Q0":`)
+	fd.Close()
+	if _, contents, _, err := readjournal(path); err != nil || contents != oeb.String() {
+		t.Errorf("truncated journal: got %q %v, want %q", contents, err, oeb.String())
+	}
+
+	// Once clean, there is nothing to recover.
+	oeb.Clean()
+	if err := j.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("journal not removed for a clean body: %v", err)
+	}
+}
+
+// TestJournalFlushWhileWriting checks, when run with -race, that
+// flushing the journals doesn't race with writes to the body.
+func TestJournalFlushWhileWriting(t *testing.T) {
+	defer func(old string) { *journaldir = old }(*journaldir)
+	*journaldir = t.TempDir()
+
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.configureGlobals(display)
+	w := NewWindow().initHeadless(nil)
+	w.col = new(Column)
+	w.col.safe = true
+	w.display = display
+	w.body.fr = &MockFrame{}
+	w.body.display = display
+	w.tag.fr = &MockFrame{}
+	w.tag.display = display
+	defer closejournal(w.body.file)
+
+	const n = 100
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mr := new(mockResponder)
+		data := []byte("hello\n")
+		for i := 0; i < n; i++ {
+			xfidwrite(&Xfid{
+				fcall: plan9.Fcall{
+					Data:  data,
+					Count: uint32(len(data)),
+				},
+				f: &Fid{
+					qid: plan9.Qid{Path: QID(w.id, QWbody)},
+					w:   w,
+				},
+				fs: mr,
+			})
+		}
+	}()
+	for i := 0; i < n; i++ {
+		global.row.lk.Lock()
+		flushjournals()
+		global.row.lk.Unlock()
+	}
+	<-done
+
+	global.row.lk.Lock()
+	flushjournals()
+	global.row.lk.Unlock()
+	_, contents, _, err := readjournal(journalpath(w.id))
+	if err != nil {
+		t.Fatalf("readjournal failed: %v", err)
+	}
+	if got, want := contents, w.body.file.String(); got != want {
+		t.Errorf("journal has %d bytes, want the body's %d", len(got), len(want))
+	}
+}
+
+func TestRecoverableJournals(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { *journaldir = old }(*journaldir)
+	*journaldir = dir
+
+	// No process has a pid this large. The parent of the test is running.
+	dead := fmt.Sprintf("%d", 1<<30)
+	alive := fmt.Sprintf("%d", os.Getppid())
+	for _, n := range []string{dead + ".1", dead + ".2.tmp", alive + ".1", "x.1"} {
+		if err := os.WriteFile(filepath.Join(dir, n), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oeb := file.MakeObservableEditableBuffer("", nil)
+	oeb.Mark(1)
+	oeb.InsertAt(0, []rune("x"))
+	mine := newjournal(oeb, journalpath(1))
+	if err := mine.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	got := recoverablejournals()
+	want := []string{filepath.Join(dir, dead+".1"), filepath.Join(dir, "x.1")}
+	if !processalive(os.Getppid()) {
+		// There is no telling on this system.
+		want = append(want, filepath.Join(dir, alive+".1"))
+		sort.Strings(want)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("recoverablejournals: got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("recoverablejournals: got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { *journaldir = old }(*journaldir)
+	*journaldir = dir
+
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("x"),
+		ScBody("x", "x\n"),
+	)
+	w := global.row.col[0].w[0]
+	global.activecol = w.col
+
+	oeb := file.MakeObservableEditableBuffer("/a/b", []rune("hello\n"))
+	oeb.SetEncoding("utf-16le")
+	oeb.SetCRLF(true)
+	oeb.Mark(1)
+	oeb.InsertAt(0, []rune("ω "))
+	// A journal left behind by a process that isn't running.
+	if err := newjournal(oeb, filepath.Join(dir, fmt.Sprintf("%d.1", 1<<30))).flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	recoverx(&w.body, nil, nil, false, false, "")
+	rw := lookfile("/a/b")
+	if rw == nil {
+		t.Fatalf("no window recovered")
+	}
+	f := rw.body.file
+	if got, want := f.String(), "ω hello\n"; got != want || !f.Dirty() {
+		t.Errorf("recovered %q, dirty %v; want %q, dirty", got, f.Dirty(), want)
+	}
+	if got, want := formatof(f), formatof(oeb); got != want {
+		t.Errorf("recovered format %+v; want %+v", got, want)
+	}
+}
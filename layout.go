@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/ninep"
+)
+
+// The col directory holds a directory for each column, named by its
+// position counting from 0 as the Column of a windowInfo does. Reading
+// the ctl file of a column gives its number, its number of windows and
+// its rectangle. The tag file holds the column tag and the index file is
+// the index file restricted to the windows of the column.
+//
+// The ctl file of the row reads as the ctl files of all the columns and
+// takes these messages, one per line:
+//
+//	newcol			add an empty column on the right
+//	delcol c		delete column c, unless a window in it is dirty or in use
+//	move w c pos		move window w to column c, as its window pos counting from 0
+//	grow w [1|2|3]		grow window w as mouse button 1, 2 or 3 on its tag button does
+//	sort c			sort the windows of column c by name
+//	resize c fraction	make column c take the fraction of the width of the row
+//
+// The ctl file of a column takes the same messages, except newcol,
+// with the column left out.
+
+// ErrDeletedCol is the error for an access to the files of a column that
+// has been deleted.
+var ErrDeletedCol = fmt.Errorf("deleted column")
+
+// colnum returns the number of column c, or -1 if c has been deleted. Must
+// be called with the row lock held.
+func colnum(c *Column) int {
+	for i, d := range global.row.col {
+		if d == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// colctlprint returns the contents of the ctl file of column c, which is
+// column n.
+func colctlprint(n int, c *Column) string {
+	return fmt.Sprintf("%11d %11d %11d %11d %11d %11d \n", n, len(c.w),
+		c.r.Min.X, c.r.Min.Y, c.r.Max.X, c.r.Max.Y)
+}
+
+// xfidlayoutread responds to x, a read of the ctl file of the row or a
+// file of a column.
+func xfidlayoutread(x *Xfid) {
+	var fc plan9.Fcall
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+
+	q := FILE(x.f.qid)
+	if q == Qctl {
+		var sb strings.Builder
+		for i, c := range global.row.col {
+			sb.WriteString(colctlprint(i, c))
+		}
+		ninep.ReadString(&fc, &x.fcall, sb.String())
+		x.respond(&fc, nil)
+		return
+	}
+	c := x.f.col
+	n := colnum(c)
+	if n < 0 {
+		x.respond(&fc, ErrDeletedCol)
+		return
+	}
+	switch q {
+	case QCctl:
+		ninep.ReadString(&fc, &x.fcall, colctlprint(n, c))
+	case QCindex:
+		var sb strings.Builder
+		writeindex(&sb, c)
+		ninep.ReadString(&fc, &x.fcall, sb.String())
+	case QCtag:
+		ninep.ReadString(&fc, &x.fcall, c.tag.file.String())
+	}
+	x.respond(&fc, nil)
+}
+
+// xfidlayoutwrite responds to x, a write to the ctl file of the row or
+// to the ctl or tag file of a column.
+func xfidlayoutwrite(x *Xfid) {
+	var fc plan9.Fcall
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+
+	var c *Column
+	if q := FILE(x.f.qid); q != Qctl {
+		c = x.f.col
+		if colnum(c) < 0 {
+			x.respond(&fc, ErrDeletedCol)
+			return
+		}
+		if q == QCtag {
+			if r := fullrunewrite(x); len(r) > 0 {
+				c.tag.Commit()
+				c.tag.Insert(c.tag.Nc(), r, true)
+			}
+			fc.Count = x.fcall.Count
+			x.respond(&fc, nil)
+			return
+		}
+	}
+	for _, line := range strings.Split(string(x.fcall.Data), "\n") {
+		if err := layoutctl(c, line); err != nil {
+			x.respond(&fc, err)
+			return
+		}
+	}
+	fc.Count = x.fcall.Count
+	x.respond(&fc, nil)
+}
+
+// layoutctl performs the layout ctl message line, written to the ctl
+// file of column c or, if c is nil, of the row. Must be called with the
+// row lock held.
+func layoutctl(c *Column, line string) error {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return nil
+	}
+	args := words[1:]
+	next := func() (int, error) {
+		if len(args) == 0 {
+			return 0, ErrBadCtl
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return 0, ErrBadCtl
+		}
+		args = args[1:]
+		return n, nil
+	}
+	col := func() (*Column, error) {
+		if c != nil {
+			return c, nil
+		}
+		n, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n >= len(global.row.col) {
+			return nil, fmt.Errorf("no column %d", n)
+		}
+		return global.row.col[n], nil
+	}
+	win := func() (*Window, error) {
+		id, err := next()
+		if err != nil {
+			return nil, err
+		}
+		w := global.row.LookupWin(id)
+		if w == nil {
+			return nil, fmt.Errorf("no window %d", id)
+		}
+		return w, nil
+	}
+
+	var err error
+	switch words[0] {
+	case "newcol":
+		if c != nil || len(args) > 0 {
+			return ErrBadCtl
+		}
+		if global.row.Add(nil, -1) == nil {
+			return fmt.Errorf("no room for a new column")
+		}
+		return nil
+
+	case "delcol":
+		var d *Column
+		if d, err = col(); err != nil {
+			break
+		}
+		if len(args) > 0 {
+			return ErrBadCtl
+		}
+		return deletecol(d)
+
+	case "move":
+		var w *Window
+		var d *Column
+		var pos int
+		if w, err = win(); err != nil {
+			break
+		}
+		if d, err = col(); err != nil {
+			break
+		}
+		if pos, err = next(); err != nil {
+			break
+		}
+		if pos < 0 || len(args) > 0 {
+			return ErrBadCtl
+		}
+		w.Lock('F')
+		defer w.Unlock()
+		if w.col == nil {
+			return ErrDeletedWin
+		}
+		w.col.Close(w, false)
+		placewin(d, w, pos)
+		return nil
+
+	case "grow":
+		var w *Window
+		if w, err = win(); err != nil {
+			break
+		}
+		but := 1
+		if len(args) > 0 {
+			if but, err = next(); err != nil {
+				break
+			}
+		}
+		if but < 1 || but > 3 || len(args) > 0 {
+			return ErrBadCtl
+		}
+		w.Lock('F')
+		defer w.Unlock()
+		if w.col == nil {
+			return ErrDeletedWin
+		}
+		if c != nil && w.col != c {
+			return fmt.Errorf("window %d is not in the column", w.id)
+		}
+		w.col.Grow(w, but)
+		return nil
+
+	case "sort":
+		var d *Column
+		if d, err = col(); err != nil {
+			break
+		}
+		if len(args) > 0 {
+			return ErrBadCtl
+		}
+		d.Sort()
+		return nil
+
+	case "resize":
+		var d *Column
+		if d, err = col(); err != nil {
+			break
+		}
+		if len(args) != 1 {
+			return ErrBadCtl
+		}
+		f, perr := strconv.ParseFloat(args[0], 64)
+		if perr != nil || f <= 0 || f > 1 {
+			return ErrBadCtl
+		}
+		return resizecol(d, f)
+
+	default:
+		return ErrBadCtl
+	}
+	return err
+}
+
+// deletecol deletes column c, as the Delcol command does, unless one of
+// its windows is dirty or in use by another program.
+func deletecol(c *Column) error {
+	for _, w := range c.w {
+		f := w.body.file
+		if !f.IsDirOrScratch() && f.Dirty() {
+			return fmt.Errorf("%s modified", f.Name())
+		}
+		if w.external() || w.nopen[QWaddr]+w.nopen[QWdata]+w.nopen[QWxdata] > 0 {
+			return fmt.Errorf("%s is running an external command", f.Name())
+		}
+	}
+	global.row.Close(c, true)
+	return nil
+}
+
+// placewin adds w to column c as its window pos, counting from 0, or as
+// its last window if there are fewer.
+func placewin(c *Column, w *Window, pos int) {
+	if pos >= len(c.w) {
+		c.Add(w, nil, -1)
+		return
+	}
+	// Column.Add places w below the window it lands on.
+	v := c.w[0]
+	if pos > 0 {
+		v = c.w[pos-1]
+	}
+	c.Add(w, nil, v.r.Min.Y+v.r.Dy()/2)
+	if pos == 0 {
+		c.w[0], c.w[1] = c.w[1], c.w[0]
+		c.restack()
+	}
+}
+
+// resizecol makes column c take fraction f of the width of the row, or
+// as close to it as leaves its neighbour usable, by moving its left
+// edge or, for the first column, the left edge of the next column.
+func resizecol(c *Column, f float64) error {
+	row := &global.row
+	i := colnum(c)
+	width := int(f * float64(row.r.Dx()))
+	switch {
+	case i > 0:
+		row.movecolx(i, c.r.Max.X-width)
+	case len(row.col) > 1:
+		row.movecolx(1, c.r.Min.X+width)
+	default:
+		return fmt.Errorf("can't resize the only column")
+	}
+	return nil
+}
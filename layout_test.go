@@ -0,0 +1,211 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"9fans.net/go/plan9"
+)
+
+// loadLayoutForTesting loads the example dump file: two columns holding
+// windows 1-3 and 4-6.
+func loadLayoutForTesting(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping on windows")
+	}
+	filename := editDumpFileForTesting(t, "testdata/example.dump")
+	defer os.Remove(filename)
+	setGlobalsForLoadTesting()
+	if err := global.row.Load(nil, filename, true); err != nil {
+		t.Fatalf("Row.Load failed: %v", err)
+	}
+}
+
+func colids(c *Column) []int {
+	var ids []int
+	for _, w := range c.w {
+		ids = append(ids, w.id)
+	}
+	return ids
+}
+
+func TestLayoutCtl(t *testing.T) {
+	loadLayoutForTesting(t)
+	defer func() { global.row = Row{} }()
+	row := &global.row
+
+	check := func(c *Column, line string) {
+		t.Helper()
+		if err := layoutctl(c, line); err != nil {
+			t.Fatalf("%q failed: %v", line, err)
+		}
+	}
+	checkids := func(c *Column, want ...int) {
+		t.Helper()
+		got := colids(c)
+		if len(got) != len(want) {
+			t.Fatalf("got windows %v; want %v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("got windows %v; want %v", got, want)
+			}
+		}
+		for i := 1; i < len(c.w); i++ {
+			if c.w[i].r.Min.Y < c.w[i-1].r.Max.Y {
+				t.Fatalf("window %d overlaps window %d", c.w[i].id, c.w[i-1].id)
+			}
+		}
+	}
+	checkids(row.col[0], 1, 2, 3)
+	checkids(row.col[1], 4, 5, 6)
+
+	check(nil, "newcol")
+	if got, want := len(row.col), 3; got != want {
+		t.Fatalf("got %d columns; want %d", got, want)
+	}
+	check(nil, "move 4 2 0")
+	check(nil, "move 1 2 0")
+	checkids(row.col[0], 2, 3)
+	checkids(row.col[1], 5, 6)
+	checkids(row.col[2], 1, 4)
+	check(row.col[2], "move 5 1")
+	checkids(row.col[1], 6)
+	checkids(row.col[2], 1, 5, 4)
+	check(nil, "move 6 2 9")
+	checkids(row.col[1])
+	checkids(row.col[2], 1, 5, 4, 6)
+	if w := row.LookupWin(6); w.col != row.col[2] || w.body.col != row.col[2] {
+		t.Fatalf("moved window not in its new column")
+	}
+
+	check(row.col[2], "grow 5 2")
+	check(nil, "sort 2")
+	check(nil, "resize 0 0.25")
+	if got, want := row.col[0].r.Dx(), row.r.Dx()/4; got < want-2 || got > want+2 {
+		t.Errorf("got column width %d after resize; want about %d", got, want)
+	}
+	check(row.col[2], "resize 0.5")
+	if got, want := row.col[2].r.Dx(), row.r.Dx()/2; got < want-2 || got > want+2 {
+		t.Errorf("got column width %d after resize; want about %d", got, want)
+	}
+
+	for _, tc := range []struct {
+		c    *Column
+		line string
+		want string
+	}{
+		{nil, "bogus", ErrBadCtl.Error()},
+		{nil, "move 1 1", ErrBadCtl.Error()},
+		{nil, "move 1 9 0", "no column 9"},
+		{nil, "move 99 0 0", "no window 99"},
+		{nil, "resize 0 2", ErrBadCtl.Error()},
+		{nil, "delcol", ErrBadCtl.Error()},
+		{row.col[0], "newcol", ErrBadCtl.Error()},
+		{row.col[0], "grow 1", "window 1 is not in the column"},
+	} {
+		err := layoutctl(tc.c, tc.line)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q got error %v; want %v", tc.line, err, tc.want)
+		}
+	}
+}
+
+func TestDeletecolDirty(t *testing.T) {
+	loadLayoutForTesting(t)
+	defer func() { global.row = Row{} }()
+
+	w := global.row.LookupWin(2)
+	w.body.file.Modded()
+	if err := layoutctl(nil, "delcol 0"); err == nil {
+		t.Fatalf("deleted column with a dirty window")
+	}
+	if got, want := len(global.row.col), 2; got != want {
+		t.Fatalf("got %d columns; want %d", got, want)
+	}
+}
+
+func TestWalkColumn(t *testing.T) {
+	loadLayoutForTesting(t)
+	defer func() { global.row = Row{} }()
+
+	f := &Fid{qid: plan9.Qid{Type: plan9.QTDIR, Path: QID(0, Qdir)}}
+	for _, name := range []string{"col", "1", "ctl"} {
+		found, err := f.Walk1(name)
+		if !found || err != nil {
+			t.Fatalf("walk to %q got %v, %v", name, found, err)
+		}
+	}
+	if f.col != global.row.col[1] || FILE(f.qid) != QCctl || WIN(f.qid) != 1 {
+		t.Fatalf("walk to col/1/ctl got column %p and qid %v", f.col, f.qid)
+	}
+
+	f = &Fid{qid: plan9.Qid{Type: plan9.QTDIR, Path: QID(0, Qcol)}}
+	if found, _ := f.Walk1("2"); found {
+		t.Errorf("walk to a column that doesn't exist succeeded")
+	}
+	if found, _ := f.Walk1("cons"); found {
+		t.Errorf("walk to a root file from col succeeded")
+	}
+}
+
+func TestXfidLayoutFiles(t *testing.T) {
+	loadLayoutForTesting(t)
+	defer func() { global.row = Row{} }()
+	c := global.row.col[1]
+
+	rpc := func(write bool, q uint64, data string) string {
+		t.Helper()
+		mr := new(mockResponder)
+		x := &Xfid{
+			f:     &Fid{qid: plan9.Qid{Path: QID(1, q)}, col: c},
+			fcall: plan9.Fcall{Count: 8192, Data: []byte(data)},
+			fs:    mr,
+		}
+		if write {
+			xfidwrite(x)
+		} else {
+			xfidread(x)
+		}
+		if mr.err != nil {
+			t.Fatalf("got error %v; want nil", mr.err)
+		}
+		return string(mr.fcall.Data)
+	}
+
+	want := colctlprint(0, global.row.col[0]) + colctlprint(1, c)
+	if got := rpc(false, Qctl, ""); got != want {
+		t.Errorf("row ctl got %q; want %q", got, want)
+	}
+	if got, want := rpc(false, QCctl, ""), colctlprint(1, c); got != want {
+		t.Errorf("column ctl got %q; want %q", got, want)
+	}
+	rpc(true, QCtag, "Hello")
+	if got, want := rpc(false, QCtag, ""), string(Lheader)+"Hello"; got != want {
+		t.Errorf("column tag got %q; want %q", got, want)
+	}
+	var sb strings.Builder
+	writeindex(&sb, c)
+	if got, want := rpc(false, QCindex, ""), sb.String(); got != want || strings.Count(got, "\n") != 3 {
+		t.Errorf("column index got %q; want %q", got, want)
+	}
+
+	rpc(true, Qctl, "newcol\nmove 4 2 0\n")
+	if got, want := len(global.row.col), 3; got != want {
+		t.Fatalf("got %d columns; want %d", got, want)
+	}
+	rpc(true, QCctl, "move 4 0")
+	if got := colids(c); len(got) != 3 || got[0] != 4 {
+		t.Errorf("got windows %v in column; want 4 first", got)
+	}
+
+	mr := new(mockResponder)
+	global.row.Close(c, false)
+	xfidread(&Xfid{f: &Fid{qid: plan9.Qid{Path: QID(1, QCctl)}, col: c}, fs: mr})
+	if mr.err != ErrDeletedCol {
+		t.Errorf("read of deleted column got error %v; want %v", mr.err, ErrDeletedCol)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rjkroege/edwood/util"
+)
+
+// The lines file of a window gives access to the body by line number
+// in one round trip. The offset of a read is a line number, counting
+// from 0 for the first line, and the read returns as many whole lines
+// from there as fit. A line longer than the read count is truncated; it
+// can be read in full through addr and data.
+//
+// A write of the form "N,M text" replaces lines N through M, counting
+// from 1 as in addresses, with text. "N text" replaces line N and
+// "N,N-1 text" inserts text before line N. The writes through an open
+// lines file are gathered, so the text can be larger than a message,
+// and the change is made, with the window locked, when the file is
+// closed. A range beyond the body is reported by the close.
+
+// ErrBadLines is the error for a write to the lines file that doesn't
+// start with a range of lines.
+var ErrBadLines = fmt.Errorf("bad lines syntax")
+
+// linesread returns the whole lines of t, starting at line l (the first
+// line is line 0), that fit in count bytes. If the first line doesn't
+// fit, linesread returns as many of its runes as do.
+func linesread(t *Text, l, count int) []byte {
+	q, ok := t.LineToRune(l)
+	if !ok {
+		return nil
+	}
+	nc := t.Nc()
+	var buf []byte
+	for q < nc {
+		qe, ok := t.LineToRune(l + 1)
+		if !ok {
+			qe = nc
+		}
+		r := make([]rune, qe-q)
+		t.file.Read(q, r)
+		line := []byte(string(r))
+		if len(buf)+len(line) > count {
+			if len(buf) == 0 {
+				buf = wholerunes(line, count)
+			}
+			break
+		}
+		buf = append(buf, line...)
+		q = qe
+		l++
+	}
+	return buf
+}
+
+// wholerunes returns the longest prefix of b that holds only whole
+// runes and is at most n bytes long.
+func wholerunes(b []byte, n int) []byte {
+	m := 0
+	for m < len(b) {
+		_, size := utf8.DecodeRune(b[m:])
+		if m+size > n {
+			break
+		}
+		m += size
+	}
+	return b[:m]
+}
+
+// parselines parses the range of lines at the start of a write to the
+// lines file and returns it with the text that follows.
+func parselines(s string) (n, m int, text string, err error) {
+	i := strings.IndexAny(s, " \n")
+	if i < 0 {
+		i = len(s)
+	}
+	addr := s[:i]
+	if i < len(s) {
+		text = s[i+1:]
+	}
+	first, last, comma := strings.Cut(addr, ",")
+	n, err = strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, "", ErrBadLines
+	}
+	m = n
+	if comma {
+		m, err = strconv.Atoi(last)
+		if err != nil {
+			return 0, 0, "", ErrBadLines
+		}
+	}
+	return n, m, text, nil
+}
+
+// linesgather adds data, written at off, to what was written to the
+// lines file through f. It returns ErrBadLines if what was written
+// doesn't start with a range of lines.
+func linesgather(f *Fid, off int, data []byte) error {
+	b := f.lines
+	if off > len(b) {
+		off = len(b)
+	}
+	b = append(b[:off], data...)
+	if i := bytes.IndexAny(b, " \n"); i >= 0 {
+		if _, _, _, err := parselines(string(b[:i])); err != nil {
+			return err
+		}
+	}
+	f.lines = b
+	return nil
+}
+
+// lineswrite makes the change described by data, written to the lines
+// file of w. Must be called with the window lock held.
+func lineswrite(w *Window, data []byte) error {
+	s, _, _ := util.Cvttorunes(data, len(data))
+	n, m, text, err := parselines(string(s))
+	if err != nil {
+		return err
+	}
+	t := &w.body
+	w.Commit(t)
+	if n < 1 || m < n-1 {
+		return ErrAddrRange
+	}
+	q0, ok := t.LineToRune(n - 1)
+	if !ok {
+		return ErrAddrRange
+	}
+	if _, ok := t.LineToRune(m - 1); m >= n && !ok {
+		return ErrAddrRange
+	}
+	q1, ok := t.LineToRune(m)
+	if !ok {
+		q1 = t.Nc()
+	}
+
+	if !w.nomark {
+		global.seq++
+		t.file.Mark(global.seq)
+	}
+	if q1 > q0 {
+		t.Delete(q0, q1, true)
+	}
+	r := []rune(text)
+	if len(r) > 0 {
+		t.Insert(q0, r, true)
+	}
+	t.SetSelect(t.q0, t.q1)
+	if t.fr != nil {
+		t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
+	}
+	return nil
+}
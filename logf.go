@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 
 	"9fans.net/go/plan9"
@@ -17,7 +20,7 @@ type Log struct {
 	start int // msg[0] corresponds to 'start' in the global sequence of eventsevents
 
 	// queued events (nev=entries in ev, mev=capacity of p)
-	ev  []string
+	ev  []logEntry
 	mev int // cap(ev) //TODO(flux) used by the compaction logic
 
 	// open acme/put files that need to read events
@@ -34,6 +37,156 @@ func xfidlogopen(x *Xfid) {
 	x.f.logoff = eventlog.start + len(eventlog.ev)
 }
 
+// An entry in the log file. It reads as "id op name" or, for an op with
+// details, "id op name\tdetail".
+type logEntry struct {
+	id     int
+	op     string
+	name   string
+	detail string
+}
+
+func (e *logEntry) String() string {
+	if e.detail != "" {
+		return fmt.Sprintf("%d %s %s\t%s\n", e.id, e.op, e.name, e.detail)
+	}
+	return fmt.Sprintf("%d %s %s\n", e.id, e.op, e.name)
+}
+
+// logops maps each op of the log file to whether it is read without a
+// filter. The others are only recorded when a filter selects them.
+var logops = map[string]bool{
+	"new":    true,
+	"zerox":  true,
+	"get":    true,
+	"put":    true,
+	"del":    true,
+	"focus":  true,
+	"dirty":  false,
+	"clean":  false,
+	"rename": false,
+	"select": false,
+	"exec":   false,
+	"resize": false,
+}
+
+// A logFilter selects the entries of the log file read through a Fid.
+// Each field is a set of alternatives: an entry must match one of each
+// field that is set.
+type logFilter struct {
+	ops   map[string]bool
+	names []string // Globs matching the window name or its last element.
+	ids   map[int]bool
+}
+
+// parselogfilter parses a filter written to the log file: lines of an
+// "op", "name" or "id" keyword followed by the alternatives. An op of
+// "all" selects every op.
+func parselogfilter(s string) (*logFilter, error) {
+	lf := new(logFilter)
+	for _, line := range strings.Split(s, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
+		if len(words) == 1 {
+			return nil, fmt.Errorf("log filter %q: missing values", words[0])
+		}
+		switch words[0] {
+		case "op":
+			if lf.ops == nil {
+				lf.ops = make(map[string]bool)
+			}
+			for _, op := range words[1:] {
+				if op == "all" {
+					for op := range logops {
+						lf.ops[op] = true
+					}
+					continue
+				}
+				if _, ok := logops[op]; !ok {
+					return nil, fmt.Errorf("log filter: unknown op %q", op)
+				}
+				lf.ops[op] = true
+			}
+		case "name":
+			for _, g := range words[1:] {
+				if _, err := filepath.Match(g, ""); err != nil {
+					return nil, fmt.Errorf("log filter: bad name %q: %v", g, err)
+				}
+				lf.names = append(lf.names, g)
+			}
+		case "id":
+			if lf.ids == nil {
+				lf.ids = make(map[int]bool)
+			}
+			for _, w := range words[1:] {
+				id, err := strconv.Atoi(w)
+				if err != nil {
+					return nil, fmt.Errorf("log filter: bad id %q", w)
+				}
+				lf.ids[id] = true
+			}
+		default:
+			return nil, fmt.Errorf("log filter: unknown keyword %q", words[0])
+		}
+	}
+	return lf, nil
+}
+
+// wants returns true if lf selects op. A nil lf selects the ops read
+// without a filter.
+func (lf *logFilter) wants(op string) bool {
+	if lf == nil || lf.ops == nil {
+		return logops[op]
+	}
+	return lf.ops[op]
+}
+
+// match returns true if lf selects e.
+func (lf *logFilter) match(e *logEntry) bool {
+	if !lf.wants(e.op) {
+		return false
+	}
+	if lf == nil {
+		return true
+	}
+	if lf.ids != nil && !lf.ids[e.id] {
+		return false
+	}
+	if lf.names == nil {
+		return true
+	}
+	for _, g := range lf.names {
+		if ok, _ := filepath.Match(g, e.name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(g, filepath.Base(e.name)); ok && e.name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// xfidlogwrite sets the filter of the log file read through x.f. An
+// empty write restores the default.
+func xfidlogwrite(x *Xfid) {
+	var fc plan9.Fcall
+	lf, err := parselogfilter(string(x.fcall.Data))
+	if err != nil {
+		x.respond(&fc, err)
+		return
+	}
+	if lf.ops == nil && lf.names == nil && lf.ids == nil {
+		lf = nil
+	}
+	eventlog.lk.Lock()
+	x.f.logfilter = lf
+	eventlog.lk.Unlock()
+	fc.Count = x.fcall.Count
+	x.respond(&fc, nil)
+}
+
 func xfidlogclose(x *Xfid) {
 	eventlog.lk.Lock()
 	defer eventlog.lk.Unlock()
@@ -57,7 +210,14 @@ func xfidlogread(x *Xfid) {
 		eventlog.r.L = &eventlog.lk
 	}
 	x.flushed = false
-	for x.f.logoff >= eventlog.start+len(eventlog.ev) && !x.flushed {
+	for {
+		// Skip the entries that the filter doesn't select.
+		for x.f.logoff < eventlog.start+len(eventlog.ev) && !x.f.logfilter.match(&eventlog.ev[x.f.logoff-eventlog.start]) {
+			x.f.logoff++
+		}
+		if x.f.logoff < eventlog.start+len(eventlog.ev) || x.flushed {
+			break
+		}
 		eventlog.r.Wait() // TODO(flux) Did I get the Rendez right?
 	}
 
@@ -74,7 +234,7 @@ func xfidlogread(x *Xfid) {
 	}
 
 	i := x.f.logoff - eventlog.start
-	p := eventlog.ev[i]
+	p := eventlog.ev[i].String()
 	x.f.logoff++
 
 	fc := plan9.Fcall{}
@@ -88,7 +248,7 @@ func xfidlogflush(x *Xfid) {
 	defer eventlog.lk.Unlock()
 	for i := 0; i < len(eventlog.read); i++ {
 		rx := eventlog.read[i]
-		if rx.fcall.Tag == x.fcall.Oldtag {
+		if rx.fs == x.fs && rx.fcall.Tag == x.fcall.Oldtag {
 			rx.flushed = true
 			eventlog.r.Broadcast()
 		}
@@ -114,9 +274,35 @@ func xfidlogflush(x *Xfid) {
 //
 // op == "del" for deleted window
 // - called from winclose
+//
+// The other ops in logops are only added when a filter selects them:
+//
+// op == "dirty" or "clean" when the body becomes dirty or clean, and
+// op == "rename" when it is renamed (with the old name as detail)
+// - called from Window.UpdateTag via logstatus
+//
+// op == "select" when the body is selected with the mouse
+// - called from MouseAction
+//
+// op == "exec" for a command executed in the window
+// - called from execute
+//
+// op == "resize" when the window changes size
+// - called from Window.Resize
 func xfidlog(w *Window, op string) {
+	xfidlogf(w, op, "")
+}
+
+// xfidlogf is like xfidlog but adds the details given by format to the
+// entry.
+func xfidlogf(w *Window, op string, format string, args ...interface{}) {
 	eventlog.lk.Lock()
 	defer eventlog.lk.Unlock()
+	w.lognew = true
+	w.logname = w.body.file.Name()
+	if !logops[op] && !eventlog.wanted(op) {
+		return
+	}
 	if len(eventlog.ev) >= cap(eventlog.ev) {
 		// Remove and free any entries that all readers have read.
 		min := eventlog.start + len(eventlog.ev)
@@ -132,10 +318,45 @@ func xfidlog(w *Window, op string) {
 			eventlog.ev = eventlog.ev[:len(eventlog.ev)-n] // TODO(flux) fussy, might have messed this up
 		}
 	}
-	name := w.body.file.Name()
-	eventlog.ev = append(eventlog.ev, fmt.Sprintf("%d %s %s\n", w.id, op, name))
+	e := logEntry{id: w.id, op: op, name: w.body.file.Name()}
+	if format != "" {
+		e.detail = fmt.Sprintf(format, args...)
+	}
+	eventlog.ev = append(eventlog.ev, e)
 	if eventlog.r.L == nil {
 		eventlog.r.L = &eventlog.lk
 	}
 	eventlog.r.Broadcast()
 }
+
+// wanted returns true if the filter of an open log file selects op.
+// Must be called with l.lk held.
+func (l *Log) wanted(op string) bool {
+	for _, f := range l.f {
+		if f.logfilter.wants(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// logstatus adds dirty, clean and rename entries to the log for the
+// changes to the body of w since the last entry for w. dirty is whether
+// the body is now dirty.
+func (w *Window) logstatus(dirty bool) {
+	if !w.lognew {
+		// Not in the log yet.
+		return
+	}
+	if dirty != w.logdirty {
+		w.logdirty = dirty
+		if dirty {
+			xfidlog(w, "dirty")
+		} else {
+			xfidlog(w, "clean")
+		}
+	}
+	if old := w.logname; w.body.file.Name() != old {
+		xfidlogf(w, "rename", "%s", old)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/google/go-cmp/cmp"
+	"github.com/rjkroege/edwood/file"
+)
+
+func TestParseLogFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want *logFilter
+		err  bool
+	}{
+		{"Empty", "", &logFilter{}, false},
+		{"Ops", "op put dirty\n", &logFilter{ops: map[string]bool{"put": true, "dirty": true}}, false},
+		{"All", "op all", &logFilter{ops: logopsset()}, false},
+		{"Everything", "op new\nname *.go /tmp/*\nid 1 3\n", &logFilter{
+			ops:   map[string]bool{"new": true},
+			names: []string{"*.go", "/tmp/*"},
+			ids:   map[int]bool{1: true, 3: true},
+		}, false},
+		{"UnknownOp", "op frob", nil, true},
+		{"BadId", "id x", nil, true},
+		{"BadGlob", "name [", nil, true},
+		{"NoValues", "op", nil, true},
+		{"UnknownKeyword", "window 1", nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parselogfilter(tc.in)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v; want error %v", err, tc.err)
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(logFilter{})); diff != "" {
+				t.Errorf("filter mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func logopsset() map[string]bool {
+	ops := make(map[string]bool)
+	for op := range logops {
+		ops[op] = true
+	}
+	return ops
+}
+
+func TestLogFilterMatch(t *testing.T) {
+	put := &logEntry{id: 1, op: "put", name: "/src/a.go"}
+	dirty := &logEntry{id: 2, op: "dirty", name: "/src/b.c"}
+	for _, tc := range []struct {
+		name  string
+		lf    *logFilter
+		e     *logEntry
+		match bool
+	}{
+		{"DefaultClassic", nil, put, true},
+		{"DefaultNew", nil, dirty, false},
+		{"Op", &logFilter{ops: map[string]bool{"dirty": true}}, dirty, true},
+		{"OtherOp", &logFilter{ops: map[string]bool{"dirty": true}}, put, false},
+		{"NameOnly", &logFilter{names: []string{"*.go"}}, put, true},
+		{"NameOnlyNewOp", &logFilter{names: []string{"*.c"}}, dirty, false},
+		{"FullName", &logFilter{names: []string{"/src/*"}}, put, true},
+		{"OtherName", &logFilter{names: []string{"*.c"}}, put, false},
+		{"Id", &logFilter{ids: map[int]bool{1: true}}, put, true},
+		{"OtherId", &logFilter{ids: map[int]bool{2: true}}, put, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.lf.match(tc.e); got != tc.match {
+				t.Errorf("match is %v; want %v", got, tc.match)
+			}
+		})
+	}
+}
+
+func TestXfidreadQlogFilter(t *testing.T) {
+	mr := new(mockResponder)
+	x := &Xfid{
+		f: &Fid{
+			qid: plan9.Qid{Path: QID(0, Qlog)},
+		},
+		fs: mr,
+	}
+	xfidlogopen(x)
+	defer xfidlogclose(x)
+
+	const filter = "op put dirty\nname *.go\n"
+	x.fcall = plan9.Fcall{Data: []byte(filter), Count: uint32(len(filter))}
+	xfidwrite(x)
+	if mr.err != nil {
+		t.Fatalf("write got error %v; want nil", mr.err)
+	}
+
+	w := NewWindow().initHeadless(nil)
+	w.id = 5
+	w.body.file = file.MakeObservableEditableBuffer("/src/a.go", nil)
+	other := NewWindow().initHeadless(nil)
+	other.id = 6
+	other.body.file = file.MakeObservableEditableBuffer("/src/b.c", nil)
+	xfidlog(w, "new")
+	xfidlog(other, "new")
+	other.logstatus(true)
+	w.logstatus(true)
+	xfidlog(w, "put")
+
+	for _, want := range []string{"5 dirty /src/a.go\n", "5 put /src/a.go\n"} {
+		x.fcall = plan9.Fcall{}
+		xfidread(x)
+		if mr.err != nil {
+			t.Fatalf("read got error %v; want nil", mr.err)
+		}
+		if got := string(mr.fcall.Data); got != want {
+			t.Errorf("got data %q; want %q", got, want)
+		}
+	}
+
+	// Not recorded: the filter doesn't select renames.
+	w.body.file.SetName("/src/c.go")
+	w.logstatus(true)
+	x.fcall = plan9.Fcall{Data: []byte("op rename\n"), Count: 10}
+	xfidwrite(x)
+	w.body.file.SetName("/src/d.go")
+	w.logstatus(true)
+	x.fcall = plan9.Fcall{}
+	xfidread(x)
+	if got, want := string(mr.fcall.Data), "5 rename /src/d.go\t/src/c.go\n"; got != want {
+		t.Errorf("got data %q; want %q", got, want)
+	}
+}
@@ -63,9 +63,9 @@ func startplumbing() {
 
 func look3(t *Text, q0 int, q1 int, external bool) {
 	var (
-		n, c, f int
-		ct      *Text
-		r       []rune
+		n  int
+		ct *Text
+		r  []rune
 		//m *Plumbmsg
 		//dir string
 	)
@@ -75,60 +75,14 @@ func look3(t *Text, q0 int, q1 int, external bool) {
 		global.seltext = t
 	}
 	e, expanded := expand(t, q0, q1)
-	if !external && t.w != nil && t.w.nopen[QWevent] > 0 {
+	if !external && t.w != nil && t.w.reported() {
 		// send alphanumeric expansion to external client
-		if !expanded {
-			return
-		}
-		f = 0
-		if (e.at != nil && t.w != nil) || (len(e.name) > 0 && lookfile(e.name) != nil) {
-			f = 1 // acme can do it without loading a file
-		}
-		if q0 != e.q0 || q1 != e.q1 {
-			f |= 2 // second (post-expand) message follows
+		if expanded {
+			lookevent(t, q0, q1, e)
 		}
-		if len(e.name) > 0 {
-			f |= 4 // it's a file name
-		}
-		c = 'l'
-		if t.what == Body {
-			c = 'L'
-		}
-		n = q1 - q0
-		if n <= EVENTSIZE {
-			r = make([]rune, n)
-			t.file.Read(q0, r)
-			t.w.Eventf("%c%d %d %d %d %v\n", c, q0, q1, f, n, string(r))
-		} else {
-			t.w.Eventf("%c%d %d %d 0 \n", c, q0, q1, f)
-		}
-		if q0 == e.q0 && q1 == e.q1 {
+		if t.w.intercepted() {
 			return
 		}
-		if len(e.name) > 0 {
-			n = len(e.name)
-			if e.a1 > e.a0 {
-				n += 1 + (e.a1 - e.a0)
-			}
-			r = make([]rune, n)
-			copy(r, []rune(e.name))
-			if e.a1 > e.a0 {
-				nlen := len([]rune(e.name))
-				r[nlen] = ':'
-				e.at.file.Read(e.a0, r[nlen+1:nlen+1+e.a1-e.a0])
-			}
-		} else {
-			n = e.q1 - e.q0
-			r = make([]rune, n)
-			t.file.Read(e.q0, r)
-		}
-		f &= ^2
-		if n <= EVENTSIZE {
-			t.w.Eventf("%c%d %d %d %d %v\n", c, e.q0, e.q1, f, n, string(r))
-		} else {
-			t.w.Eventf("%c%d %d %d 0 \n", c, e.q0, e.q1, f)
-		}
-		return
 	}
 	if plumbsendfid != nil {
 		m, err := look3Message(t, q0, q1)
@@ -167,6 +121,56 @@ func look3(t *Text, q0 int, q1 int, external bool) {
 	}
 }
 
+// lookevent reports the look at [q0, q1) in t, expanded to e, as an
+// Event of the window of t.
+func lookevent(t *Text, q0, q1 int, e *Expand) {
+	var (
+		n, c, f int
+		r       []rune
+	)
+	f = 0
+	if (e.at != nil && t.w != nil) || (len(e.name) > 0 && lookfile(e.name) != nil) {
+		f = 1 // acme can do it without loading a file
+	}
+	if q0 != e.q0 || q1 != e.q1 {
+		f |= 2 // second (post-expand) message follows
+	}
+	if len(e.name) > 0 {
+		f |= 4 // it's a file name
+	}
+	c = 'l'
+	if t.what == Body {
+		c = 'L'
+	}
+	ev := Event{Type: string(rune(c)), Q0: q0, Q1: q1, Flag: f, Text: eventtext(t, q0, q1)}
+	if q0 == e.q0 && q1 == e.q1 {
+		t.w.Event(ev)
+		return
+	}
+	if len(e.name) > 0 {
+		n = len(e.name)
+		if e.a1 > e.a0 {
+			n += 1 + (e.a1 - e.a0)
+		}
+		r = make([]rune, n)
+		copy(r, []rune(e.name))
+		if e.a1 > e.a0 {
+			nlen := len([]rune(e.name))
+			r[nlen] = ':'
+			e.at.file.Read(e.a0, r[nlen+1:nlen+1+e.a1-e.a0])
+		}
+	} else {
+		n = e.q1 - e.q0
+		r = make([]rune, n)
+		t.file.Read(e.q0, r)
+	}
+	ev.Expansion = &EventExpansion{Q0: e.q0, Q1: e.q1}
+	if n <= EVENTSIZE {
+		ev.Expansion.Text = string(r)
+	}
+	t.w.Event(ev)
+}
+
 // look3Message generates a plumb message for the text in t at range [q0, q1).
 // If q0 == q1, the range will be expanded to the current selection if q0/q1 falls
 // within the selection. Otherwise, it'll expand to a whitespace-delimited word.
@@ -261,6 +265,12 @@ func plumbshow(m *plumb.Message) {
 }
 
 func search(ct *Text, r []rune) bool {
+	return searchcase(ct, r, false)
+}
+
+// searchcase is search that, if fold is true, matches letters
+// regardless of case.
+func searchcase(ct *Text, r []rune, fold bool) bool {
 	n := len(r)
 	if n > RBUFSIZE {
 		warning(nil, "string too long\n")
@@ -268,6 +278,9 @@ func search(ct *Text, r []rune) bool {
 	}
 
 	res := regexp.QuoteMeta(string(r))
+	if fold {
+		res = "(?i)" + res
+	}
 	// Unless QuoteMeta has a bug, this will always work.
 	regexp := regexp.MustCompile(res)
 
@@ -436,6 +449,7 @@ func openfile(t *Text, e *Expand) *Window {
 		w.SetName(e.name)
 		t.Load(0, e.name, true)
 		t.file.Clean()
+		loadundohistory(t)
 		t.w.tag.SetSelect(t.w.tag.file.Nr(), t.w.tag.file.Nr())
 		if ow != nil {
 			for _, inc := range ow.incl {
@@ -285,7 +285,7 @@ func BenchmarkLook3(t *testing.B) {
 				tc.fn(b, global)
 				b.StopTimer()
 
-				got, err := global.row.dump()
+				got, err := global.row.dumpstate(false)
 				if err != nil {
 					b.Fatalf("dump failed: %v", err)
 				}
@@ -298,3 +298,31 @@ func BenchmarkLook3(t *testing.B) {
 		})
 	}
 }
+
+func TestLookFold(t *testing.T) {
+	for _, tc := range []struct {
+		dot  Range
+		arg  string
+		want Range
+	}{
+		{Range{0, 0}, "SHORT", Range{0, 0}},
+		{Range{0, 0}, "-i SHORT", Range{10, 15}},
+		{Range{0, 1}, "", Range{0, 1}},
+		{Range{0, 1}, "-i", Range{14, 15}},
+		{Range{0, 0}, "-inline", Range{0, 0}},
+	} {
+		t.Run(tc.arg, func(t *testing.T) {
+			FlexiblyMakeWindowScaffold(
+				t,
+				ScWin("test"),
+				ScBody("test", "This is a\nshort text\nto try addressing\n"),
+			)
+			w := global.row.col[0].w[0]
+			w.body.q0, w.body.q1 = tc.dot.q0, tc.dot.q1
+			look(&w.body, nil, nil, false, false, tc.arg)
+			if got := (Range{w.body.q0, w.body.q1}); got != tc.want {
+				t.Errorf("Look %q selected %v; want %v", tc.arg, got, tc.want)
+			}
+		})
+	}
+}
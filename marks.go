@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rjkroege/edwood/dumpfile"
+	"github.com/rjkroege/edwood/file"
+)
+
+// The body of a window has named marks (see file.Marks): ranges that
+// move with the text around them as it is edited. In Edit, k name sets
+// the mark called name to the address and '"name" is the mark as an
+// address. Without a name, k and ' use the unnamed mark, as in sam: 'd
+// deletes the text of the unnamed mark.
+//
+// The marks file of a window holds a line per mark:
+//
+//	'name q0 q1
+//
+// with q0 and q1 the rune offsets of the mark. Writing such a line sets
+// the mark and writing 'name alone removes it.
+
+// ErrBadMark is the error for a malformed line written to the marks file.
+var ErrBadMark = fmt.Errorf("bad mark")
+
+// validmarkname returns true if name can name a mark.
+func validmarkname(name string) bool {
+	for _, c := range name {
+		if !isalnum(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// marksread returns the contents of the marks file of w.
+func marksread(w *Window) string {
+	var sb strings.Builder
+	m := w.body.file.Marks()
+	for _, name := range m.Names() {
+		q0, q1, _ := m.Get(name)
+		fmt.Fprintf(&sb, "'%s %d %d\n", name, q0, q1)
+	}
+	return sb.String()
+}
+
+// markswrite sets or removes the marks of w as given by the lines of
+// data. Must be called with the window lock held.
+func markswrite(w *Window, data []byte) error {
+	t := &w.body
+	t.Commit()
+	m := t.file.Marks()
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		name, ok := strings.CutPrefix(f[0], "'")
+		if !ok || !validmarkname(name) {
+			return ErrBadMark
+		}
+		switch len(f) {
+		case 1:
+			m.Delete(name)
+		case 3:
+			q0, err0 := strconv.Atoi(f[1])
+			q1, err1 := strconv.Atoi(f[2])
+			if err0 != nil || err1 != nil {
+				return ErrBadMark
+			}
+			if q0 < 0 || q0 > q1 || q1 > t.Nc() {
+				return ErrAddrRange
+			}
+			m.Set(name, q0, q1)
+		default:
+			return ErrBadMark
+		}
+	}
+	return nil
+}
+
+// dumpmarks returns the marks of f for a dump file, or nil if there are
+// none.
+func dumpmarks(f *file.ObservableEditableBuffer) map[string]dumpfile.Mark {
+	m := f.Marks()
+	names := m.Names()
+	if len(names) == 0 {
+		return nil
+	}
+	marks := make(map[string]dumpfile.Mark, len(names))
+	for _, name := range names {
+		q0, q1, _ := m.Get(name)
+		marks[name] = dumpfile.Mark{Q0: q0, Q1: q1}
+	}
+	return marks
+}
+
+// restoremarks sets the marks of f from a dump file, leaving out those
+// beyond the end of f, whose contents have changed since the dump.
+func restoremarks(f *file.ObservableEditableBuffer, marks map[string]dumpfile.Mark) {
+	m := f.Marks()
+	for name, r := range marks {
+		if validmarkname(name) && 0 <= r.Q0 && r.Q0 <= r.Q1 && r.Q1 <= f.Nr() {
+			m.Set(name, r.Q0, r.Q1)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/dumpfile"
+)
+
+func TestMarksFile(t *testing.T) {
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("test"),
+		ScBody("test", contents),
+	)
+	w := global.row.col[0].w[0]
+
+	rpc := func(write bool, data string) (string, error) {
+		t.Helper()
+		mr := new(mockResponder)
+		x := &Xfid{
+			f:     &Fid{qid: plan9.Qid{Path: QID(w.id, QWmarks)}, w: w},
+			fcall: plan9.Fcall{Count: 8192, Data: []byte(data)},
+			fs:    mr,
+		}
+		if write {
+			xfidwrite(x)
+		} else {
+			xfidread(x)
+		}
+		return string(mr.fcall.Data), mr.err
+	}
+
+	if _, err := rpc(true, "'a 10 15\n'todo 3 3\n'x 0 1\n'x\n"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	w.body.Insert(0, []rune(">"), true)
+	want := "'a 11 16\n'todo 4 4\n"
+	if got, err := rpc(false, ""); err != nil || got != want {
+		t.Errorf("read got %q, %v; want %q", got, err, want)
+	}
+	for _, tc := range []struct {
+		data string
+		err  error
+	}{
+		{"a 1 2", ErrBadMark},
+		{"'a 1", ErrBadMark},
+		{"'a-b 1 2", ErrBadMark},
+		{"'a one 2", ErrBadMark},
+		{"'a 5 2", ErrAddrRange},
+		{"'a 0 1000", ErrAddrRange},
+	} {
+		if _, err := rpc(true, tc.data); err != tc.err {
+			t.Errorf("write %q got error %v; want %v", tc.data, err, tc.err)
+		}
+	}
+
+	// Edit sees the marks written to the file.
+	global.row.lk.Lock()
+	w.Lock('M')
+	editcmd(&w.body, []rune("'\"a\" c/long/"))
+	w.Unlock()
+	global.row.lk.Unlock()
+	if got, want := w.body.file.String(), ">This is a\nlong text\nto try addressing\n"; got != want {
+		t.Errorf("Edit with mark got %q; want %q", got, want)
+	}
+}
+
+func TestDumpLoadMarks(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "hello.txt")
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get current working directory: %v", err)
+	}
+	dump := &dumpfile.Content{
+		CurrentDir: cwd,
+		VarFont:    *varfontflag,
+		FixedFont:  *fixedfontflag,
+		Columns:    []dumpfile.Column{{}},
+		Windows: []*dumpfile.Window{
+			{
+				Type: dumpfile.Unsaved,
+				Tag: dumpfile.Text{
+					Buffer: filename + " Del Snarf Undo | Look",
+				},
+				Body: dumpfile.Text{
+					Buffer: "hello world\n",
+				},
+				Marks: map[string]dumpfile.Mark{
+					"":      {Q0: 0, Q1: 5},
+					"w":     {Q0: 6, Q1: 11},
+					"gone":  {Q0: 6, Q1: 100},
+					"bad-1": {Q0: 0, Q1: 1},
+				},
+			},
+		},
+	}
+
+	setGlobalsForLoadTesting()
+	if err := global.row.Load(dump, "", true); err != nil {
+		t.Fatalf("Row.Load failed: %v", err)
+	}
+	w := global.row.col[0].w[0]
+	if got, want := marksread(w), "' 0 5\n'w 6 11\n"; got != want {
+		t.Errorf("restored marks %q; want %q", got, want)
+	}
+
+	got, err := global.row.dumpstate(false)
+	if err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	want := map[string]dumpfile.Mark{"": {Q0: 0, Q1: 5}, "w": {Q0: 6, Q1: 11}}
+	if !reflect.DeepEqual(got.Windows[0].Marks, want) {
+		t.Errorf("dumped marks %v; want %v", got.Windows[0].Marks, want)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/rjkroege/edwood/regexp"
 	"github.com/rjkroege/edwood/sam"
 )
@@ -24,6 +26,44 @@ func rxcompile(r string) (*AcmeRegexp, error) {
 	}, nil
 }
 
+// A regular expression in an Edit command or an address can be followed
+// by flags, letters directly after its closing delimiter:
+//
+//	i	letters match regardless of case
+//	s	. matches a newline too
+//	l	the expression is literal text, without special characters
+//
+// as in /edwood/i or x/a.b/l. In Edit, a run of flag letters followed by
+// a delimiter is instead a command and its text, as in x/re/s/a/b/, and a
+// command i or s followed by a blank or newline must be separated from
+// the regular expression by a blank, as in /re/ i. The flags of s come
+// after its replacement text, with g. An empty regular expression is the
+// previous one, flags and all.
+
+// rxflagchars are the letters of the flags of a regular expression.
+const rxflagchars = "isl"
+
+// rxflags returns the regular expression pat as modified by flags, a
+// string of the letters in rxflagchars.
+func rxflags(pat, flags string) string {
+	if pat == "" || flags == "" {
+		return pat
+	}
+	if strings.ContainsRune(flags, 'l') {
+		pat = regexp.QuoteMeta(pat)
+	}
+	var mode string
+	for _, c := range "is" {
+		if strings.ContainsRune(flags, c) {
+			mode += string(c)
+		}
+	}
+	if mode != "" {
+		pat = "(?" + mode + ")" + pat
+	}
+	return pat
+}
+
 // rxexecute searches forward in r[start:end] (from beginning of the slice to the end)
 // and returns at most n matches. If r is nil, it is derived from t.
 func (re *AcmeRegexp) rxexecute(t sam.Texter, r []rune, start int, end int, n int) []RangeSet {
@@ -138,10 +138,8 @@ func (r *Row) Resize(rect image.Rectangle) {
 
 func (row *Row) DragCol(c *Column, _ int) {
 	var (
-		r       image.Rectangle
 		i, b, x int
 		p, op   image.Point
-		d       *Column
 	)
 	clearmouse()
 	row.display.SetCursor(&boxcursor)
@@ -186,27 +184,35 @@ Found:
 	if i == 0 {
 		return
 	}
-	d = row.col[i-1]
-	if p.X < d.r.Min.X+row.display.ScaleSize(80+Scrollwid) {
-		p.X = d.r.Min.X + row.display.ScaleSize(80+Scrollwid)
+	row.movecolx(i, p.X)
+	c.MouseBut()
+}
+
+// movecolx moves the left edge of column i, which must not be the first,
+// to x, or as close to it as leaves both column i and the column to its
+// left usable.
+func (row *Row) movecolx(i, x int) {
+	c := row.col[i]
+	d := row.col[i-1]
+	if x < d.r.Min.X+row.display.ScaleSize(80+Scrollwid) {
+		x = d.r.Min.X + row.display.ScaleSize(80+Scrollwid)
 	}
-	if p.X > c.r.Max.X-row.display.ScaleSize(80-Scrollwid) {
-		p.X = c.r.Max.X - row.display.ScaleSize(80-Scrollwid)
+	if x > c.r.Max.X-row.display.ScaleSize(80-Scrollwid) {
+		x = c.r.Max.X - row.display.ScaleSize(80-Scrollwid)
 	}
-	r = d.r
+	r := d.r
 	r.Max.X = c.r.Max.X
 	row.display.ScreenImage().Draw(r, row.display.White(), nil, image.Point{})
-	r.Max.X = p.X
+	r.Max.X = x
 	d.Resize(r)
 	r = c.r
-	r.Min.X = p.X
+	r.Min.X = x
 	r.Max.X = r.Min.X
 	r.Max.X += row.display.ScaleSize(Border)
 	row.display.ScreenImage().Draw(r, row.display.Black(), nil, image.Point{})
 	r.Min.X = r.Max.X
 	r.Max.X = c.r.Max.X
 	c.Resize(r)
-	c.MouseBut()
 }
 
 func (row *Row) Close(c *Column, dofree bool) {
@@ -324,7 +330,7 @@ func (r *Row) Dump(file string) error {
 		}
 		file = f
 	}
-	dump, err := r.dump()
+	dump, err := r.dumpstate(true)
 	if err != nil {
 		return err
 	}
@@ -335,7 +341,9 @@ func (r *Row) Dump(file string) error {
 	return nil
 }
 
-func (r *Row) dump() (*dumpfile.Content, error) {
+// dumpstate returns the state of r, with the undo histories of its
+// windows if history is true.
+func (r *Row) dumpstate(history bool) (*dumpfile.Content, error) {
 	rowTag := r.tag.file.String()
 	// Remove commands at the beginning of row tag.
 	if i := strings.Index(rowTag, RowTag); i > 1 {
@@ -370,7 +378,7 @@ func (r *Row) dump() (*dumpfile.Content, error) {
 			},
 		}
 		for _, w := range c.w {
-			if w.nopen[QWevent] != 0 {
+			if w.external() {
 				// Mark zeroxes of external windows specially.
 				dumpid[w.body.file] = -1
 			}
@@ -384,14 +392,14 @@ func (r *Row) dump() (*dumpfile.Content, error) {
 			t := &w.body
 
 			// External windows can't be recreated so skip them.
-			if w.nopen[QWevent] > 0 {
+			if w.external() {
 				if w.dumpstr == "" {
 					continue
 				}
 			}
 
 			// zeroxes of external windows are tossed
-			if dumpid[t.file] < 0 && w.nopen[QWevent] == 0 {
+			if dumpid[t.file] < 0 && !w.external() {
 				continue
 			}
 
@@ -426,12 +434,22 @@ func (r *Row) dump() (*dumpfile.Content, error) {
 			case !w.body.file.Dirty() && access(t.file.Name()) || w.body.file.IsDir():
 				dumpid[t.file] = w.id
 				dw.Type = dumpfile.Saved
+				if !t.file.IsDir() {
+					if history {
+						dw.History = dumphistory(t.file)
+					}
+					dw.Marks = dumpmarks(t.file)
+				}
 
 			default:
 				dumpid[t.file] = w.id
 				// TODO(rjk): Conceivably this is a bit of a layering violation?
 				dw.Type = dumpfile.Unsaved
 				dw.Body.Buffer = t.file.String()
+				if history {
+					dw.History = dumphistory(t.file)
+				}
+				dw.Marks = dumpmarks(t.file)
 			}
 			dw.Tag = dumpfile.Text{
 				Buffer: w.tag.file.String(),
@@ -444,8 +462,8 @@ func (r *Row) dump() (*dumpfile.Content, error) {
 }
 
 // loadhelper breaks out common load file parsing functionality for selected row
-// types.
-func (row *Row) loadhelper(win *dumpfile.Window) error {
+// types. h is the undo history of win, rebased, or nil.
+func (row *Row) loadhelper(win *dumpfile.Window, h *file.History) error {
 	// Column for this window.
 	i := win.Column
 
@@ -510,11 +528,27 @@ func (row *Row) loadhelper(win *dumpfile.Window) error {
 
 	if win.Type == dumpfile.Unsaved {
 		w.body.LoadReader(0, subl[0], strings.NewReader(win.Body.Buffer), true)
+		if h != nil {
+			// A mismatch means that the dump file was edited. Keep the contents.
+			if err := restoreundohistory(w.body.file, h); err != nil {
+				warning(nil, "can't restore undo history of %s: %v\n", subl[0], err)
+			}
+		}
 		w.body.file.Modded()
 
 	} else if win.Type != dumpfile.Zerox && len(subl[0]) > 0 && subl[0][0] != '+' && subl[0][0] != '-' {
 		// Implementation of the Get command: open the file.
 		get(&w.body, nil, nil, false, false, "")
+		if h != nil {
+			// A mismatch means that the file was modified since the dump.
+			if err := restoreundohistory(w.body.file, h); err != nil {
+				warning(nil, "can't restore undo history of %s: %v\n", subl[0], err)
+			}
+		}
+	}
+
+	if win.Type != dumpfile.Zerox {
+		restoremarks(w.body.file, win.Marks)
 	}
 
 	if win.Font != "" {
@@ -640,6 +674,19 @@ func (row *Row) loadimpl(dump *dumpfile.Content, initing bool) error {
 		row.col[i].tag.Show(col.Tag.Q0, col.Tag.Q1, true)
 	}
 
+	// Move the undo histories past the sequence numbers already in use.
+	// All windows share a base so that undo points made in several files
+	// at once (e.g. by Edit) remain coupled.
+	base := global.seq
+	histories := make(map[*dumpfile.Window]*file.History)
+	for _, win := range dump.Windows {
+		if win.History != nil {
+			h := loadhistory(win.History)
+			h.Rebase(base)
+			histories[win] = h
+		}
+	}
+
 	// Load the windows.
 	for _, win := range dump.Windows {
 		switch win.Type {
@@ -652,7 +699,7 @@ func (row *Row) loadimpl(dump *dumpfile.Content, initing bool) error {
 			run(nil, win.ExecCommand, dirline, true, "", "", false)
 
 		case dumpfile.Saved, dumpfile.Unsaved, dumpfile.Zerox:
-			if err := row.loadhelper(win); err != nil {
+			if err := row.loadhelper(win, histories[win]); err != nil {
 				return err
 			}
 
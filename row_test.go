@@ -20,6 +20,7 @@ import (
 	"github.com/rjkroege/edwood/draw"
 	"github.com/rjkroege/edwood/dumpfile"
 	"github.com/rjkroege/edwood/edwoodtest"
+	"github.com/rjkroege/edwood/file"
 )
 
 const gopherEdwoodDir = "/home/gopher/go/src/edwood"
@@ -166,7 +167,7 @@ func TestRowLoad(t *testing.T) {
 			if err != nil {
 				t.Fatalf("failed to load dump file %v: %v", tc, err)
 			}
-			got, err := global.row.dump()
+			got, err := global.row.dumpstate(false)
 			if err != nil {
 				t.Fatalf("dump failed: %v", err)
 			}
@@ -488,3 +489,123 @@ func editDumpFileForTesting(t *testing.T, filename string) string {
 	f.Close()
 	return f.Name()
 }
+
+func TestDumpLoadUndoHistory(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "hello.txt")
+
+	oeb := file.MakeObservableEditableBuffer(filename, []rune("hello world\n"))
+	oeb.Mark(1)
+	oeb.InsertAt(0, []rune("> "))
+	oeb.Mark(2)
+	oeb.DeleteAt(2, 7)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get current working directory: %v", err)
+	}
+	dump := &dumpfile.Content{
+		CurrentDir: cwd,
+		VarFont:    *varfontflag,
+		FixedFont:  *fixedfontflag,
+		Columns:    []dumpfile.Column{{}},
+		Windows: []*dumpfile.Window{
+			{
+				Type: dumpfile.Unsaved,
+				Tag: dumpfile.Text{
+					Buffer: filename + " Del Snarf Undo | Look",
+				},
+				Body: dumpfile.Text{
+					Buffer: oeb.String(),
+				},
+				History: dumphistory(oeb),
+			},
+		},
+	}
+
+	setGlobalsForLoadTesting()
+	global.seq = 5
+	if err := global.row.Load(dump, "", true); err != nil {
+		t.Fatalf("Row.Load failed: %v", err)
+	}
+	w := global.row.col[0].w[0]
+	if got, want := w.body.file.Seq(), 7; got != want {
+		t.Errorf("restored seq: got %d, want %d", got, want)
+	}
+	if global.seq < w.body.file.Seq() {
+		t.Errorf("global.seq %d not moved past restored seq %d", global.seq, w.body.file.Seq())
+	}
+
+	for _, tc := range []struct {
+		isundo bool
+		want   string
+	}{
+		{true, "> hello world\n"},
+		{true, "hello world\n"},
+		{false, "> hello world\n"},
+	} {
+		undo(&w.body, nil, nil, tc.isundo, false, "")
+		if got := w.body.file.String(); got != tc.want {
+			t.Errorf("undo(%v): got %q, want %q", tc.isundo, got, tc.want)
+		}
+	}
+
+	got, err := global.row.dumpstate(true)
+	if err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	if got.Windows[0].History == nil {
+		t.Errorf("dump of restored window has no undo history")
+	}
+}
+
+func TestUndoHistorySidecar(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "hello.txt")
+	if err := os.WriteFile(filename, []byte("hello world\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	savedundodir := *undodir
+	*undodir = filepath.Join(dir, "undo")
+	defer func() { *undodir = savedundodir }()
+
+	setGlobalsForLoadTesting()
+	global.seq = 0
+	c := global.row.Add(nil, -1)
+	readfile(c, filename)
+	w := c.w[0]
+
+	global.seq++
+	w.body.file.Mark(global.seq)
+	w.body.Insert(0, []rune("> "), true)
+	put(&w.body, nil, nil, false, false, "")
+	if _, err := os.Stat(undohistoryfile(filename)); err != nil {
+		t.Fatalf("no undo history file after Put: %v", err)
+	}
+
+	// Simulate a restart.
+	setGlobalsForLoadTesting()
+	global.seq = 0
+	c = global.row.Add(nil, -1)
+	readfile(c, filename)
+	w = c.w[0]
+	if w.body.file.Dirty() {
+		t.Errorf("restored window is dirty")
+	}
+	undo(&w.body, nil, nil, true, false, "")
+	if got, want := w.body.file.String(), "hello world\n"; got != want {
+		t.Errorf("undo after restart: got %q, want %q", got, want)
+	}
+
+	// A file modified outside of Edwood doesn't get the stale history.
+	if err := os.WriteFile(filename, []byte("changed\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	setGlobalsForLoadTesting()
+	c = global.row.Add(nil, -1)
+	readfile(c, filename)
+	if c.w[0].body.file.HasUndoableChanges() {
+		t.Errorf("stale undo history restored onto modified file")
+	}
+}
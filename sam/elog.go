@@ -50,6 +50,17 @@ func MakeElog() Elog {
 	}
 }
 
+// Copy returns a copy of e that doesn't share storage with it, so that
+// it can be applied after e has been reused.
+func (e *Elog) Copy() Elog {
+	c := Elog{Log: make([]ElogOperation, len(e.Log)), warned: e.warned}
+	for i, eo := range e.Log {
+		eo.r = append([]rune(nil), eo.r...)
+		c.Log[i] = eo
+	}
+	return c
+}
+
 func (e *Elog) Reset() {
 	// TODO(flux): If working on large documents we may want to actually trim the
 	// array here, as it will hog memory after a fine-grained edit.  But don't worry about
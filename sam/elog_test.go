@@ -142,3 +142,21 @@ func TestApply(t *testing.T) {
 		}
 	}
 }
+
+func TestElogCopy(t *testing.T) {
+	e := MakeElog()
+	e.Insert(0, []rune("abc"))
+	e.Replace(5, 7, []rune("xy"))
+	c := e.Copy()
+
+	// Reusing e leaves c alone.
+	e.Term()
+	e.Insert(0, []rune("ZZZ"))
+	e.Replace(5, 7, []rune("QQ"))
+
+	tb := TextBuffer{0, 0, []rune("0123456789")}
+	c.Apply(&tb)
+	if got, want := string(tb.buf), "abc01234xy789"; got != want {
+		t.Errorf("applied copy got %q; want %q", got, want)
+	}
+}
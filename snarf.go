@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/ninep"
+)
+
+// The snarf file holds the snarf buffer. Reading it gives the buffer as
+// it was when the file was opened. What is written to it replaces the
+// buffer when the file is closed, so a later Paste inserts it.
+//
+// The read-only files snarf.1 to snarf.NSNARF hold the most recent
+// contents of the snarf buffer, snarf.1 being the latest.
+
+// NSNARF is the number of snarfs kept in the snarf.<n> files.
+const NSNARF = 10
+
+// recordsnarf adds the snarf buffer to the snarf history unless it is
+// empty or already the latest entry. Must be called with the row lock
+// held.
+func recordsnarf() {
+	b := global.snarfbuf
+	if len(b) == 0 || (len(global.snarfhist) > 0 && bytes.Equal(global.snarfhist[0], b)) {
+		return
+	}
+	n := len(global.snarfhist) + 1
+	if n > NSNARF {
+		n = NSNARF
+	}
+	hist := make([][]byte, n)
+	hist[0] = b
+	copy(hist[1:], global.snarfhist)
+	global.snarfhist = hist
+}
+
+// snarfhistnum returns n if name is the name of the snarf.<n> file of
+// an entry in the snarf history. Must be called with the row lock held.
+func snarfhistnum(name string) (int, bool) {
+	s, ok := strings.CutPrefix(name, "snarf.")
+	if !ok || s == "" || s[0] == '0' || s[0] == '+' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(global.snarfhist) {
+		return 0, false
+	}
+	return n, true
+}
+
+// snarfDirTab returns the DirTab entry for the snarf.<n> file.
+func snarfDirTab(n int) *DirTab {
+	return &DirTab{
+		name: fmt.Sprintf("snarf.%d", n),
+		t:    plan9.QTFILE,
+		qid:  Qsnarfhist,
+		perm: 0400,
+	}
+}
+
+// xfidsnarfopen prepares the snarf file or a snarf.<n> file for x, which
+// opens it.
+func xfidsnarfopen(x *Xfid) {
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+
+	if FILE(x.f.qid) == Qsnarfhist {
+		if n := WIN(x.f.qid); n <= len(global.snarfhist) {
+			x.f.snapshot = global.snarfhist[n-1]
+		}
+		return
+	}
+	if x.fcall.Mode&3 != plan9.OWRITE {
+		acmegetsnarf()
+		x.f.snapshot = global.snarfbuf
+	}
+	if x.fcall.Mode&3 != plan9.OREAD {
+		x.f.snarf = []byte{}
+	}
+}
+
+// xfidsnarfwrite responds to x, a write to the snarf file.
+func xfidsnarfwrite(x *Xfid) {
+	var fc plan9.Fcall
+	b := x.f.snarf
+	if b == nil {
+		x.respond(&fc, ErrPermission)
+		return
+	}
+	off := int(x.fcall.Offset)
+	if off > len(b) {
+		off = len(b)
+	}
+	x.f.snarf = append(b[:off], x.fcall.Data...)
+	fc.Count = uint32(len(x.fcall.Data))
+	x.respond(&fc, nil)
+}
+
+// xfidsnarfclose sets the snarf buffer to what was written to the snarf
+// file through x.f.
+func xfidsnarfclose(x *Xfid) {
+	b := x.f.snarf
+	x.f.snarf = nil
+	if b == nil {
+		return
+	}
+	global.row.lk.Lock()
+	defer global.row.lk.Unlock()
+	global.snarfbuf = b
+	acmeputsnarf()
+}
+
+// xfidsnarfread responds to x, a read of the snarf file or a snarf.<n>
+// file.
+func xfidsnarfread(x *Xfid) {
+	var fc plan9.Fcall
+	ninep.ReadBuffer(&fc, &x.fcall, x.f.snapshot)
+	x.respond(&fc, nil)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/edwoodtest"
+)
+
+func TestRecordsnarf(t *testing.T) {
+	defer func() {
+		global.snarfbuf = nil
+		global.snarfhist = nil
+	}()
+	global.snarfhist = nil
+
+	for _, s := range []string{"", "a", "a", "b"} {
+		global.snarfbuf = []byte(s)
+		recordsnarf()
+	}
+	if got, want := fmt.Sprintf("%q", global.snarfhist), `["b" "a"]`; got != want {
+		t.Errorf("got history %v; want %v", got, want)
+	}
+	for i := 0; i < 2*NSNARF; i++ {
+		global.snarfbuf = []byte(fmt.Sprint(i))
+		recordsnarf()
+	}
+	if got, want := len(global.snarfhist), NSNARF; got != want {
+		t.Fatalf("got %d entries in history; want %d", got, want)
+	}
+	if got, want := string(global.snarfhist[0]), fmt.Sprint(2*NSNARF-1); got != want {
+		t.Errorf("got latest entry %q; want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		n    int
+		ok   bool
+	}{
+		{"snarf.1", 1, true},
+		{"snarf.10", 10, true},
+		{"snarf.11", 0, false},
+		{"snarf.0", 0, false},
+		{"snarf.01", 0, false},
+		{"snarf.+1", 0, false},
+		{"snarf.", 0, false},
+		{"snarf", 0, false},
+	} {
+		if n, ok := snarfhistnum(tc.name); n != tc.n || ok != tc.ok {
+			t.Errorf("snarfhistnum(%q) got %v, %v; want %v, %v", tc.name, n, ok, tc.n, tc.ok)
+		}
+	}
+}
+
+func TestXfidSnarf(t *testing.T) {
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.row = Row{display: display}
+	global.snarfhist = nil
+	defer func() {
+		global.row = Row{}
+		global.snarfbuf = nil
+		global.snarfhist = nil
+	}()
+	display.WriteSnarf([]byte("from the display"))
+
+	f := &Fid{qid: plan9.Qid{Path: QID(0, Qsnarf)}}
+	rpc := func(fn func(*Xfid), fcall plan9.Fcall) *plan9.Fcall {
+		t.Helper()
+		mr := new(mockResponder)
+		fn(&Xfid{f: f, fcall: fcall, fs: mr})
+		if mr.err != nil {
+			t.Fatalf("got error %v; want nil", mr.err)
+		}
+		return mr.fcall
+	}
+
+	rpc(xfidopen, plan9.Fcall{Mode: plan9.OREAD})
+	if got, want := string(rpc(xfidread, plan9.Fcall{Count: 100}).Data), "from the display"; got != want {
+		t.Errorf("read got %q; want %q", got, want)
+	}
+	rpc(xfidclose, plan9.Fcall{})
+
+	f.busy = true
+	rpc(xfidopen, plan9.Fcall{Mode: plan9.OWRITE})
+	rpc(xfidwrite, plan9.Fcall{Data: []byte("hello, ")})
+	rpc(xfidwrite, plan9.Fcall{Offset: 7, Data: []byte("world")})
+	if got := string(global.snarfbuf); got != "from the display" {
+		t.Errorf("snarf buffer set to %q before close", got)
+	}
+	rpc(xfidclose, plan9.Fcall{})
+	if got, want := string(global.snarfbuf), "hello, world"; got != want {
+		t.Errorf("got snarf buffer %q; want %q", got, want)
+	}
+	b := make([]byte, 100)
+	if n, _, _ := display.ReadSnarf(b); string(b[:n]) != "hello, world" {
+		t.Errorf("got display snarf %q; want %q", b[:n], "hello, world")
+	}
+
+	f = &Fid{qid: plan9.Qid{Type: plan9.QTDIR, Path: QID(0, Qdir)}}
+	if found, err := f.Walk1("snarf.2"); !found || err != nil {
+		t.Fatalf("walk to snarf.2 got %v, %v", found, err)
+	}
+	rpc(xfidopen, plan9.Fcall{Mode: plan9.OREAD})
+	if got, want := string(rpc(xfidread, plan9.Fcall{Count: 100}).Data), "from the display"; got != want {
+		t.Errorf("read of snarf.2 got %q; want %q", got, want)
+	}
+}
@@ -192,6 +192,7 @@ func (w *Window) setTag1() {
 		Lredo     = " Redo"
 		Lget      = " Get"
 		Lput      = " Put"
+		Lmerge    = " Merge"
 		Llook     = " Look"
 		Ledit     = " Edit"
 		Lpipe     = " |"
@@ -219,6 +220,9 @@ func (w *Window) setTag1() {
 			sb.WriteString(Lput)
 		}
 	}
+	if w.diskchanged {
+		sb.WriteString(Lmerge)
+	}
 	// TODO(rjk): What happens if I make a directory into a file.
 	if w.body.file.IsDir() {
 		sb.WriteString(Lget)
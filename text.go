@@ -473,13 +473,13 @@ func (t *Text) logInsert(oq0 file.OffsetTuple, b []byte, nr int) {
 		if t.what == Body {
 			c = 'I'
 		}
+		e := Event{Type: string(c), Q0: q0, Q1: q0 + nr}
 		if nr <= EVENTSIZE {
 			// TODO(rjk): Does unnecessary work making a string from r if there's no
 			// event reader.
-			t.w.Eventf("%c%d %d 0 %d %s\n", c, q0, q0+nr, nr, b)
-		} else {
-			t.w.Eventf("%c%d %d 0 0 \n", c, q0, q0+nr)
+			e.Text = string(b)
 		}
+		t.w.Event(e)
 	}
 }
 
@@ -620,7 +620,7 @@ func (t *Text) logInsertDelete(q0, q1 int) {
 		if t.what == Body {
 			c = 'D'
 		}
-		t.w.Eventf("%c%d %d 0 0 \n", c, q0, q1)
+		t.w.Event(Event{Type: string(c), Q0: q0, Q1: q1})
 	}
 }
 
@@ -1041,7 +1041,9 @@ func (t *Text) Type(r rune) {
 		}
 	}
 	// Otherwise ordinary character; just insert it.
+	nc := t.file.Nr()
 	t.file.InsertAt(t.q0, rp[:nr])
+	nr = t.file.Nr() - nc // None if read-only.
 	t.SetSelect(t.q0+nr, t.q0+nr)
 
 	// Always commit if the typing is into a tag. The reason to do this is to
@@ -1260,7 +1262,7 @@ func (t *Text) Show(q0, q1 int, doselect bool) {
 	if tsd {
 		t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
 	} else {
-		if t.w.nopen[QWevent] > 0 {
+		if t.w.external() {
 			nl = 3 * t.fr.GetFrameFillStatus().Maxlines / 4
 		} else {
 			nl = t.fr.GetFrameFillStatus().Maxlines / 4
@@ -1281,6 +1283,16 @@ func (t *Text) ReadC(q int) rune {
 	return t.file.ReadC(q)
 }
 
+// LineToRune is a forwarding function for file.LineToRune.
+func (t *Text) LineToRune(l int) (int, bool) {
+	return t.file.LineToRune(l)
+}
+
+// RuneToLine is a forwarding function for file.RuneToLine.
+func (t *Text) RuneToLine(q int) int {
+	return t.file.RuneToLine(q)
+}
+
 func (t *Text) SetSelect(q0, q1 int) {
 	// log.Println("Text SetSelect Start", q0, q1)
 	// defer log.Println("Text SetSelect End", q0, q1)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rjkroege/edwood/dumpfile"
+	"github.com/rjkroege/edwood/file"
+)
+
+// undohistoryfile returns the name of the file in *undodir that holds
+// the undo history for the file name or "" if persisting undo history
+// is disabled. The absolute path of name is flattened into a single
+// path element by replacing each separator with '%'.
+func undohistoryfile(name string) string {
+	if *undodir == "" || name == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(*undodir, strings.ReplaceAll(filepath.ToSlash(abs), "/", "%"))
+}
+
+// saveundohistory writes the undo history of oeb to its undo history
+// file. Invoked after a successful Put so that the history can be
+// restored when the file is next opened.
+func saveundohistory(oeb *file.ObservableEditableBuffer) {
+	fn := undohistoryfile(oeb.Name())
+	if fn == "" || oeb.IsDirOrScratch() {
+		return
+	}
+	if err := os.MkdirAll(*undodir, 0700); err != nil {
+		warning(nil, "can't create undo history directory %s: %v\n", *undodir, err)
+		return
+	}
+	if err := oeb.History().Save(fn); err != nil {
+		warning(nil, "can't save undo history for %s: %v\n", oeb.Name(), err)
+	}
+}
+
+// loadundohistory restores the undo history of t from its undo history
+// file. Nothing is restored if t already has an undo history or if the
+// saved history doesn't match t's contents (i.e. the file was modified
+// outside of Edwood.)
+func loadundohistory(t *Text) {
+	oeb := t.file
+	if oeb.HasUndoableChanges() || oeb.HasRedoableChanges() || oeb.IsDirOrScratch() {
+		return
+	}
+	fn := undohistoryfile(oeb.Name())
+	if fn == "" {
+		return
+	}
+	h, err := file.LoadHistory(fn)
+	if err != nil {
+		return
+	}
+	h.Rebase(global.seq)
+	restoreundohistory(oeb, h)
+}
+
+// restoreundohistory replaces the undo history of oeb with h. The
+// sequence numbers in h must already have been rebased past those in
+// use.
+func restoreundohistory(oeb *file.ObservableEditableBuffer, h *file.History) error {
+	if err := oeb.RestoreHistory(h); err != nil {
+		return err
+	}
+	if s := h.MaxSeq(); s > global.seq {
+		global.seq = s
+	}
+	return nil
+}
+
+// dumphistory returns the undo history of oeb for inclusion in a dump
+// file or nil if there is nothing to undo or redo.
+func dumphistory(oeb *file.ObservableEditableBuffer) *dumpfile.History {
+	if !oeb.HasUndoableChanges() && !oeb.HasRedoableChanges() {
+		return nil
+	}
+	h := oeb.History()
+	dh := &dumpfile.History{
+		Pieces:  make([]dumpfile.HistoryPiece, 0, len(h.Pieces)),
+		Begin:   h.Begin,
+		End:     h.End,
+		Actions: make([]dumpfile.HistoryAction, 0, len(h.Actions)),
+		Path:    h.Path,
+		Head:    h.Head,
+		Saved:   h.Saved,
+		Seq:     h.Seq,
+		PutSeq:  h.PutSeq,
+	}
+	for _, p := range h.Pieces {
+		dh.Pieces = append(dh.Pieces, dumpfile.HistoryPiece(p))
+	}
+	for _, a := range h.Actions {
+		da := dumpfile.HistoryAction{
+			ID:      a.ID,
+			Parent:  a.Parent,
+			Seq:     a.Seq,
+			Time:    a.Time,
+			Kind:    a.Kind,
+			Fname:   a.Fname,
+			Changes: make([]dumpfile.HistoryChange, 0, len(a.Changes)),
+		}
+		for _, c := range a.Changes {
+			da.Changes = append(da.Changes, dumpfile.HistoryChange{
+				Off:  c.Off,
+				Roff: c.Roff,
+				Old:  dumpfile.HistorySpan(c.Old),
+				New:  dumpfile.HistorySpan(c.New),
+			})
+		}
+		dh.Actions = append(dh.Actions, da)
+	}
+	return dh
+}
+
+// loadhistory returns the undo history dh of a window of a dump file.
+func loadhistory(dh *dumpfile.History) *file.History {
+	h := &file.History{
+		Pieces:  make([]file.HistoryPiece, 0, len(dh.Pieces)),
+		Begin:   dh.Begin,
+		End:     dh.End,
+		Actions: make([]file.HistoryAction, 0, len(dh.Actions)),
+		Path:    dh.Path,
+		Head:    dh.Head,
+		Saved:   dh.Saved,
+		Seq:     dh.Seq,
+		PutSeq:  dh.PutSeq,
+	}
+	for _, p := range dh.Pieces {
+		h.Pieces = append(h.Pieces, file.HistoryPiece(p))
+	}
+	for _, da := range dh.Actions {
+		a := file.HistoryAction{
+			ID:      da.ID,
+			Parent:  da.Parent,
+			Seq:     da.Seq,
+			Time:    da.Time,
+			Kind:    da.Kind,
+			Fname:   da.Fname,
+			Changes: make([]file.HistoryChange, 0, len(da.Changes)),
+		}
+		for _, c := range da.Changes {
+			a.Changes = append(a.Changes, file.HistoryChange{
+				Off:  c.Off,
+				Roff: c.Roff,
+				Old:  file.HistorySpan(c.Old),
+				New:  file.HistorySpan(c.New),
+			})
+		}
+		h.Actions = append(h.Actions, a)
+	}
+	return h
+}
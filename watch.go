@@ -0,0 +1,285 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rjkroege/edwood/diff"
+	"github.com/rjkroege/edwood/file"
+	"github.com/rjkroege/edwood/util"
+)
+
+// Edwood watches the disk files of the windows for changes made by other
+// programs, unless started with -n. A clean window is reloaded. A dirty
+// window gets a Merge command in its tag that shows the body merged with
+// the changes on disk in a window that can be edited before the merge
+// replaces the body.
+//
+// The watcher compares the disk files with the DiskDetails recorded
+// when they were last read or written so it can't miss a change. A
+// dirnotifier makes it notice changes promptly. Without one, the disk
+// files are polled.
+
+// A dirnotifier signals changes to the files in a set of directories.
+type dirnotifier interface {
+	// watch sets the directories to watch.
+	watch(dirs map[string]bool)
+	// events returns a channel that receives a value after a change.
+	events() <-chan struct{}
+}
+
+const (
+	// How often to check the disk files without a dirnotifier.
+	pollinterval = time.Second
+	// How often to check the disk files with a dirnotifier. This updates
+	// the directories watched for new windows.
+	syncinterval = 5 * time.Second
+)
+
+// watchthread checks the disk files of the windows whenever they might
+// have changed.
+func watchthread(g *globals) {
+	n := newdirnotifier()
+	interval := pollinterval
+	var events <-chan struct{}
+	if n != nil {
+		interval = syncinterval
+		events = n.events()
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-events:
+		}
+		g.row.lk.Lock()
+		dirs, changed := checkdisks(&g.row)
+		if changed && g.row.display != nil {
+			g.row.display.Flush()
+		}
+		g.row.lk.Unlock()
+		if n != nil {
+			n.watch(dirs)
+		}
+	}
+}
+
+// checkdisks checks the disk file of every window (see checkdisk) and
+// returns the directories holding them and whether a window changed.
+// Must be called with the row lock held.
+func checkdisks(r *Row) (map[string]bool, bool) {
+	dirs := make(map[string]bool)
+	changed := false
+	seen := make(map[*file.ObservableEditableBuffer]bool)
+	r.AllWindows(func(w *Window) {
+		f := w.body.file
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+
+		// As in mousethread, w and its clones are locked.
+		w.Lock('W')
+		defer w.Unlock()
+		if f.Name() == "" || f.IsDirOrScratch() || f.ReadOnly() || f.Info() == nil {
+			return
+		}
+		dirs[filepath.Dir(f.Name())] = true
+		if checkdisk(r, w) {
+			changed = true
+		}
+	})
+	return dirs, changed
+}
+
+// sameinfo returns true if a and b describe the same unmodified file.
+func sameinfo(a, b os.FileInfo) bool {
+	return a != nil && b != nil && os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// checkdisk checks whether the disk file of w has changed since it was
+// last read or written. If so, a clean w is reloaded and a dirty w is
+// marked as diskchanged. It returns true if a window changed. Must be
+// called with w locked.
+func checkdisk(r *Row, w *Window) bool {
+	f := w.body.file
+	name := f.Name()
+	d, err := os.Stat(name)
+	if err != nil || d.IsDir() {
+		return false
+	}
+	if sameinfo(f.Info(), d) {
+		return setdiskchanged(r, f, nil)
+	}
+	if f.Dirty() && w.diskchanged && sameinfo(w.diskinfo, d) {
+		// Already noticed.
+		return false
+	}
+	if h, err := file.HashFor(name); err == nil && h.Eq(f.Hash()) {
+		// Only touched.
+		f.SetInfo(d)
+		return setdiskchanged(r, f, nil)
+	}
+
+	if f.Dirty() {
+		return setdiskchanged(r, f, d)
+	}
+	reload(w)
+	setdiskchanged(r, f, nil)
+	return true
+}
+
+// setdiskchanged records in every window on f that its disk file has
+// changed to d while the body was dirty or, if d is nil, that it
+// hasn't. It returns true if a tag changed. The windows on f must be
+// locked.
+func setdiskchanged(r *Row, f *file.ObservableEditableBuffer, d os.FileInfo) bool {
+	tagchanged := false
+	r.AllWindows(func(w *Window) {
+		if w.body.file != f {
+			return
+		}
+		changed := d != nil
+		w.diskinfo = d
+		if w.diskchanged == changed {
+			return
+		}
+		w.diskchanged = changed
+		if w.col != nil {
+			w.ForceSetWindowTag()
+			tagchanged = true
+		}
+	})
+	return tagchanged
+}
+
+// reload reads the disk file of the clean window w again, keeping the
+// selection and scroll position as far as possible.
+func reload(w *Window) {
+	t := &w.body
+	q0, q1, org := t.q0, t.q1, t.org
+	get(t, nil, nil, false, false, "")
+	nc := t.Nc()
+	t.SetSelect(util.Min(q0, nc), util.Min(q1, nc))
+	if t.fr != nil {
+		t.SetOrigin(util.Min(org, nc), true)
+	}
+}
+
+// A mergeview holds the merge shown in a +Merge window.
+type mergeview struct {
+	id   int         // Of the window whose body is merged.
+	hash file.Hash   // Of the disk file merged.
+	info os.FileInfo // Of the disk file merged.
+}
+
+// merge implements the Merge command: for a window whose disk file
+// changed while the body was dirty, it shows a three-way merge of the
+// body and the disk file, using the body as it was when last read or
+// written as the base, in the window named by the file name followed by
+// +Merge. Conflicting changes are left between conflict markers, to be
+// resolved there. Apply in the tag of the +Merge window replaces the
+// body with the merge and Discard deletes the window. The body is left
+// alone until then.
+func merge(et *Text, _ *Text, _ *Text, _, _ bool, _ string) {
+	if et == nil || et.w == nil {
+		return
+	}
+	w := et.w
+	f := w.body.file
+	name := f.Name()
+
+	fd, err := os.Open(name)
+	if err != nil {
+		warning(nil, "Merge: %v\n", err)
+		return
+	}
+	defer fd.Close()
+	d, err := fd.Stat()
+	if err != nil {
+		warning(nil, "Merge: %v\n", err)
+		return
+	}
+	disk := file.MakeObservableEditableBuffer(name, nil)
+	defer disk.Close()
+	if _, _, err := disk.Load(0, fd, true); err != nil {
+		warning(nil, "Merge: %v\n", err)
+		return
+	}
+	base, err := f.SavedBytes()
+	switch {
+	case err == file.ErrSavedUnknown:
+		warning(nil, "Merge: %s: contents when last read unknown; merging with an empty base\n", name)
+	case err != nil:
+		warning(nil, "Merge: %s: %v\n", name, err)
+		return
+	}
+	merged, conflicts := diff.Merge(diff.SplitLines(string(base)), diff.SplitLines(f.String()),
+		diff.SplitLines(disk.String()), "Edwood", name)
+
+	mname := name + "+Merge"
+	mw := lookfile(mname)
+	if mw == nil {
+		mw = makenewwindow(et)
+		mw.SetName(mname)
+		mw.tag.Insert(mw.tag.Nc(), []rune("Apply Discard "), true)
+		mw.tag.file.Clean()
+		xfidlog(mw, "new")
+	}
+	if mw != w {
+		// The window of et is already locked.
+		mw.Lock('E')
+		defer mw.Unlock()
+	}
+	mw.mergeview = &mergeview{id: w.id, hash: disk.Hash(), info: d}
+	t := &mw.body
+	t.Delete(0, t.Nc(), true)
+	t.Insert(0, []rune(strings.Join(merged, "")), true)
+	t.file.Clean()
+	t.SetSelect(0, 0)
+	t.Show(0, 0, true)
+	if conflicts > 0 {
+		warning(nil, "%s: %d conflicts in merge\n", name, conflicts)
+	}
+}
+
+// applymerge implements the Apply command of the +Merge window mw: it
+// replaces the body of the merged window with that of mw, which can be
+// undone, and deletes mw. Must be called with mw locked.
+func applymerge(mw *Window) {
+	m := mw.mergeview
+	w := global.row.LookupWin(m.id)
+	if w == nil {
+		warning(nil, "Apply: merged window deleted\n")
+		return
+	}
+	f := w.body.file
+	if h, err := file.HashFor(f.Name()); err != nil || !h.Eq(m.hash) {
+		warning(nil, "Apply: %s changed on disk since the merge; Merge again\n", f.Name())
+		return
+	}
+	merged := []rune(mw.body.file.String())
+
+	owner := mw.owner
+	mw.Unlock()
+	w.Lock('E')
+	t := &w.body
+	q0, q1 := t.q0, t.q1
+	global.seq++
+	f.Mark(global.seq)
+	t.Delete(0, t.Nc(), true)
+	t.Insert(0, merged, true)
+	nc := t.Nc()
+	t.SetSelect(util.Min(q0, nc), util.Min(q1, nc))
+	// The body now includes the changes on disk: Put may overwrite them.
+	f.SetHash(m.hash)
+	f.SetInfo(m.info)
+	setdiskchanged(&global.row, f, nil)
+	w.Unlock()
+	mw.Lock(owner)
+
+	mw.col.Close(mw, true)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// inotify is a dirnotifier implemented with Linux's inotify.
+type inotify struct {
+	fd  int
+	wds map[string]int // Watch descriptor of each directory watched.
+	c   chan struct{}
+}
+
+// newdirnotifier returns a dirnotifier or nil if it isn't possible to be
+// notified of changes.
+func newdirnotifier() dirnotifier {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil
+	}
+	in := &inotify{
+		fd:  fd,
+		wds: make(map[string]int),
+		c:   make(chan struct{}, 1),
+	}
+	go in.read()
+	return in
+}
+
+// read turns the events read from the inotify instance into values sent
+// on in.c. The events themselves aren't needed: every disk file is
+// checked after any change.
+func (in *inotify) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(in.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			select {
+			case in.c <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (in *inotify) events() <-chan struct{} {
+	return in.c
+}
+
+// Changes that can alter the contents of a file in a directory. A file
+// being written is noticed once it is closed.
+const inotifymask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_DELETE | unix.IN_ATTRIB
+
+func (in *inotify) watch(dirs map[string]bool) {
+	for d, wd := range in.wds {
+		if !dirs[d] {
+			unix.InotifyRmWatch(in.fd, uint32(wd))
+			delete(in.wds, d)
+		}
+	}
+	for d := range dirs {
+		if _, ok := in.wds[d]; ok {
+			continue
+		}
+		if wd, err := unix.InotifyAddWatch(in.fd, d, inotifymask); err == nil {
+			in.wds[d] = wd
+		}
+	}
+}
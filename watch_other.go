@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// newdirnotifier returns nil: the disk files are polled.
+func newdirnotifier() dirnotifier {
+	return nil
+}
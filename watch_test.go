@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rjkroege/edwood/file"
+)
+
+func TestCheckdisks(t *testing.T) {
+	dir := t.TempDir()
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("clean"),
+		ScBody("clean", "one\ntwo\n"),
+		ScDir(dir, "clean"),
+		ScWin("dirty"),
+		ScBody("dirty", "a\nb\nc\n"),
+		ScDir(dir, "dirty"),
+	)
+	clean, dirty := global.row.col[0].w[0], global.row.col[0].w[1]
+	for _, w := range []*Window{clean, dirty} {
+		f := w.body.file
+		d, err := os.Stat(f.Name())
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		h, err := file.HashFor(f.Name())
+		if err != nil {
+			t.Fatalf("HashFor failed: %v", err)
+		}
+		f.SetInfo(d)
+		f.SetHash(h)
+		f.Clean()
+	}
+	global.seq++
+	dirty.body.file.Mark(global.seq)
+	dirty.body.Insert(0, []rune("x\n"), true)
+
+	write := func(name, s string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		tm := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, tm, tm); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+
+	// Only touching the file changes nothing.
+	write("clean", "one\ntwo\n")
+	dirs, changed := checkdisks(&global.row)
+	if !dirs[dir] || len(dirs) != 1 {
+		t.Errorf("checkdisks returned %v; want %q", dirs, dir)
+	}
+	if changed {
+		t.Errorf("checkdisks changed a window for a touched file")
+	}
+	if clean.diskchanged || clean.body.file.HasUndoableChanges() {
+		t.Errorf("touched file reloaded")
+	}
+
+	write("clean", "one\nTWO\n")
+	write("dirty", "a\nb\nC\n")
+	if _, changed := checkdisks(&global.row); !changed {
+		t.Errorf("checkdisks didn't change a window")
+	}
+	if got, want := clean.body.file.String(), "one\nTWO\n"; got != want {
+		t.Errorf("clean window not reloaded: got %q, want %q", got, want)
+	}
+	if clean.body.file.Dirty() || clean.diskchanged {
+		t.Errorf("reloaded window is dirty or marked as changed")
+	}
+	if got, want := dirty.body.file.String(), "x\na\nb\nc\n"; got != want {
+		t.Errorf("dirty window reloaded: got %q, want %q", got, want)
+	}
+	if !dirty.diskchanged {
+		t.Errorf("dirty window not marked as changed")
+	}
+
+	// Merge shows the merge in a window, leaving the body alone.
+	global.activecol = dirty.col
+	merge(&dirty.tag, nil, nil, false, false, "")
+	mw := lookfile(dirty.body.file.Name() + "+Merge")
+	if mw == nil {
+		t.Fatalf("no +Merge window")
+	}
+	if got, want := mw.body.file.String(), "x\na\nb\nC\n"; got != want {
+		t.Errorf("merge: got %q, want %q", got, want)
+	}
+	if got, want := dirty.body.file.String(), "x\na\nb\nc\n"; got != want {
+		t.Errorf("merge changed the body to %q", got)
+	}
+	checkdisks(&global.row)
+	if !dirty.diskchanged {
+		t.Errorf("window marked as unchanged before the merge is applied")
+	}
+
+	// The merge can be edited before it is applied.
+	mw.body.Insert(0, []rune("y\n"), true)
+	global.row.lk.Lock()
+	mw.Lock('M')
+	applyx(&mw.tag, nil, nil, false, false, "")
+	global.row.lk.Unlock()
+	if global.row.LookupWin(mw.id) != nil {
+		t.Errorf("Apply left the +Merge window")
+	}
+	if got, want := dirty.body.file.String(), "y\nx\na\nb\nC\n"; got != want {
+		t.Errorf("Apply: got %q, want %q", got, want)
+	}
+	checkdisks(&global.row)
+	if dirty.diskchanged {
+		t.Errorf("merged window still marked as changed")
+	}
+	f := dirty.body.file
+	if err := putfile(f, 0, f.Nr(), f.Name()); err != nil {
+		t.Errorf("putfile after merge failed: %v", err)
+	}
+}
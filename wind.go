@@ -27,18 +27,21 @@ type Window struct {
 	autoindent bool
 	showdel    bool
 
-	id    int
-	addr  Range
-	limit Range
+	id       int
+	addr     Range
+	moreaddr []Range // Further ranges set with the addr file; see addrs.go.
+	limit    Range
 
 	nopen      [QMAX]byte // number of open Fid for each file in the file server
 	nomark     bool
 	wrselrange Range
 	rdselfd    *os.File // temporary file for rdsel read requests
 
-	col    *Column
-	eventx *Xfid
-	events []byte
+	col        *Column
+	eventx     *Xfid
+	events     []byte
+	jsoneventx *Xfid // Like eventx and events for the eventjson file.
+	jsonevents []byte
 
 	owner       int // TODO(fhs): change type to rune
 	maxlines    int
@@ -61,6 +64,17 @@ type Window struct {
 	taglines           int
 	tagtop             image.Rectangle
 
+	diskchanged bool        // the disk file changed while the body was dirty
+	diskinfo    os.FileInfo // the disk file when diskchanged was noticed
+
+	lognew   bool   // the window has an entry in the log file
+	logname  string // the name in the last entry in the log file
+	logdirty bool   // whether the last dirty or clean entry was dirty
+
+	ctltxn      *ctltxn      // the transaction begun with the ctl file, if any
+	editpreview *editpreview // the Edit command shown, if a +Preview window
+	mergeview   *mergeview   // the merge shown, if a +Merge window
+
 	editoutlk chan bool
 }
 
@@ -75,14 +89,25 @@ func NewWindow() *Window {
 
 // Initialize the headless parts of the window.
 func (w *Window) initHeadless(clone *Window) *Window {
+	global.WinID++
+	w.id = global.WinID
+	w.initUnnumbered(clone)
+	if clone == nil {
+		openjournal(w)
+	}
+	return w
+}
+
+// initUnnumbered initializes the headless parts of the window but for
+// its id and journal, which a window that is never opened, such as one
+// that F reads a file into (see loadheadless), doesn't need.
+func (w *Window) initUnnumbered(clone *Window) {
 	w.tag.w = w
 	w.taglines = 1
 	w.tagsafe = false
 	w.tagexpand = true
 	w.body.w = w
 	w.incl = []string{}
-	global.WinID++
-	w.id = global.WinID
 	w.ref.Inc()
 	if global.globalincref {
 		w.ref.Inc()
@@ -124,7 +149,6 @@ func (w *Window) initHeadless(clone *Window) *Window {
 		w.autoindent = clone.autoindent
 	}
 	w.editoutlk = make(chan bool, 1)
-	return w
 }
 
 func (w *Window) Init(clone *Window, r image.Rectangle, dis draw.Display) {
@@ -257,6 +281,12 @@ func (w *Window) TagLines(r image.Rectangle) int {
 func (w *Window) Resize(r image.Rectangle, safe, keepextra bool) int {
 	// log.Printf("Window.Resize r=%v safe=%v keepextra=%v\n", r, safe, keepextra)
 	// defer log.Println("Window.Resize End\n")
+	or := w.r
+	defer func() {
+		if w.lognew && !w.r.Eq(or) {
+			xfidlogf(w, "resize", "%d %d %d %d", w.r.Min.X, w.r.Min.Y, w.r.Max.X, w.r.Max.Y)
+		}
+	}()
 
 	// TODO(rjk): Do not leak global event state into this function.
 	mouseintag := global.mouse.Point.In(w.tag.all)
@@ -380,6 +410,10 @@ func (w *Window) Close() {
 		xfidlog(w, "del")
 		w.tag.file.DelObserver(w)
 		w.body.file.DelTagStatusObserver(w)
+		if !w.body.file.HasMultipleObservers() {
+			closejournal(w.body.file)
+			w.body.file.Close()
+		}
 		w.tag.Close()
 		w.body.Close()
 		if global.activewin == w {
@@ -395,18 +429,28 @@ func (w *Window) Delete() {
 		w.eventx = nil
 		x.c <- nil // wake him up
 	}
+	x = w.jsoneventx
+	if x != nil {
+		w.jsonevents = w.jsonevents[0:0]
+		w.jsoneventx = nil
+		x.c <- nil
+	}
 }
 
-func (w *Window) Undo(isundo bool) {
+// Undo undoes (or redoes) the most recent change to the body of w. It
+// returns false if there was nothing to undo or the body is read-only.
+func (w *Window) Undo(isundo bool) bool {
 	w.utflastqid = -1
 	body := &w.body
-	if q0, q1, ok := body.file.Undo(isundo); ok {
+	q0, q1, ok := body.file.Undo(isundo)
+	if ok {
 		body.q0, body.q1 = q0, q1
 	}
 
 	// TODO(rjk): Updates the scrollbar and selection.
 	// Be sure not to do this inside of the Undo operation's callbacks.
 	body.Show(body.q0, body.q1, true)
+	return ok
 }
 
 func (w *Window) SetName(name string) {
@@ -485,7 +529,7 @@ func (w *Window) Clean(conservative bool) bool {
 	if w.body.file.IsDirOrScratch() { // don't whine if it's a guide file, error window, etc.
 		return true
 	}
-	if !conservative && w.nopen[QWevent] > 0 {
+	if !conservative && w.external() {
 		return true
 	}
 	if w.body.file.TreatAsDirty() {
@@ -519,8 +563,9 @@ func (w *Window) CtlPrint(fonts bool) string {
 		w.body.Nc(), isdir, dirty)
 	if fonts {
 		// fsys exposes the actual physical font name.
-		buf = fmt.Sprintf("%s%11d %s %11d ", buf, w.body.fr.Rect().Dx(),
-			quote(fontget(w.body.font, w.display).Name()), w.body.fr.GetMaxtab())
+		buf = fmt.Sprintf("%s%11d %s %11d %s ", buf, w.body.fr.Rect().Dx(),
+			quote(fontget(w.body.font, w.display).Name()), w.body.fr.GetMaxtab(),
+			w.body.file.Encoding())
 	}
 	return buf
 }
@@ -552,21 +597,35 @@ func (w *Window) Eventf(format string, args ...interface{}) {
 
 // ClampAddr clamps address range based on the body buffer.
 func (w *Window) ClampAddr() {
-	if w.addr.q0 < 0 {
-		w.addr.q0 = 0
+	w.addr = w.clamp(w.addr)
+	for i, r := range w.moreaddr {
+		w.moreaddr[i] = w.clamp(r)
 	}
-	if w.addr.q1 < 0 {
-		w.addr.q1 = 0
+}
+
+func (w *Window) clamp(r Range) Range {
+	if r.q0 < 0 {
+		r.q0 = 0
+	}
+	if r.q1 < 0 {
+		r.q1 = 0
 	}
-	if w.addr.q0 > w.body.Nc() {
-		w.addr.q0 = w.body.Nc()
+	if r.q0 > w.body.Nc() {
+		r.q0 = w.body.Nc()
 	}
-	if w.addr.q1 > w.body.Nc() {
-		w.addr.q1 = w.body.Nc()
+	if r.q1 > w.body.Nc() {
+		r.q1 = w.body.Nc()
 	}
+	return r
 }
 
 func (w *Window) UpdateTag(newtagstatus file.TagStatus) {
 	// log.Printf("Window.UpdateTag, status %+v, %d", newtagstatus, global.seq)
+	if w.col == nil {
+		// A window without a display, as F reads files into, has no tag
+		// to show and isn't in the log.
+		return
+	}
 	w.setTag1()
+	w.logstatus(newtagstatus.SaveableAndDirty)
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rjkroege/edwood/diff"
+	"github.com/rjkroege/edwood/file"
+)
+
+// The diff file of a window holds the changes made to its body since it
+// was last read or written, as a unified diff of the file on disk and
+// the body. Like info.json, it is computed when the file is opened. The
+// header of each hunk ends with the file name and line of the hunk's
+// first change in the body, so that it can be looked at.
+
+// ErrNoDiskFile is the error for the diff of a window that doesn't hold
+// a file.
+var ErrNoDiskFile = fmt.Errorf("window holds no file")
+
+// bodydiff returns the diff file of w. It also returns true if the disk
+// file no longer has the contents that w recorded when it was last read
+// or written. Must be called with the window lock held.
+func bodydiff(w *Window) ([]byte, bool, error) {
+	f := w.body.file
+	name := f.Name()
+	if name == "" || f.IsDirOrScratch() {
+		return nil, false, ErrNoDiskFile
+	}
+	w.Commit(&w.body)
+
+	var disk []string
+	diskname := name
+	changed := false
+	fd, err := os.Open(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// Every line of the body is new.
+		diskname = "/dev/null"
+		changed = !f.Hash().Eq(file.EmptyHash)
+	case err != nil:
+		return nil, false, err
+	default:
+		defer fd.Close()
+		b := file.MakeObservableEditableBuffer(name, nil)
+		defer b.Close()
+		if _, _, err := b.Load(0, fd, true); err != nil {
+			return nil, false, err
+		}
+		disk = diff.SplitLines(b.String())
+		changed = !b.Hash().Eq(f.Hash())
+	}
+	return []byte(diff.Unified(diskname, name, disk, diff.SplitLines(f.String()))), changed, nil
+}
+
+// diffx implements the Diff command: it shows the diff file of the
+// window in the window named +Diff of its directory.
+func diffx(et *Text, _ *Text, _ *Text, _, _ bool, _ string) {
+	if et == nil || et.w == nil {
+		return
+	}
+	w := et.w
+	b, changed, err := bodydiff(w)
+	if err != nil {
+		warning(nil, "Diff: %v\n", err)
+		return
+	}
+	name := w.body.file.Name()
+	if changed {
+		warning(nil, "Diff: %s changed on disk since last read\n", name)
+	}
+	if len(b) == 0 {
+		warning(nil, "Diff: %s unchanged\n", name)
+		return
+	}
+
+	dname := filepath.Join(w.body.DirName(""), "+Diff")
+	dw := lookfile(dname)
+	if dw == nil {
+		dw = makenewwindow(et)
+		dw.SetName(dname)
+		xfidlog(dw, "new")
+	}
+	dw.Lock(w.owner)
+	defer dw.Unlock()
+	t := &dw.body
+	t.Delete(0, t.Nc(), true)
+	t.Insert(0, []rune(string(b)), true)
+	t.file.Clean()
+	t.SetSelect(0, 0)
+	t.Show(0, 0, true)
+}
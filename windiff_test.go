@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"9fans.net/go/plan9"
+	"github.com/rjkroege/edwood/file"
+)
+
+func TestBodydiff(t *testing.T) {
+	dir := t.TempDir()
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("a.txt"),
+		ScBody("a.txt", "one\ntwo\n"),
+		ScDir(dir, "a.txt"),
+		ScWin("b.txt"),
+		ScBody("b.txt", "new\n"),
+		ScWin("+Errors"),
+	)
+	w, nw, ew := global.row.col[0].w[0], global.row.col[0].w[1], global.row.col[0].w[2]
+	f := w.body.file
+	name := f.Name()
+	h, err := file.HashFor(name)
+	if err != nil {
+		t.Fatalf("HashFor failed: %v", err)
+	}
+	f.SetHash(h)
+	nw.body.file.SetName(filepath.Join(dir, "b.txt"))
+
+	if b, changed, err := bodydiff(w); err != nil || changed || len(b) != 0 {
+		t.Errorf("unchanged window got diff %q, %v, %v", b, changed, err)
+	}
+	w.body.Insert(w.body.Nc(), []rune("three\n"), true)
+	want := "--- " + name + "\n+++ " + name + "\n@@ -1,2 +1,3 @@ " + name + ":3\n one\n two\n+three\n"
+	if b, changed, err := bodydiff(w); err != nil || changed || string(b) != want {
+		t.Errorf("got diff %q, %v, %v; want %q", b, changed, err, want)
+	}
+	if err := os.WriteFile(name, []byte("one\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, changed, _ := bodydiff(w); !changed {
+		t.Errorf("change on disk not reported")
+	}
+
+	nname := nw.body.file.Name()
+	want = "--- /dev/null\n+++ " + nname + "\n@@ -0,0 +1 @@ " + nname + ":1\n+new\n"
+	if b, _, err := bodydiff(nw); err != nil || string(b) != want {
+		t.Errorf("new file got diff %q, %v; want %q", b, err, want)
+	}
+	if _, _, err := bodydiff(ew); err != ErrNoDiskFile {
+		t.Errorf("scratch window got error %v; want %v", err, ErrNoDiskFile)
+	}
+}
+
+func TestDiffFile(t *testing.T) {
+	dir := t.TempDir()
+	FlexiblyMakeWindowScaffold(
+		t,
+		ScWin("a.txt"),
+		ScBody("a.txt", "one\ntwo\n"),
+		ScDir(dir, "a.txt"),
+	)
+	w := global.row.col[0].w[0]
+	w.body.Insert(0, []rune("zero\n"), true)
+	want, _, err := bodydiff(w)
+	if err != nil {
+		t.Fatalf("bodydiff failed: %v", err)
+	}
+
+	mr := new(mockResponder)
+	x := &Xfid{
+		f:     &Fid{qid: plan9.Qid{Path: QID(w.id, QWdiff)}, w: w},
+		fcall: plan9.Fcall{Count: 8192},
+		fs:    mr,
+	}
+	xfidopen(x)
+	if mr.err != nil {
+		t.Fatalf("open got error %v", mr.err)
+	}
+	// The file is a snapshot as of the open.
+	w.body.Insert(0, []rune("minus one\n"), true)
+	xfidread(x)
+	if got := string(mr.fcall.Data); mr.err != nil || got != string(want) {
+		t.Errorf("read got %q, %v; want %q", got, mr.err, want)
+	}
+
+	global.activecol = w.col
+	diffx(&w.body, nil, nil, false, false, "")
+	dw := lookfile(filepath.Join(dir, "+Diff"))
+	if dw == nil {
+		t.Fatalf("no +Diff window")
+	}
+	want, _, _ = bodydiff(w)
+	if got := dw.body.file.String(); got != string(want) {
+		t.Errorf("+Diff window got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Backup policies for the files written by Put (see -B).
+const (
+	backupnone   = "none"   // Don't keep backups.
+	backuponce   = "once"   // Keep the contents before the first Put in this session.
+	backupalways = "always" // Keep the contents before the most recent Put.
+)
+
+// backedup holds the files already backed up in this session.
+var backedup = make(map[string]bool)
+
+// writefile replaces the contents of the file name, following symbolic
+// links, with the contents of r and returns the FileInfo of the result.
+//
+// An existing file is replaced atomically: the contents are written to a
+// temporary file in the same directory that gets the mode, ownership and
+// extended attributes of the file, is synced to disk and is renamed over
+// the file. If that isn't possible (e.g. the directory isn't writable,
+// the file has several links or its owner can't be kept) and inplace is
+// true, the file is rewritten in place instead.
+//
+// Before it is replaced, the file is backed up to name~ according to the
+// -B policy.
+func writefile(name string, r io.Reader, inplace bool) (os.FileInfo, error) {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	old, err := os.Stat(name)
+	if err != nil {
+		// A new file: there is nothing to lose.
+		return writeinplace(name, r, nil)
+	}
+	if !old.Mode().IsRegular() || nlinks(old) > 1 {
+		if !inplace {
+			return nil, fmt.Errorf("can't replace %s atomically", name)
+		}
+		return writeinplace(name, r, old)
+	}
+
+	fd, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err == nil {
+		if err = copyattrs(fd, name, old); err != nil {
+			fd.Close()
+			os.Remove(fd.Name())
+		}
+	}
+	if err != nil {
+		if !inplace {
+			return nil, err
+		}
+		return writeinplace(name, r, old)
+	}
+	tmp := fd.Name()
+	defer os.Remove(tmp)
+	defer fd.Close()
+
+	if _, err := io.Copy(fd, r); err != nil {
+		return nil, err
+	}
+	if err := fd.Sync(); err != nil {
+		return nil, err
+	}
+	if err := backup(name, old, true); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return nil, err
+	}
+	syncdir(filepath.Dir(name))
+	return fd.Stat()
+}
+
+// writeinplace truncates the file name (with FileInfo old, nil if it
+// doesn't exist) and writes the contents of r to it.
+func writeinplace(name string, r io.Reader, old os.FileInfo) (os.FileInfo, error) {
+	if old != nil {
+		if err := backup(name, old, false); err != nil {
+			return nil, err
+		}
+	}
+	fd, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	if _, err := io.Copy(fd, r); err != nil {
+		return nil, err
+	}
+	if err := fd.Sync(); err != nil && old != nil && old.Mode().IsRegular() {
+		return nil, err
+	}
+	if old == nil {
+		syncdir(filepath.Dir(name))
+	}
+	return fd.Stat()
+}
+
+// backup keeps the contents of the file name with FileInfo old in name~
+// if the -B policy asks for it. If link is true, name is about to be
+// replaced by a new file rather than rewritten so name~ can be a link to
+// it instead of a copy.
+func backup(name string, old os.FileInfo, link bool) error {
+	switch *backupflag {
+	case backupalways:
+	case backuponce:
+		if backedup[name] {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if !old.Mode().IsRegular() {
+		return nil
+	}
+
+	bak := name + "~"
+	os.Remove(bak)
+	if !link || os.Link(name, bak) != nil {
+		if err := copyfile(bak, name, old); err != nil {
+			return fmt.Errorf("can't back up to %s: %v", bak, err)
+		}
+	}
+	backedup[name] = true
+	return nil
+}
+
+// copyfile copies the file src with FileInfo fi to dst.
+func copyfile(dst, src string, fi os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// syncdir syncs the directory dir so that a new entry in it survives a
+// crash. Not all systems can sync directories so errors are ignored.
+func syncdir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
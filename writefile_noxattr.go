@@ -0,0 +1,13 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import (
+	"os"
+)
+
+// copyxattrs does nothing: extended attributes aren't supported.
+func copyxattrs(fd *os.File, name string) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import (
+	"os"
+)
+
+// nlinks returns 1: hard links aren't detected.
+func nlinks(fi os.FileInfo) uint64 {
+	return 1
+}
+
+// copyattrs gives the new file fd the mode of the file name described
+// by fi.
+func copyattrs(fd *os.File, name string, fi os.FileInfo) error {
+	return fd.Chmod(fi.Mode().Perm())
+}
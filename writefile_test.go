@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func checkContents(t *testing.T, name, want string) {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(b) != want {
+		t.Errorf("%s contains %q; want %q", name, b, want)
+	}
+}
+
+func TestWritefile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "f")
+
+	// A new file.
+	if _, err := writefile(name, strings.NewReader("one"), true); err != nil {
+		t.Fatalf("writefile failed: %v", err)
+	}
+	checkContents(t, name, "one")
+
+	if err := os.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := writefile(name, strings.NewReader("two"), true)
+	if err != nil {
+		t.Fatalf("writefile failed: %v", err)
+	}
+	checkContents(t, name, "two")
+	after, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Errorf("file rewritten in place instead of replaced")
+	}
+	if !os.SameFile(d, after) {
+		t.Errorf("writefile returned the FileInfo of another file")
+	}
+	if got := after.Mode().Perm(); got != 0640 {
+		t.Errorf("mode is %v; want %v", got, os.FileMode(0640))
+	}
+	if des, _ := os.ReadDir(dir); len(des) != 1 {
+		t.Errorf("temporary files left behind: %v", des)
+	}
+}
+
+func TestWritefileLinks(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("links aren't supported")
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Symbolic links are followed.
+	symlink := filepath.Join(dir, "symlink")
+	if err := os.Symlink(target, symlink); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writefile(symlink, strings.NewReader("new"), true); err != nil {
+		t.Fatalf("writefile failed: %v", err)
+	}
+	if fi, err := os.Lstat(symlink); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink replaced: %v", err)
+	}
+	checkContents(t, target, "new")
+
+	// Hard links are kept by writing in place.
+	hardlink := filepath.Join(dir, "hardlink")
+	if err := os.Link(target, hardlink); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writefile(target, strings.NewReader("newer"), false); err == nil {
+		t.Errorf("writefile replaced a file with several links")
+	}
+	if _, err := writefile(target, strings.NewReader("newer"), true); err != nil {
+		t.Fatalf("writefile failed: %v", err)
+	}
+	checkContents(t, hardlink, "newer")
+}
+
+func TestWritefileBackup(t *testing.T) {
+	defer func(old string) { *backupflag = old }(*backupflag)
+	defer func(old map[string]bool) { backedup = old }(backedup)
+
+	for _, tc := range []struct {
+		policy string
+		want   string // Contents of name~ after the last write.
+	}{
+		{backupnone, ""},
+		{backuponce, "1"},
+		{backupalways, "2"},
+	} {
+		*backupflag = tc.policy
+		backedup = make(map[string]bool)
+		name := filepath.Join(t.TempDir(), "f")
+		for _, s := range []string{"1", "2", "3"} {
+			if _, err := writefile(name, strings.NewReader(s), true); err != nil {
+				t.Fatalf("%s: writefile failed: %v", tc.policy, err)
+			}
+		}
+		checkContents(t, name, "3")
+		if tc.want == "" {
+			if _, err := os.Stat(name + "~"); !os.IsNotExist(err) {
+				t.Errorf("%s: backup made", tc.policy)
+			}
+			continue
+		}
+		checkContents(t, name+"~", tc.want)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// nlinks returns the number of hard links to the file described by fi.
+func nlinks(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// copyattrs gives the new file fd the ownership, mode and extended
+// attributes of the file name described by fi.
+func copyattrs(fd *os.File, name string, fi os.FileInfo) error {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		nfi, err := fd.Stat()
+		if err != nil {
+			return err
+		}
+		if nst, ok := nfi.Sys().(*syscall.Stat_t); !ok || nst.Uid != st.Uid || nst.Gid != st.Gid {
+			if err := fd.Chown(int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+		}
+	}
+	// After Chown, which can clear the setuid and setgid bits.
+	if err := fd.Chmod(fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+		return err
+	}
+	return copyxattrs(fd, name)
+}
@@ -0,0 +1,41 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyxattrs copies the extended attributes of the file name to fd.
+func copyxattrs(fd *os.File, name string) error {
+	sz, err := unix.Listxattr(name, nil)
+	if err != nil || sz == 0 {
+		// Not supported or none to copy.
+		return nil
+	}
+	buf := make([]byte, sz)
+	if sz, err = unix.Listxattr(name, buf); err != nil {
+		return err
+	}
+	for _, attr := range strings.Split(string(buf[:sz]), "\x00") {
+		if attr == "" {
+			continue
+		}
+		vsz, err := unix.Getxattr(name, attr, nil)
+		if err != nil {
+			return err
+		}
+		val := make([]byte, vsz)
+		if vsz, err = unix.Getxattr(name, attr, val); err != nil {
+			return err
+		}
+		if err := unix.Fsetxattr(int(fd.Fd()), attr, val[:vsz], 0); err != nil && err != unix.ENOTSUP {
+			return err
+		}
+	}
+	return nil
+}
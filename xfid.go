@@ -11,6 +11,7 @@ import (
 
 	"9fans.net/go/plan9"
 	"github.com/rjkroege/edwood/draw"
+	"github.com/rjkroege/edwood/file"
 	"github.com/rjkroege/edwood/ninep"
 	"github.com/rjkroege/edwood/runes"
 	"github.com/rjkroege/edwood/util"
@@ -26,6 +27,7 @@ var (
 	ErrAddrRange  = fmt.Errorf("address out of range")
 	ErrInUse      = fmt.Errorf("already in use")
 	ErrBadEvent   = fmt.Errorf("bad event syntax")
+	ErrReadOnly   = fmt.Errorf("window is read-only")
 )
 
 func (x *Xfid) respond(t *plan9.Fcall, err error) *Xfid {
@@ -49,6 +51,7 @@ func xfidflush(x *Xfid) {
 	// defer log.Println("done xfidflush")
 
 	xfidlogflush(x)
+	eventjsonlog.xfidflush(x)
 
 	// search windows for matching tag
 	global.row.lk.Lock()
@@ -57,13 +60,21 @@ func xfidflush(x *Xfid) {
 		for _, w := range c.w {
 			w.Lock('E')
 			wx := w.eventx
-			if wx != nil && wx.fcall.Tag == x.fcall.Oldtag {
+			if wx != nil && wx.fs == x.fs && wx.fcall.Tag == x.fcall.Oldtag {
 				w.eventx = nil
 				wx.flushed = true
 				wx.c <- nil
 				w.Unlock()
 				goto out
 			}
+			wx = w.jsoneventx
+			if wx != nil && wx.fs == x.fs && wx.fcall.Tag == x.fcall.Oldtag {
+				w.jsoneventx = nil
+				wx.flushed = true
+				wx.c <- nil
+				w.Unlock()
+				goto out
+			}
 			w.Unlock()
 		}
 	}
@@ -84,20 +95,36 @@ func xfidopen(x *Xfid) {
 
 	w := x.f.w
 	q := FILE(x.f.qid)
-	if w != nil {
+	if w != nil && q == QWinfojson {
+		// Like xfidclose, lock the row before the window.
+		global.row.lk.Lock()
+		w.Lock('E')
+		x.f.snapshot = infojson(w)
+		w.Unlock()
+		global.row.lk.Unlock()
+	} else if w != nil {
 		t := &w.body
 		w.Lock('E')
 		switch q {
 		case QWaddr:
 			if w.nopen[q] == 0 {
 				w.addr = Range{0, 0}
+				w.moreaddr = nil
 				w.limit = Range{-1, -1}
 			}
 			w.nopen[q]++
 		case QWdata, QWxdata:
 			w.nopen[q]++
-		case QWevent:
-			if w.nopen[q] == 0 {
+		case QWdiff:
+			b, _, err := bodydiff(w)
+			if err != nil {
+				w.Unlock()
+				x.respond(&fc, err)
+				return
+			}
+			x.f.snapshot = b
+		case QWevent, QWeventjson:
+			if !w.external() {
 				if !w.body.file.IsDir() && w.col != nil {
 					w.filemenu = false
 				}
@@ -157,8 +184,14 @@ func xfidopen(x *Xfid) {
 		w.Unlock()
 	} else {
 		switch q {
+		case Qindexjson:
+			x.f.snapshot = indexjson()
+		case Qsnarf, Qsnarfhist:
+			xfidsnarfopen(x)
 		case Qlog:
 			xfidlogopen(x)
+		case Qeventjson:
+			eventjsonlog.open()
 		case Qeditout:
 			select {
 			case global.editoutlk <- true:
@@ -178,10 +211,12 @@ func xfidclose(x *Xfid) {
 	// log.Println("xfidclose", x)
 	// defer log.Println("xfidclose done")
 	var fc plan9.Fcall
+	var err error
 
 	w := x.f.w
 	x.f.busy = false
 	x.f.w = nil
+	x.f.col = nil
 	if !x.f.open {
 		if w != nil {
 			w.Close()
@@ -192,6 +227,7 @@ func xfidclose(x *Xfid) {
 
 	q := FILE(x.f.qid)
 	x.f.open = false
+	x.f.snapshot = nil
 	if w != nil {
 		// We need to lock row here before locking window (just like mousethread)
 		// in order to synchronize mousetext with mousethread: mousetext is
@@ -204,30 +240,45 @@ func xfidclose(x *Xfid) {
 				w.ctlfid = MaxFid
 				w.ctrllock.Unlock()
 			}
+			if txn := w.ctltxn; txn != nil && txn.fid == x.f.fid {
+				w.aborttxn()
+			}
 		case QWdata, QWxdata:
-			w.nomark = false
+			if w.ctltxn == nil {
+				w.nomark = false
+			}
 			fallthrough
 		case QWaddr:
 			fallthrough
-		case QWevent: // BUG: do we need to shut down Xfid?
+		case QWevent, QWeventjson: // BUG: do we need to shut down Xfid?
 			w.nopen[q]--
 			if w.nopen[q] == 0 {
-				if q == QWdata || q == QWxdata {
+				if (q == QWdata || q == QWxdata) && w.ctltxn == nil {
 					w.nomark = false
 				}
-				if q == QWevent && !w.body.file.IsDir() && w.col != nil {
-					w.filemenu = true
+				if q == QWeventjson {
+					w.jsonevents = nil
 				}
-				if q == QWevent {
+				if (q == QWevent || q == QWeventjson) && !w.external() {
+					if !w.body.file.IsDir() && w.col != nil {
+						w.filemenu = true
+					}
 					w.dumpstr = ""
 					w.dumpdir = ""
 				}
 			}
+		case QWlines:
+			if x.f.lines != nil && w.col != nil {
+				err = lineswrite(w, x.f.lines)
+			}
+			x.f.lines = nil
 		case QWrdsel:
 			w.rdselfd.Close()
 			w.rdselfd = nil
 		case QWwrsel:
-			w.nomark = false
+			if w.ctltxn == nil {
+				w.nomark = false
+			}
 			t := &w.body
 			t.Show(util.Min(w.wrselrange.q0, t.Nc()), util.Min(w.wrselrange.q1, t.Nc()), true)
 			t.ScrDraw(t.fr.GetFrameFillStatus().Nchars)
@@ -241,9 +292,15 @@ func xfidclose(x *Xfid) {
 		switch q {
 		case Qeditout:
 			<-global.editoutlk
+		case Qeventjson:
+			eventjsonlog.close()
+		case Qlog:
+			xfidlogclose(x)
+		case Qsnarf:
+			xfidsnarfclose(x)
 		}
 	}
-	x.respond(&fc, nil)
+	x.respond(&fc, err)
 }
 
 // xfidread responds to a plan9.Tread request.
@@ -262,6 +319,17 @@ func xfidread(x *Xfid) {
 		case Qindex:
 			xfidindexread(x)
 			return
+		case Qindexjson:
+			ninep.ReadBuffer(&fc, &x.fcall, x.f.snapshot)
+		case Qctl, QCctl, QCindex, QCtag:
+			xfidlayoutread(x)
+			return
+		case Qsnarf, Qsnarfhist:
+			xfidsnarfread(x)
+			return
+		case Qeventjson:
+			eventjsonlog.xfidread(x)
+			return
 		case Qlog:
 			xfidlogread(x)
 			return
@@ -283,8 +351,7 @@ func xfidread(x *Xfid) {
 	case QWaddr:
 		w.body.Commit()
 		w.ClampAddr()
-		buf := fmt.Sprintf("%11d %11d ", w.addr.q0, w.addr.q1)
-		ninep.ReadString(&fc, &x.fcall, buf)
+		ninep.ReadString(&fc, &x.fcall, w.addrstring())
 		x.respond(&fc, nil)
 
 	case QWbody:
@@ -295,9 +362,36 @@ func xfidread(x *Xfid) {
 		x.respond(&fc, nil)
 
 	case QWevent:
-		xfideventread(x, w)
+		xfideventread(x, w, &w.events, &w.eventx)
+
+	case QWeventjson:
+		xfideventread(x, w, &w.jsonevents, &w.jsoneventx)
+
+	case QWhistory:
+		ninep.ReadString(&fc, &x.fcall, w.body.file.UndoTree())
+		x.respond(&fc, nil)
+
+	case QWdiff, QWinfojson:
+		ninep.ReadBuffer(&fc, &x.fcall, x.f.snapshot)
+		x.respond(&fc, nil)
+
+	case QWlines:
+		w.body.Commit()
+		b := linesread(&w.body, int(off), int(x.fcall.Count))
+		fc.Count = uint32(len(b))
+		fc.Data = b
+		x.respond(&fc, nil)
+
+	case QWmarks:
+		w.body.Commit()
+		ninep.ReadString(&fc, &x.fcall, marksread(w))
+		x.respond(&fc, nil)
 
 	case QWdata:
+		if len(w.moreaddr) > 0 {
+			xfidaddrsread(x, w)
+			break
+		}
 		// BUG: what should happen if q1 > q0?
 		if w.addr.q0 > w.body.Nc() {
 			x.respond(&fc, ErrAddrRange)
@@ -307,6 +401,10 @@ func xfidread(x *Xfid) {
 		w.addr.q1 = w.addr.q0
 
 	case QWxdata:
+		if len(w.moreaddr) > 0 {
+			xfidaddrsread(x, w)
+			break
+		}
 		// BUG: what should happen if q1 > q0?
 		if w.addr.q0 > w.body.Nc() {
 			x.respond(&fc, ErrAddrRange)
@@ -389,6 +487,16 @@ func xfidwrite(x *Xfid) {
 			x.respond(&fc, ErrDeletedWin)
 			return
 		}
+		// The body ignores changes if read-only: fail writes that would
+		// make them instead.
+		if w.body.file.ReadOnly() {
+			switch qid {
+			case QWbody, QWdata, QWxdata, QWwrsel, QWlines:
+				w.Unlock()
+				x.respond(&fc, ErrReadOnly)
+				return
+			}
+		}
 	}
 	x.fcall.Count = uint32(len(x.fcall.Data))
 
@@ -449,22 +557,29 @@ func xfidwrite(x *Xfid) {
 		fc.Count = x.fcall.Count
 		x.respond(&fc, nil)
 
+	case Qeventjson:
+		xfidglobaleventjsonwrite(x)
+
+	case Qlog:
+		xfidlogwrite(x)
+
+	case Qctl, QCctl, QCtag:
+		xfidlayoutwrite(x)
+
+	case Qsnarf:
+		xfidsnarfwrite(x)
+
 	case QWaddr:
 		r := []rune(string(x.fcall.Data))
 		t := &w.body
 		w.Commit(t)
-		eval := true
-		a, eval, nr := address(false, t, w.limit, w.addr, 0, len(r),
-			func(q int) rune { return r[q] }, eval)
-		if nr < len(r) {
-			x.respond(&fc, ErrBadAddr)
-			break
-		}
-		if !eval {
-			x.respond(&fc, ErrAddrRange)
+		rs, err := parseaddrs(t, w.limit, w.addr, r)
+		if err != nil {
+			x.respond(&fc, err)
 			break
 		}
-		w.addr = a
+		w.addr = rs[0]
+		w.moreaddr = rs[1:]
 		fc.Count = x.fcall.Count
 		x.respond(&fc, nil)
 
@@ -493,7 +608,32 @@ func xfidwrite(x *Xfid) {
 	case QWctl:
 		xfidctlwrite(x, w)
 
+	case QWlines:
+		if err := linesgather(x.f, int(x.fcall.Offset), x.fcall.Data[:x.fcall.Count]); err != nil {
+			x.respond(&fc, err)
+			break
+		}
+		fc.Count = x.fcall.Count
+		x.respond(&fc, nil)
+
+	case QWmarks:
+		if err := markswrite(w, x.fcall.Data[:x.fcall.Count]); err != nil {
+			x.respond(&fc, err)
+			break
+		}
+		fc.Count = x.fcall.Count
+		x.respond(&fc, nil)
+
 	case QWdata:
+		if len(w.moreaddr) > 0 {
+			if err := addrswrite(w, x.fcall.Data[:x.fcall.Count]); err != nil {
+				x.respond(&fc, err)
+				break
+			}
+			fc.Count = x.fcall.Count
+			x.respond(&fc, nil)
+			break
+		}
 		a := w.addr
 		t := &w.body
 		w.Commit(t)
@@ -533,6 +673,9 @@ func xfidwrite(x *Xfid) {
 	case QWevent:
 		xfideventwrite(x, w)
 
+	case QWeventjson:
+		xfideventjsonwrite(x, w)
+
 	case QWtag:
 		updateText(&w.tag)
 
@@ -562,6 +705,11 @@ forloop:
 			err = ErrDeletedWin
 			break
 		}
+		if w != nil && w.ctltxn != nil {
+			if err = txnrefused(words[0]); err != nil {
+				break
+			}
+		}
 
 		switch words[0] {
 		case "": // empty line.
@@ -580,6 +728,18 @@ forloop:
 			err = ErrBadCtl
 			break forloop
 
+		case "begin": // begin transaction
+			if err = w.begintxn(x.f.fid); err != nil {
+				break forloop
+			}
+		case "commit": // commit transaction
+			if err = w.committxn(); err != nil {
+				break forloop
+			}
+		case "abort": // roll back transaction
+			if err = w.aborttxn(); err != nil {
+				break forloop
+			}
 		case "clean": // mark window 'clean', seq=0
 			t := &w.body
 			t.eq0 = ^0
@@ -660,6 +820,7 @@ forloop:
 		case "addr=dot": // set addr
 			w.addr.q0 = w.body.q0
 			w.addr.q1 = w.body.q1
+			w.moreaddr = nil
 		case "limit=addr": // set limit
 			w.body.Commit()
 			w.ClampAddr()
@@ -701,6 +862,18 @@ forloop:
 				break forloop
 			}
 			fontx(&w.body, nil, nil, XXX, XXX, string(r))
+		case "encoding": // set encoding of disk file
+			if len(words) < 2 {
+				err = ErrBadCtl
+				break forloop
+			}
+			if err = setencoding(w, words[1]); err != nil {
+				break forloop
+			}
+		case "crlf", "lf": // set line endings of disk file
+			setdiskformat(w, func(f *file.ObservableEditableBuffer) { f.SetCRLF(words[0] == "crlf") })
+		case "bom", "nobom": // set byte order mark of disk file
+			setdiskformat(w, func(f *file.ObservableEditableBuffer) { f.SetBOM(words[0] == "bom") })
 		default:
 			err = ErrBadCtl
 			break forloop
@@ -713,6 +886,9 @@ forloop:
 
 	if err != nil {
 		n = 0
+		if w != nil && w.ctltxn != nil {
+			w.aborttxn()
+		}
 	}
 	fc := plan9.Fcall{
 		Count: uint32(n),
@@ -728,19 +904,6 @@ forloop:
 func xfideventwrite(x *Xfid, w *Window) {
 	var err error
 
-	// We can't lock row while we have a window locked
-	// because that can create deadlock with mousethread.
-	rowLock := func() {
-		defer w.Lock(w.owner)
-		w.Unlock() // sets w.owner to 0
-		global.row.lk.Lock()
-	}
-	rowUnlock := func() {
-		defer w.Lock(w.owner)
-		w.Unlock() // sets w.owner to 0
-		global.row.lk.Unlock()
-	}
-
 	// The messages have a fixed format: a character indicating the
 	// origin or cause of the action, a character indicating
 	// the type of the action, four free-format blank-terminated
@@ -751,7 +914,6 @@ func xfideventwrite(x *Xfid, w *Window) {
 	// text, which may itself contain newlines.
 	// %c%c%d %d %d %d %s\n
 	lines := strings.Split(string(x.fcall.Data), "\n")
-forloop:
 	for _, events := range lines {
 		if events == "" {
 			continue
@@ -760,7 +922,7 @@ forloop:
 			err = ErrBadEvent
 			break
 		}
-		w.owner = int(events[0])
+		owner := int(events[0])
 		c := events[1]
 		words := strings.Fields(events[2:])
 		if len(words) < 2 {
@@ -781,33 +943,9 @@ forloop:
 		}
 		q1 := int(num)
 
-		var t *Text
-		switch {
-		case 'a' <= c && c <= 'z':
-			t = &w.tag
-		case 'A' <= c && c <= 'Z':
-			t = &w.body
-		default:
-			err = ErrBadEvent
-			break forloop
-		}
-		if q0 > t.Nc() || q1 > t.Nc() || q0 > q1 {
-			err = ErrBadEvent
+		if err = performevent(w, owner, c, q0, q1); err != nil {
 			break
 		}
-
-		rowLock() // just like mousethread
-		switch c {
-		case 'x', 'X':
-			execute(t, q0, q1, true, nil)
-		case 'l', 'L':
-			look3(t, q0, q1, true)
-		default:
-			rowUnlock()
-			err = ErrBadEvent
-			break forloop
-		}
-		rowUnlock()
 	}
 
 	var fc plan9.Fcall
@@ -819,6 +957,50 @@ forloop:
 	x.respond(&fc, err)
 }
 
+// performevent performs the look or execute action of type c on
+// [q0, q1) in w that a client wrote back to an event file. Must be
+// called with the window lock held.
+func performevent(w *Window, owner int, c byte, q0, q1 int) error {
+	// We can't lock row while we have a window locked
+	// because that can create deadlock with mousethread.
+	rowLock := func() {
+		defer w.Lock(w.owner)
+		w.Unlock() // sets w.owner to 0
+		global.row.lk.Lock()
+	}
+	rowUnlock := func() {
+		defer w.Lock(w.owner)
+		w.Unlock() // sets w.owner to 0
+		global.row.lk.Unlock()
+	}
+
+	w.owner = owner
+	var t *Text
+	switch {
+	case 'a' <= c && c <= 'z':
+		t = &w.tag
+	case 'A' <= c && c <= 'Z':
+		t = &w.body
+	default:
+		return ErrBadEvent
+	}
+	if q0 > t.Nc() || q1 > t.Nc() || q0 > q1 {
+		return ErrBadEvent
+	}
+
+	rowLock() // just like mousethread
+	defer rowUnlock()
+	switch c {
+	case 'x', 'X':
+		execute(t, q0, q1, true, nil)
+	case 'l', 'L':
+		look3(t, q0, q1, true)
+	default:
+		return ErrBadEvent
+	}
+	return nil
+}
+
 // xfidutfread reads x.fcall.Count bytes from offset x.fcall.Offset in
 // text t and sends the data to the client. It only sends full runes,
 // and optimizes for sequential reads by keeping track of (byte offset,
@@ -901,67 +1083,75 @@ func xfidruneread(x *Xfid, t *Text, q0 int, q1 int) int {
 	// defer log.Println("done xfidruneread")
 
 	t.w.Commit(t)
+	buf, nr := runeread(t, q0, q1, int(x.fcall.Count))
 
+	fc := plan9.Fcall{
+		Count: uint32(len(buf)),
+		Data:  buf,
+	}
+	x.respond(&fc, nil)
+	return nr
+}
+
+// runeread returns the UTF-8 encoding of as many whole runes from address
+// q0,q1 in t as fit in count bytes, and the number of runes.
+func runeread(t *Text, q0, q1, count int) ([]byte, int) {
 	// Get Count runes, but that might be larger than Count bytes
-	nr := util.Min(q1-q0, int(x.fcall.Count))
+	nr := util.Min(q1-q0, count)
 	tmp := make([]rune, nr)
 	t.file.Read(q0, tmp)
 	buf := []byte(string(tmp))
 
 	m := len(buf)
-	if len(buf) > int(x.fcall.Count) {
+	if len(buf) > count {
 		// copy whole runes only
 		m = 0
 		nr = 0
 		for m < len(buf) {
 			_, size := utf8.DecodeRune(buf[m:])
-			if m+size > int(x.fcall.Count) {
+			if m+size > count {
 				break
 			}
 			m += size
 			nr++
 		}
 	}
-	buf = buf[:m]
-
-	fc := plan9.Fcall{
-		Count: uint32(len(buf)),
-		Data:  buf,
-	}
-	x.respond(&fc, nil)
-	return nr
+	return buf[:m], nr
 }
 
-func xfideventread(x *Xfid, w *Window) {
+// xfideventread responds to x with the waiting events, first waiting
+// for one if there are none. events and eventx are the queue and the
+// waiting read of the event or eventjson file of w.
+func xfideventread(x *Xfid, w *Window, events *[]byte, eventx **Xfid) {
 	// log.Println("xfideventread", x)
 	// defer log.Println("done xfideventread")
 	var fc plan9.Fcall
 
 	i := 0
 	x.flushed = false
-	for len(w.events) == 0 {
+	for len(*events) == 0 {
 		if i != 0 {
 			if !x.flushed {
 				x.respond(&fc, fmt.Errorf("window shut down"))
 			}
 			return
 		}
-		w.eventx = x
+		*eventx = x
 		w.Unlock()
 		<-x.c
 		w.Lock('F')
 		i++
 	}
 
-	n := len(w.events)
+	n := len(*events)
 	if uint32(n) > x.fcall.Count {
 		n = int(x.fcall.Count)
 	}
 	fc.Count = uint32(n)
-	fc.Data = w.events[:n]
+	fc.Data = (*events)[:n]
 	x.respond(&fc, nil)
 
-	w.events = w.events[n:]
+	*events = (*events)[n:]
 }
 
 func xfidindexread(x *Xfid) {
@@ -970,7 +1160,7 @@ func xfidindexread(x *Xfid) {
 
 	// BUG(fhs): This is broken when the client is doing a sequential
 	// read using a very small buffer and we create/delete windows
-	// in-between the requests.
+	// in-between the requests. index.json doesn't have this problem.
 
 	global.row.lk.Lock()
 	nmax := 0
@@ -983,23 +1173,7 @@ func xfidindexread(x *Xfid) {
 	nmax++
 	var sb strings.Builder
 	for _, c := range global.row.col {
-		for _, w := range c.w {
-			// only show the currently active window of a set
-			if w.body.file.GetCurObserver().(*Text) != &w.body {
-				continue
-			}
-			sb.WriteString(w.CtlPrint(false))
-			m := util.Min(BUFSIZE/utf8.UTFMax, w.tag.Nc())
-			tag := make([]rune, m)
-			w.tag.file.Read(0, tag)
-
-			// We only include first line of a multi-line tag
-			if i := runes.IndexRune(tag, '\n'); i >= 0 {
-				tag = tag[:i]
-			}
-			sb.WriteString(string(tag))
-			sb.WriteString("\n")
-		}
+		writeindex(&sb, c)
 	}
 	global.row.lk.Unlock()
 
@@ -1007,3 +1181,25 @@ func xfidindexread(x *Xfid) {
 	ninep.ReadString(&fc, &x.fcall, sb.String())
 	x.respond(&fc, nil)
 }
+
+// writeindex writes the lines of the index file for the windows of c to
+// sb. Must be called with the row lock held.
+func writeindex(sb *strings.Builder, c *Column) {
+	for _, w := range c.w {
+		// only show the currently active window of a set
+		if w.body.file.GetCurObserver().(*Text) != &w.body {
+			continue
+		}
+		sb.WriteString(w.CtlPrint(false))
+		m := util.Min(BUFSIZE/utf8.UTFMax, w.tag.Nc())
+		tag := make([]rune, m)
+		w.tag.file.Read(0, tag)
+
+		// We only include first line of a multi-line tag
+		if i := runes.IndexRune(tag, '\n'); i >= 0 {
+			tag = tag[:i]
+		}
+		sb.WriteString(string(tag))
+		sb.WriteString("\n")
+	}
+}
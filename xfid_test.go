@@ -603,6 +603,138 @@ func TestXfidwriteQWdata(t *testing.T) {
 	}
 }
 
+func TestXfidwriteQWlines(t *testing.T) {
+	display := edwoodtest.NewDisplay(image.Rectangle{})
+	global.configureGlobals(display)
+
+	const body = "one\ntwo\nthree\n"
+	long := strings.Repeat("0123456789abcdef\n", 1000)
+	for _, tc := range []struct {
+		name     string   // test name
+		data     []string // data to write, in successive messages
+		err      error    // error response to the write
+		clunkerr error    // error response to the clunk
+		body     string   // resulting body
+	}{
+		{"Replace", []string{"2,2 deux\n"}, nil, nil, "one\ndeux\nthree\n"},
+		{"OneLine", []string{"1 un\n"}, nil, nil, "un\ntwo\nthree\n"},
+		{"Several", []string{"1,2 un\ndeux\ntrois\n"}, nil, nil, "un\ndeux\ntrois\nthree\n"},
+		{"Delete", []string{"2,3"}, nil, nil, "one\n"},
+		{"Insert", []string{"2,1 half\n"}, nil, nil, "one\nhalf\ntwo\nthree\n"},
+		{"Append", []string{"4,3 four\n"}, nil, nil, "one\ntwo\nthree\nfour\n"},
+		{"NewlineSeparator", []string{"3\nTHREE\n"}, nil, nil, "one\ntwo\nTHREE\n"},
+		{"Messages", []string{"2", ",2 de", "ux\n"}, nil, nil, "one\ndeux\nthree\n"},
+		{"LargerThanMessage", []string{"2,2 " + long[:8000], long[8000:]}, nil, nil, "one\n" + long + "three\n"},
+		{"BadSyntax", []string{"x,2 a\n"}, ErrBadLines, nil, body},
+		{"BadEnd", []string{"1,y a\n"}, ErrBadLines, nil, body},
+		{"Zero", []string{"0 a\n"}, nil, ErrAddrRange, body},
+		{"Backwards", []string{"3,1 a\n"}, nil, ErrAddrRange, body},
+		{"PastEnd", []string{"2,5 a\n"}, nil, ErrAddrRange, body},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := new(mockResponder)
+			w := NewWindow().initHeadless(nil)
+			w.col = new(Column)
+			w.col.safe = true
+			w.display = display
+			w.body.fr = &MockFrame{}
+			w.body.display = display
+			w.body.file = file.MakeObservableEditableBuffer("", []rune(body))
+			w.tag.fr = &MockFrame{}
+			w.tag.display = display
+
+			f := &Fid{
+				qid:  plan9.Qid{Path: QID(0, QWlines)},
+				w:    w,
+				open: true,
+			}
+			w.ref.Inc() // As by the open.
+			off := 0
+			for _, data := range tc.data {
+				xfidwrite(&Xfid{
+					fcall: plan9.Fcall{
+						Data:   []byte(data),
+						Count:  uint32(len(data)),
+						Offset: uint64(off),
+					},
+					f:  f,
+					fs: mr,
+				})
+				if mr.err != nil {
+					break
+				}
+				if got, want := mr.fcall.Count, uint32(len(data)); got != want {
+					t.Errorf("Fcall.Count is %v; want %v", got, want)
+				}
+				off += len(data)
+			}
+			if got, want := mr.err, tc.err; got != want {
+				t.Fatalf("got error %v; want %v", got, want)
+			}
+			if tc.err == nil {
+				if got := w.body.file.String(); got != body {
+					t.Errorf("body changed to %q before the clunk", got)
+				}
+				xfidclose(&Xfid{f: f, fs: mr})
+				if got, want := mr.err, tc.clunkerr; got != want {
+					t.Fatalf("got clunk error %v; want %v", got, want)
+				}
+			}
+			if got, want := w.body.file.String(), tc.body; got != want {
+				t.Errorf("got body %q; want %q", got, want)
+			}
+			if tc.err == nil && tc.clunkerr == nil {
+				w.Undo(true)
+				if got, want := w.body.file.String(), body; got != want {
+					t.Errorf("after undo got body %q; want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestXfidreadQWlines(t *testing.T) {
+	const body = "one\ntwo\nthree\nαβγ"
+
+	for _, tc := range []struct {
+		name   string // test name
+		offset uint64 // line to read from
+		count  uint32 // number of bytes to read
+		data   string // data in response
+	}{
+		{"All", 0, 100, body},
+		{"FromSecond", 1, 100, "two\nthree\nαβγ"},
+		{"WholeLines", 0, 12, "one\ntwo\n"},
+		{"LastLine", 3, 100, "αβγ"},
+		{"PastEnd", 4, 100, ""},
+		{"LongLine", 3, 5, "αβ"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := new(mockResponder)
+			w := NewWindow().initHeadless(nil)
+			w.col = new(Column)
+			w.body.file = file.MakeObservableEditableBuffer("", []rune(body))
+			xfidread(&Xfid{
+				f: &Fid{
+					qid: plan9.Qid{Path: QID(1, QWlines)},
+					w:   w,
+				},
+				fcall: plan9.Fcall{
+					Offset: tc.offset,
+					Count:  tc.count,
+				},
+				fs: mr,
+			})
+			if mr.err != nil {
+				t.Fatalf("got error %v; want nil", mr.err)
+			}
+			if got, want := string(mr.fcall.Data), tc.data; got != want {
+				t.Errorf("got data %q; want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestXfidwriteDeletedWin(t *testing.T) {
 	mr := new(mockResponder)
 	w := NewWindow().initHeadless(nil)
@@ -940,6 +1072,18 @@ func TestXfidwriteQWctl(t *testing.T) {
 		{ErrBadCtl, "font"},
 		{fmt.Errorf("nulls in font name"), "font /path/with/\x00nulls"},
 		{nil, "font /path/to/font"},
+		{ErrBadCtl, "encoding"},
+		{fmt.Errorf(`unknown encoding "ebcdic"`), "encoding ebcdic"},
+		{nil, "encoding latin-1"},
+		{nil, "crlf"},
+		{nil, "lf"},
+		{nil, "bom\nnobom"},
+		{nil, "begin\nname /Test/Write/Ctl\ncommit"},
+		{nil, "begin\nclean\nabort"},
+		{fmt.Errorf("no transaction"), "commit"},
+		{fmt.Errorf("no transaction"), "abort"},
+		{fmt.Errorf("transaction already begun"), "begin\nbegin"},
+		{fmt.Errorf("put in a transaction"), "begin\nput"},
 	} {
 		t.Run(fmt.Sprintf("Data=%q", tc.data), func(t *testing.T) {
 			mr := new(mockResponder)
@@ -1305,9 +1449,41 @@ func TestXfidreadQWaddr(t *testing.T) {
 	}
 }
 
+func TestXfidreadQWhistory(t *testing.T) {
+	w := NewWindow().initHeadless(nil)
+	w.col = new(Column)
+	w.body.file = file.MakeObservableEditableBuffer("", []rune("abc"))
+	w.body.file.Mark(1)
+	w.body.file.InsertAt(3, []rune("d"))
+	w.body.file.Undo(true)
+	w.body.file.Mark(2)
+	w.body.file.InsertAt(0, []rune("X"))
+
+	mr := new(mockResponder)
+	xfidread(&Xfid{
+		f: &Fid{
+			qid: plan9.Qid{Path: QID(1, QWhistory)},
+			w:   w,
+		},
+		fcall: plan9.Fcall{Count: 1024},
+		fs:    mr,
+	})
+	if mr.err != nil {
+		t.Fatalf("got error %v; want nil", mr.err)
+	}
+	got := string(mr.fcall.Data)
+	if want := w.body.file.UndoTree(); got != want {
+		t.Errorf("got data %q; want %q", got, want)
+	}
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " branch") || !strings.HasSuffix(lines[1], " current") {
+		t.Errorf("unexpected history %q", got)
+	}
+}
+
 func TestXfidreadQWctl(t *testing.T) {
 	const prewant = "          1          32          14           0           0           0 "
-	const postwant = "           0 "
+	const postwant = "           0 utf-8 "
 	want := prewant + edwoodtest.Plan9FontPath(edwoodtest.MockFontName) + postwant
 	if len(want) > 128 {
 		want = want[:128]